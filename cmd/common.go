@@ -37,15 +37,19 @@ func loadConfig() (*cnfg.Config, error) {
 }
 
 // loadPackages returns the registry contents, optionally forcing a refresh.
+//
+// The cache is not cleared before a forced refresh: it is what is served, with
+// a notice saying how old it is, when the registry cannot be reached.
 func loadPackages(config *cnfg.Config, forceRefresh bool) ([]*pkg.Package, error) {
+	if forceRefresh && config.Offline {
+		fmt.Fprintln(os.Stderr, "Offline: not refreshing, using the registry cache as it is")
+		forceRefresh = false
+	}
 	if forceRefresh {
-		if err := pkg.ClearCache(config); err != nil {
-			return nil, fmt.Errorf("clearing cache: %w", err)
-		}
 		fmt.Fprintln(os.Stderr, "Refreshing registry…")
 		packages, err := pkg.RefreshRegistry(config)
 		if len(packages) == 0 {
-			return nil, err
+			return nil, rateLimitHelp(err)
 		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "warning:", err)
@@ -55,7 +59,7 @@ func loadPackages(config *cnfg.Config, forceRefresh bool) ([]*pkg.Package, error
 
 	packages, err := pkg.LoadAllPackagesFromRegistry(config)
 	if len(packages) == 0 {
-		return nil, err
+		return nil, rateLimitHelp(err)
 	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "warning:", err)
@@ -63,6 +67,27 @@ func loadPackages(config *cnfg.Config, forceRefresh bool) ([]*pkg.Package, error
 	return packages, nil
 }
 
+// checkHeads asks upstream where the branches head installs follow are, so
+// HasUpdate can tell whether they moved. -f asks again however recently the
+// answer was cached. A remote that cannot be reached is a warning: the rest
+// of the command has nothing to do with it.
+func checkHeads(config *cnfg.Config, installed map[string]*pkg.Package, force bool) {
+	if err := pkg.CheckHeads(config, installed, force); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: checking followed branches:", err)
+	}
+}
+
+// rateLimitHelp answers a registry that refused for the rate limit the way
+// loadConfig answers a missing one: with what to do, not the request that
+// failed.
+func rateLimitHelp(err error) error {
+	var limited *pkg.RateLimitError
+	if errors.As(err, &limited) {
+		return errors.New(cnfg.RateLimitHelp(limited.Until, limited.Authenticated))
+	}
+	return err
+}
+
 // cliReporter prints installer events to the terminal. It is the plain-text
 // counterpart of the TUI's event log.
 func cliReporter(e pkg.Event) {
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lvim-tech/clipack/cnfg"
 	"github.com/lvim-tech/clipack/pkg"
@@ -109,21 +113,28 @@ func TestLoadPackagesUsesTheCache(t *testing.T) {
 	}
 }
 
-func TestLoadPackagesForceRefreshClearsTheCache(t *testing.T) {
+func TestLoadPackagesForceRefreshFallsBackToTheCache(t *testing.T) {
 	config := setupCmdTest(t)
-	// A registry that cannot be reached, so the refresh must fail rather than
-	// quietly serving the stale cache it was told to discard.
+	// A registry that cannot be reached: the refresh fails, and what was cached
+	// is served with a notice instead of nothing at all.
 	config.Registry.URL = "https://github.com/definitely/not-a-real-registry-xyz.git"
 	seedCache(t, config, demoPackage())
 
+	var packages []*pkg.Package
 	var err error
-	capture(t, func() { _, err = loadPackages(config, true) })
+	_, stderr := capture(t, func() { packages, err = loadPackages(config, true) })
 
-	if err == nil {
-		t.Fatal("loadPackages(force) error = nil, want the fetch failure reported")
+	if err != nil {
+		t.Fatalf("loadPackages(force) error = %v, want the cache served", err)
+	}
+	if len(packages) != 1 || packages[0].Name != "demo" {
+		t.Errorf("got %v, want the cached package", packages)
 	}
-	if exists(pkg.GetCacheFilePath(config)) {
-		t.Error("the cache survived a forced refresh")
+	if !strings.Contains(stderr, "offline, cache is") {
+		t.Errorf("stderr = %q, want the offline notice", stderr)
+	}
+	if !exists(pkg.GetCacheFilePath(config)) {
+		t.Error("a failed refresh discarded the cache")
 	}
 }
 
@@ -139,6 +150,26 @@ func TestLoadPackagesReportsAMissingRegistry(t *testing.T) {
 	}
 }
 
+func TestLoadPackagesExplainsARateLimit(t *testing.T) {
+	config := setupCmdTest(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	config.Registry.URL = "https://github.com/owner/registry.git"
+	config.Registry.TarballURL = server.URL
+	config.Registry.RawURL = server.URL
+
+	var err error
+	capture(t, func() { _, err = loadPackages(config, true) })
+
+	if err == nil || !strings.HasPrefix(err.Error(), "rate limited until ") || strings.Contains(err.Error(), server.URL) {
+		t.Errorf("loadPackages() error = %v, want what to do about the limit, not the request", err)
+	}
+}
+
 func TestCliReporter(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"github.com/lvim-tech/clipack/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	editReset bool
+	editYes   bool
+)
+
+// editCmd turns a registry entry into a local override and opens it in the
+// user's editor. The override lives in the overlay directory, so it survives
+// every registry refresh until it is reset; the command itself only has to
+// get it there, check it, and offer the rebuild.
+var editCmd = &cobra.Command{
+	Use:   "edit <package>",
+	Short: "Edit a package's definition locally and rebuild from it",
+	Long: `Copy a package's registry entry into the overlay directory
+(~/.config/clipack/packages by default), open it in $VISUAL or $EDITOR, and
+check it when the editor exits. An installed package can then be rebuilt from
+the edited definition.
+
+The override takes the place of the registry's entry from then on, through
+every refresh, until "clipack edit --reset <package>" removes it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		name := args[0]
+
+		installedMap, err := pkg.InstalledMap(config)
+		if err != nil {
+			return err
+		}
+		installed := installedMap[name]
+
+		if editReset {
+			removed, err := pkg.RemoveOverride(config, name)
+			if err != nil {
+				return err
+			}
+			for _, path := range removed {
+				fmt.Printf("Removed %s\n", path)
+			}
+			fmt.Printf("%s follows the registry again.\n", name)
+			if installed == nil {
+				return nil
+			}
+			entry, err := pkg.LoadPackageFromRegistry(name, config)
+			if err != nil {
+				fmt.Printf("%s is not in the registry; the installed build is left as it is.\n", name)
+				return nil
+			}
+			return offerRebuild(config, installed, entry, "the registry's definition")
+		}
+
+		// The registry's entry when there is one — it is what the override is
+		// meant to replace — and the installed manifest for a package the
+		// registry no longer has, which is otherwise impossible to rebuild.
+		entry, err := pkg.LoadPackageFromRegistry(name, config)
+		if err != nil {
+			if installed == nil {
+				return err
+			}
+			entry = installed
+		}
+
+		path, created, err := pkg.WriteOverride(config, entry)
+		if err != nil {
+			return err
+		}
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		installer := newInstaller(config)
+		var edited *pkg.Package
+		for {
+			if err := runEditor(path); err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(data, original) {
+				// A copy nobody changed would pin the package to today's
+				// registry entry for no reason, so it is not kept.
+				if created {
+					if err := os.Remove(path); err != nil {
+						return fmt.Errorf("no changes, but removing the unchanged copy failed: %w", err)
+					}
+				}
+				fmt.Println("No changes.")
+				return nil
+			}
+
+			edited, err = installer.ValidateOverride(data)
+			if err == nil && edited.Name != name {
+				err = fmt.Errorf("name is %q; an override of %s must keep its name", edited.Name, name)
+			}
+			if err == nil {
+				break
+			}
+
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			if !askYes("Edit again?") {
+				// Put back what was there, so the broken file is not left to
+				// warn on every start.
+				if err := restoreOverride(path, original, created); err != nil {
+					return fmt.Errorf("%s was not changed, but the edited file is still in place: %w", name, err)
+				}
+				return fmt.Errorf("%s was not changed", name)
+			}
+		}
+
+		fmt.Printf("Saved %s\n", path)
+		if installed == nil {
+			fmt.Printf("Run 'clipack install %s' to build it.\n", name)
+			return nil
+		}
+		edited.Origin = pkg.OverlayOrigin
+		edited.Overlay = path
+		return offerRebuild(config, installed, edited, "the edited definition")
+	},
+}
+
+// restoreOverride puts the override at path back the way it was before the
+// edit: gone when the edit created it, its original content otherwise.
+func restoreOverride(path string, original []byte, created bool) error {
+	if created {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, original, 0o644)
+}
+
+// offerRebuild asks whether to rebuild an installed package from def, and does
+// so with the method it was installed with.
+func offerRebuild(config *cnfg.Config, installed, def *pkg.Package, what string) error {
+	if !editYes && !askYes(fmt.Sprintf("Rebuild %s from %s?", def.Name, what)) {
+		return nil
+	}
+	method := installed.InstallMethod
+	if method == "" {
+		method = pkg.MethodVersion
+	}
+	if err := newInstaller(config).Update(def, method); err != nil {
+		return fmt.Errorf("rebuilding %s: %w", def.Name, err)
+	}
+	return nil
+}
+
+// runEditor opens path in $VISUAL or $EDITOR, falling back to vi. The variable
+// goes through the shell, because "code --wait" is as common a value as "nvim".
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	c := exec.Command("/bin/sh", "-c", editor+` "$1"`, "sh", path)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("running %s: %w", editor, err)
+	}
+	return nil
+}
+
+func init() {
+	editCmd.Flags().BoolVar(&editReset, "reset", false, "Remove the local override and follow the registry again")
+	editCmd.Flags().BoolVarP(&editYes, "yes", "y", false, "Rebuild without asking")
+	rootCmd.AddCommand(editCmd)
+}
@@ -105,12 +105,18 @@ func otherPackage() *pkg.Package {
 // package-level variables that survive between Execute calls, so without this a
 // flag set by one test would leak into the next.
 func resetFlags() {
-	installForceRefresh, installMethod, installYes = false, "", false
+	installForceRefresh, installMethod, installYes, installRef = false, "", false, ""
 	updateForceRefresh, updateAll, updateYes = false, false, false
-	removeYes = false
+	updateRebuildChanged, updateRef = false, ""
+	removeYes, removeForce = false, false
 	listForceRefresh, listInstalled, listUpdates = false, false, false
 	previewForceRefresh = false
+	editReset, editYes = false, false
+	lintJSON = false
+	signKey = ""
+	sourcesPrune = false
 	themeShowColors = false
+	offline = false
 }
 
 // execute runs the root command with the given arguments and returns whatever
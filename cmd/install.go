@@ -13,6 +13,7 @@ var (
 	installForceRefresh bool
 	installMethod       string
 	installYes          bool
+	installRef          string
 )
 
 // installCmd installs one or more packages by name. Without arguments it hands
@@ -22,6 +23,13 @@ var installCmd = &cobra.Command{
 	Short: "Install packages from the registry",
 	Long: `Install one or more packages by name.
 
+Packages they depend on are installed first, unless they already are.
+
+--ref builds one package at a ref of your choosing instead of the registry's: a
+tag or branch, or a commit (seven or more hex digits). The install is recorded
+as pinned to it, and updates leave it alone until 'clipack update <package>'
+returns it to the registry's ref.
+
 Called without arguments it opens the interactive interface, where packages can
 be browsed, filtered and installed.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -46,38 +54,85 @@ be browsed, filtered and installed.`,
 
 		installer := newInstaller(config)
 		method := installer.ResolveMethod(installMethod)
+		if err := pkg.CheckMethod(method); err != nil {
+			return err
+		}
+		if installRef != "" {
+			if len(args) != 1 {
+				return fmt.Errorf("--ref pins one package: name exactly one")
+			}
+			if installMethod != "" {
+				return fmt.Errorf("--ref and --install-method cannot be combined: the ref decides how it is built")
+			}
+		}
 
 		for _, name := range args {
-			selected := pkg.FindByName(packages, name)
-			if selected == nil {
+			if pkg.FindByName(packages, name) == nil {
 				return fmt.Errorf("package %q not found in registry", name)
 			}
+		}
 
-			// Installing over an existing install would leave the previous
-			// version's binaries, resource trees and man pages behind: only
-			// update knows what they were, because it reads the manifest first.
-			//
-			// Unless there is nothing to leave behind. A manifest outlives what
-			// it describes, and a package whose binary is gone is not installed
-			// in any sense the user cares about — refusing to install it, and
-			// pointing at update instead, is the least useful thing to say.
-			if prev := installed[name]; prev != nil {
-				if missing := prev.MissingArtifacts(config); len(missing) == 0 {
-					return fmt.Errorf("%s is already installed: use 'clipack update %s', or 'clipack remove %s' first", name, name, name)
-				} else {
-					fmt.Printf("%s is recorded as installed but %s is missing; reinstalling\n",
-						name, strings.Join(missing, ", "))
+		// Installing over an existing install would leave the previous
+		// version's binaries, resource trees and man pages behind: only
+		// update knows what they were, because it reads the manifest first.
+		//
+		// Unless there is nothing to leave behind. A manifest outlives what
+		// it describes, and a package whose binary is gone is not installed
+		// in any sense the user cares about — refusing to install it, and
+		// pointing at update instead, is the least useful thing to say.
+		//
+		// Checked before anything is built, since the named package's
+		// dependencies come first and would be installed for nothing.
+		for _, name := range args {
+			if prev := installed[name]; prev != nil && len(prev.MissingArtifacts(config)) == 0 {
+				return fmt.Errorf("%s is already installed: use 'clipack update %s', or 'clipack remove %s' first", name, name, name)
+			}
+		}
+
+		// What the named packages depend on is installed first, in an order
+		// where every build finds what it needs already there.
+		order, err := pkg.ResolveOrder(packages, args)
+		if err != nil {
+			return err
+		}
+		requested := make(map[string]bool, len(args))
+		for _, name := range args {
+			requested[name] = true
+		}
+
+		for _, selected := range order {
+			name := selected.Name
+			prev := installed[name]
+
+			if !requested[name] {
+				// A dependency that is already there is what was asked for.
+				if prev != nil && len(prev.MissingArtifacts(config)) == 0 {
+					continue
 				}
+				fmt.Printf("\n%s is needed by %s\n", name, strings.Join(neededBy(order, name), ", "))
+			}
+
+			// Whatever is recorded here is missing something: a whole install
+			// was refused above, or skipped as a dependency.
+			if prev != nil {
+				fmt.Printf("%s is recorded as installed but %s is missing; reinstalling\n",
+					name, strings.Join(prev.MissingArtifacts(config), ", "))
+			}
+
+			// Copy so the cached registry entry is not mutated by the
+			// installer. A pinned entry is a copy already.
+			copied := *selected
+			candidate, candidateMethod := &copied, method
+			if requested[name] && installRef != "" {
+				candidate, candidateMethod = selected.PinnedTo(installRef, installer.IsCommit(selected, installRef))
 			}
 
-			if !installYes && !confirmInstall(selected, method, config.Paths.Build) {
+			if !installYes && !confirmInstall(candidate, candidateMethod, config.Paths.Build) {
 				fmt.Println("Skipped", name)
 				continue
 			}
 
-			// Copy so the cached registry entry is not mutated by the installer.
-			candidate := *selected
-			if err := installer.Install(&candidate, method); err != nil {
+			if err := installer.Install(candidate, candidateMethod); err != nil {
 				return fmt.Errorf("installing %s: %w", name, err)
 			}
 		}
@@ -86,11 +141,28 @@ be browsed, filtered and installed.`,
 	},
 }
 
+// neededBy names the packages in order that depend on name directly.
+func neededBy(order []*pkg.Package, name string) []string {
+	var out []string
+	for _, p := range order {
+		for _, dep := range p.Dependencies() {
+			if dep == name {
+				out = append(out, p.Name)
+				break
+			}
+		}
+	}
+	return out
+}
+
 // confirmInstall prints a summary and asks for confirmation.
 func confirmInstall(p *pkg.Package, method, buildDir string) bool {
 	fmt.Printf("\n%s\n", p.Name)
 	fmt.Printf("  description : %s\n", p.Description)
 	fmt.Printf("  %-11s : %s\n", method, p.Ref(method))
+	if p.CustomRef != "" {
+		fmt.Printf("  pinned to   : %s, not the registry's ref\n", p.CustomRef)
+	}
 	fmt.Printf("  maintainer  : %s\n", p.Maintainer)
 	if p.License != "" {
 		fmt.Printf("  license     : %s\n", p.License)
@@ -105,7 +177,8 @@ func confirmInstall(p *pkg.Package, method, buildDir string) bool {
 
 func init() {
 	installCmd.Flags().BoolVarP(&installForceRefresh, "force-refresh", "f", false, "Force refresh of the registry cache")
-	installCmd.Flags().StringVarP(&installMethod, "install-method", "m", "", "Installation method: version or commit")
+	installCmd.Flags().StringVarP(&installMethod, "install-method", "m", "", "Installation method: version, commit, release or head")
 	installCmd.Flags().BoolVarP(&installYes, "yes", "y", false, "Do not ask for confirmation")
+	installCmd.Flags().StringVar(&installRef, "ref", "", "Build this tag, branch or commit instead of the registry's ref")
 	rootCmd.AddCommand(installCmd)
 }
@@ -37,9 +37,26 @@ var listCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		checkHeads(config, installedMap, listForceRefresh)
+
+		// Which registry a package came from only tells packages apart when
+		// there is more than one; with a single registry the column would
+		// repeat one name down the whole table. An entry from the overlay
+		// directory counts as one more, marked "local".
+		layered := len(config.RegistryLayers()) > 1
+		for _, p := range packages {
+			if p.Overlay != "" {
+				layered = true
+				break
+			}
+		}
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
-		fmt.Fprintln(w, "NAME\tVERSION\tSTATUS\tCATEGORY\tDESCRIPTION")
+		if layered {
+			fmt.Fprintln(w, "NAME\tVERSION\tSTATUS\tCATEGORY\tREGISTRY\tDESCRIPTION")
+		} else {
+			fmt.Fprintln(w, "NAME\tVERSION\tSTATUS\tCATEGORY\tDESCRIPTION")
+		}
 
 		shown := 0
 		for _, p := range packages {
@@ -53,6 +70,14 @@ var listCmd = &cobra.Command{
 				// The manifest is there but what it describes is not, which is
 				// worth saying plainly rather than reporting as installed.
 				status = "broken"
+			case installed != nil && installed.CustomRef != "":
+				// Built from a ref of the user's choosing, so the registry
+				// moving says nothing about it.
+				status = "pinned"
+			case pkg.NeedsRebuild(p, installed):
+				// Same ref, different entry: not an update, but the build on
+				// disk is not what the registry would make today.
+				status = "rebuild"
 			case installed != nil:
 				status = "installed"
 			}
@@ -60,7 +85,7 @@ var listCmd = &cobra.Command{
 			if listInstalled && installed == nil {
 				continue
 			}
-			if listUpdates && status != "update" {
+			if listUpdates && status != "update" && status != "rebuild" {
 				continue
 			}
 
@@ -69,8 +94,17 @@ var listCmd = &cobra.Command{
 				category = "-"
 			}
 
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
-				p.Name, p.Version, status, category, firstLine(p.Description))
+			if layered {
+				origin := p.Origin
+				if origin == "" {
+					origin = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					p.Name, p.Version, status, category, origin, firstLine(p.Description))
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					p.Name, p.Version, status, category, firstLine(p.Description))
+			}
 			shown++
 		}
 
@@ -94,6 +128,6 @@ func firstLine(s string) string {
 func init() {
 	listCmd.Flags().BoolVarP(&listForceRefresh, "force-refresh", "f", false, "Force refresh of the registry cache")
 	listCmd.Flags().BoolVarP(&listInstalled, "installed", "i", false, "Only show installed packages")
-	listCmd.Flags().BoolVarP(&listUpdates, "updates", "u", false, "Only show packages with updates")
+	listCmd.Flags().BoolVarP(&listUpdates, "updates", "u", false, "Only show packages with updates or a rebuild recommended")
 	rootCmd.AddCommand(listCmd)
 }
@@ -27,16 +27,15 @@ var previewCmd = &cobra.Command{
 			return err
 		}
 
-		if previewForceRefresh {
-			if err := pkg.ClearCache(config); err != nil {
-				return err
-			}
+		packages, err := loadPackages(config, previewForceRefresh)
+		if err != nil {
+			return err
 		}
 
 		name := args[0]
-		packageInfo, err := pkg.LoadPackageFromRegistry(name, config)
-		if err != nil {
-			return err
+		packageInfo := pkg.FindByName(packages, name)
+		if packageInfo == nil {
+			return fmt.Errorf("package %q not found in registry", name)
 		}
 
 		yamlData, err := yaml.Marshal(packageInfo)
@@ -50,6 +49,7 @@ var previewCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		checkHeads(config, installedMap, previewForceRefresh)
 
 		installed, ok := installedMap[packageInfo.Name]
 		if !ok {
@@ -66,7 +66,7 @@ var previewCmd = &cobra.Command{
 		fmt.Printf("install_method: %s\n", method)
 		fmt.Printf("installed_ref: %s\n", installed.Ref(method))
 		if pkg.HasUpdate(packageInfo, installed) {
-			fmt.Printf("available_ref: %s\n", packageInfo.Ref(method))
+			fmt.Printf("available_ref: %s\n", pkg.UpdateRef(packageInfo, installed, method))
 		}
 
 		// What of it is reachable as a command, which the registry record above
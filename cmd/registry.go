@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"github.com/lvim-tech/clipack/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lintJSON bool
+	signKey  string
+)
+
+// registryCmd groups the commands that work on a registry itself rather than on
+// the packages installed from it.
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Work on a package registry",
+	Args:  cobra.NoArgs,
+}
+
+// registryLintCmd checks every entry of a registry the way an install would,
+// without installing anything. It exits non-zero on errors, which is what makes
+// it usable as the registry's CI check.
+var registryLintCmd = &cobra.Command{
+	Use:   "lint [registry]",
+	Short: "Check every entry of a registry",
+	Long: `Check every entry of a registry for mistakes that loading skips over and
+an install would trip on: unknown fields, a missing clone URL, exposed names the
+package does not install, resources that escape their directory, man pages
+without a section, plaintext http downloads, binaries two packages both
+install, malformed toolchain requirements, dependencies the registry does not
+have or that form a cycle, and archive sources without a valid sha256.
+
+The argument takes every form registry.url does, so "clipack registry lint ."
+checks the checkout you are in. Without one, every configured registry is
+fetched and checked as it is live.
+
+Warnings are printed but only errors make the command fail.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var config *cnfg.Config
+		var layers []cnfg.RegistryLayer
+		if len(args) == 1 {
+			// A checkout needs no setup: CI runs this on a machine clipack has
+			// never been configured on. Resource targets are then checked
+			// against a scratch tree, which is also where a remote is cloned.
+			loaded, err := cnfg.LoadConfig()
+			if err != nil {
+				scratch, err := os.MkdirTemp("", "clipack-lint-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(scratch)
+				loaded = cnfg.NewDefaultConfig(scratch)
+			}
+			config = loaded
+			layers = []cnfg.RegistryLayer{{Name: cnfg.LayerName(args[0]), URL: args[0]}}
+		} else {
+			loaded, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			config = loaded
+			layers = config.RegistryLayers()
+		}
+
+		report := lintReport{}
+		for _, layer := range layers {
+			registry, err := pkg.NewRegistry(config, layer)
+			if err != nil {
+				return err
+			}
+			files, err := registry.Files()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", registry, err)
+			}
+			findings := pkg.LintRegistry(config, files)
+			errs, warnings := pkg.CountFindings(findings)
+			report.Registries = append(report.Registries, lintedRegistry{
+				Registry: registry.String(),
+				Files:    len(files),
+				Errors:   errs,
+				Warnings: warnings,
+				Findings: findings,
+			})
+			report.Errors += errs
+			report.Warnings += warnings
+		}
+
+		if lintJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			for _, r := range report.Registries {
+				if len(report.Registries) > 1 {
+					fmt.Printf("%s\n", r.Registry)
+				}
+				for _, f := range r.Findings {
+					fmt.Println(f)
+				}
+				fmt.Printf("%d file(s) checked: %d error(s), %d warning(s)\n", r.Files, r.Errors, r.Warnings)
+			}
+		}
+
+		if report.Errors > 0 {
+			return fmt.Errorf("lint found %d error(s)", report.Errors)
+		}
+		return nil
+	},
+}
+
+// registrySignCmd signs a registry checkout for the machines that trust its
+// key. It is the maintainer's half of registry.trusted_keys.
+var registrySignCmd = &cobra.Command{
+	Use:   "sign [dir]",
+	Short: "Sign a registry checkout",
+	Long: `Write registry.sha256, the digests of index.yaml and every file it lists,
+and sign it with --key: an ssh key through ssh-keygen -Y sign, or a minisign
+secret key through minisign. Commit both files with the entries they cover;
+an entry changed afterwards no longer verifies until the registry is signed
+again.
+
+Machines that list the public key under registry.trusted_keys refuse the
+registry unless the signature and every digest check out.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		if signKey == "" {
+			return fmt.Errorf("--key is required: the private key to sign with")
+		}
+
+		signed, err := pkg.SignRegistry(dir, cnfg.ExpandPath(signKey))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Signed %d file(s): %s\n", signed.Files, signed.Signature)
+		return nil
+	},
+}
+
+// registryDiffCmd shows what the last refresh changed, so a registry update
+// can be read before `update --all` builds it.
+var registryDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what the last registry refresh changed",
+	Long: `Compare the cached registry with the snapshot the refresh that wrote it
+replaced: packages added, removed and renamed, version and commit bumps, and
+every field that changed in each entry, with build steps and scripts shown as
+a diff.
+
+A refresh keeps the previous snapshot only when the registry changed, so this
+is always the last change, however many refreshes found nothing new since.
+Nothing is fetched; run clipack list -f first to pick up the latest.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		diffs, err := pkg.DiffSnapshots(config)
+		if err != nil {
+			return err
+		}
+
+		for i, d := range diffs {
+			if len(diffs) > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("%s\n", d.Layer)
+			}
+			printSnapshotDiff(d)
+		}
+		return nil
+	},
+}
+
+// printSnapshotDiff writes one layer's changes: a marker and the name per
+// entry — + added, - removed, > renamed, ~ changed — and under a change the
+// fields that differ.
+func printSnapshotDiff(d pkg.SnapshotDiff) {
+	if !d.Previous {
+		fmt.Println("No previous snapshot: the registry has not changed since it was first fetched.")
+		return
+	}
+	fmt.Printf("Since the snapshot fetched %s: %s\n", snapshotLabel(d.From), d.Summary())
+	if d.Empty() {
+		return
+	}
+	fmt.Println()
+
+	for _, p := range d.Added {
+		fmt.Printf("+ %-16s %s\n", p.Name, p.Version)
+	}
+	for _, p := range d.Removed {
+		fmt.Printf("- %-16s %s\n", p.Name, p.Version)
+	}
+	for _, c := range d.Renamed {
+		fmt.Printf("> %-16s renamed from %s\n", c.To.Name, c.From.Name)
+		printFieldChanges(c.Fields)
+	}
+	for _, c := range d.Changed {
+		ref := ""
+		if c.Bumped() {
+			ref = fmt.Sprintf("%s → %s", c.From.Version, c.To.Version)
+			if c.From.Version == c.To.Version {
+				ref = "commit " + shortRef(c.From.Commit) + " → " + shortRef(c.To.Commit)
+			}
+		}
+		fmt.Println(strings.TrimRight(fmt.Sprintf("~ %-16s %s", c.To.Name, ref), " "))
+		printFieldChanges(c.Fields)
+	}
+}
+
+func printFieldChanges(fields []pkg.FieldChange) {
+	for _, f := range fields {
+		if !f.Multiline() {
+			fmt.Printf("    %s: %q → %q\n", f.Field, f.From, f.To)
+			continue
+		}
+		fmt.Printf("    %s:\n", f.Field)
+		for _, line := range strings.Split(strings.TrimRight(f.Diff(), "\n"), "\n") {
+			fmt.Println("      " + line)
+		}
+	}
+}
+
+// snapshotLabel names a snapshot by when it was fetched and, when the
+// registry said, the commit it was read at.
+func snapshotLabel(h pkg.CacheHeader) string {
+	label := h.LastUpdated.Format("2006-01-02 15:04")
+	if h.Commit != "" {
+		label += " (" + shortRef(h.Commit) + ")"
+	}
+	return label
+}
+
+// shortRef trims a full SHA to something readable and leaves a tag alone.
+func shortRef(ref string) string {
+	if len(ref) == 40 {
+		return ref[:12]
+	}
+	return ref
+}
+
+// lintReport is the JSON shape of a lint run. Counts are totals over every
+// registry, so CI can read one field to decide.
+type lintReport struct {
+	Errors     int              `json:"errors"`
+	Warnings   int              `json:"warnings"`
+	Registries []lintedRegistry `json:"registries"`
+}
+
+type lintedRegistry struct {
+	Registry string        `json:"registry"`
+	Files    int           `json:"files"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	Findings []pkg.Finding `json:"findings"`
+}
+
+func init() {
+	registryLintCmd.Flags().BoolVar(&lintJSON, "json", false, "Print the findings as JSON")
+	registrySignCmd.Flags().StringVar(&signKey, "key", "", "Private key to sign with (ssh or minisign)")
+	registryCmd.AddCommand(registryLintCmd)
+	registryCmd.AddCommand(registrySignCmd)
+	registryCmd.AddCommand(registryDiffCmd)
+	rootCmd.AddCommand(registryCmd)
+}
@@ -2,13 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/lvim-tech/clipack/pkg"
 	"github.com/lvim-tech/clipack/tui"
 	"github.com/spf13/cobra"
 )
 
-var removeYes bool
+var (
+	removeYes   bool
+	removeForce bool
+)
 
 // removeCmd uninstalls packages. Removal is driven by the installed manifest
 // (configs/<name>/package.yaml), which is the only accurate record of what was
@@ -36,13 +40,27 @@ var removeCmd = &cobra.Command{
 			return nil
 		}
 
-		installer := newInstaller(config)
-
+		var named []*pkg.Package
 		for _, name := range args {
 			installed, ok := installedMap[name]
 			if !ok {
 				return fmt.Errorf("package %q is not installed", name)
 			}
+			named = append(named, installed)
+		}
+		// Dependents go before what they depend on, so removing a plugin and
+		// its host together is not refused halfway through.
+		named, err = pkg.SortByDependencies(named)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(named)
+
+		installer := newInstaller(config)
+		installer.IgnoreDependents = removeForce
+
+		for _, installed := range named {
+			name := installed.Name
 
 			fmt.Printf("\n%s (%s)\n", installed.Name, installed.Ref(installed.InstallMethod))
 			fmt.Printf("  %s\n\n", installed.Description)
@@ -63,5 +81,6 @@ var removeCmd = &cobra.Command{
 
 func init() {
 	removeCmd.Flags().BoolVarP(&removeYes, "yes", "y", false, "Do not ask for confirmation")
+	removeCmd.Flags().BoolVar(&removeForce, "force", false, "Remove packages other installed packages depend on")
 	rootCmd.AddCommand(removeCmd)
 }
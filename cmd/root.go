@@ -12,10 +12,13 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/lvim-tech/clipack/cnfg"
 	"github.com/lvim-tech/clipack/tui"
 	"github.com/spf13/cobra"
 )
 
+var offline bool
+
 var rootCmd = &cobra.Command{
 	Use:   "clipack",
 	Short: "Clipack is a package manager for CLI tools and their configurations.",
@@ -28,6 +31,15 @@ Run "clipack" with no arguments to open the interactive interface.`,
 	// Args are rejected here so a typo like "clipack instal bat" reports an
 	// unknown command instead of silently opening the TUI.
 	Args: cobra.NoArgs,
+	// --offline is passed on as the environment variable rather than threaded
+	// through every command: LoadConfig reads it wherever the configuration is
+	// loaded, the TUI included, and build steps see it too.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if offline {
+			return os.Setenv(cnfg.OfflineEnv, "1")
+		}
+		return nil
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return tui.Run()
 	},
@@ -52,5 +64,7 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false,
+		"Never touch the network: use the registry cache as it is, and refuse installs that download")
 	rootCmd.AddCommand(tuiCmd)
 }
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/lvim-tech/clipack/pkg"
+	"github.com/spf13/cobra"
+)
+
+// schemaCmd prints the JSON Schema of a registry package file. It needs no
+// configuration, so a registry's CI or an editor setup can call it anywhere.
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of a registry package file",
+	Long: `Print the JSON Schema of a registry package file, generated from the
+types clipack decodes it into.
+
+Editors that speak the YAML language server pick it up from a first line of
+
+    # yaml-language-server: $schema=` + pkg.SchemaID,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := pkg.PackageSchemaJSON()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/lvim-tech/clipack/cnfg"
 	"github.com/lvim-tech/clipack/pkg"
 	"github.com/spf13/cobra"
 )
@@ -11,6 +13,10 @@ var (
 	updateForceRefresh bool
 	updateAll          bool
 	updateYes          bool
+	// updateRebuildChanged applies the rebuilds pkg.NeedsRebuild recommends,
+	// which --all leaves alone: --all is for refs that moved.
+	updateRebuildChanged bool
+	updateRef            string
 )
 
 // updateCmd rebuilds installed packages whose registry entry has moved on.
@@ -23,7 +29,18 @@ var updateCmd = &cobra.Command{
 the registry.
 
 Without arguments the available updates are listed. Use --all to apply them, or
-name the packages to update explicitly.`,
+name the packages to update explicitly.
+
+A package whose registry entry changed while its version or commit did not is
+listed apart, as a rebuild. Use --rebuild-changed to apply those.
+
+A package installed with the head method follows its branch instead of the
+registry: it has an update when the branch moved upstream. Where the branch is
+is asked of the remote at most every 15 minutes, or now with -f.
+
+--ref rebuilds one package at a tag, branch or commit of your choosing, and pins
+it there: updates leave a package pinned to a custom ref alone. Naming it
+without --ref returns it to the registry's ref.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		config, err := loadConfig()
 		if err != nil {
@@ -39,54 +56,96 @@ name the packages to update explicitly.`,
 		if err != nil {
 			return err
 		}
+		checkHeads(config, installedMap, updateForceRefresh)
 		if len(installedMap) == 0 {
 			fmt.Println("No packages are installed.")
 			return nil
 		}
 
+		if updateRef != "" && (len(args) != 1 || updateAll) {
+			return fmt.Errorf("--ref pins one package: name exactly one")
+		}
+
 		// Collect the outdated packages once; both the listing and the update
-		// path work from the same set.
+		// path work from the same set. Rebuilds are kept apart: their ref did
+		// not move, so they are neither counted nor applied as updates.
 		type candidate struct {
 			registry  *pkg.Package
 			installed *pkg.Package
 		}
-		var outdated []candidate
+		var outdated, rebuilds, pinned []candidate
 		for _, p := range packages {
 			installed, ok := installedMap[p.Name]
-			if !ok || !pkg.HasUpdate(p, installed) {
+			if !ok {
 				continue
 			}
-			outdated = append(outdated, candidate{registry: p, installed: installed})
+			switch {
+			case installed.CustomRef != "":
+				pinned = append(pinned, candidate{registry: p, installed: installed})
+			case pkg.HasUpdate(p, installed):
+				outdated = append(outdated, candidate{registry: p, installed: installed})
+			case pkg.NeedsRebuild(p, installed):
+				rebuilds = append(rebuilds, candidate{registry: p, installed: installed})
+			}
 		}
 
 		// Named packages take precedence over the outdated set.
 		if len(args) > 0 {
-			installer := newInstaller(config)
+			var named []*pkg.Package
 			for _, name := range args {
 				registry := pkg.FindByName(packages, name)
-				installed := installedMap[name]
 				if registry == nil {
 					return fmt.Errorf("package %q not found in registry", name)
 				}
-				if installed == nil {
+				if installedMap[name] == nil {
 					return fmt.Errorf("package %q is not installed", name)
 				}
-				if !pkg.HasUpdate(registry, installed) {
-					fmt.Printf("%s is already up to date\n", name)
-					continue
-				}
+				named = append(named, registry)
+			}
+			// A dependency is rebuilt before what is built against it.
+			named, err = pkg.SortByDependencies(named)
+			if err != nil {
+				return err
+			}
+
+			installer := newInstaller(config)
+			for _, registry := range named {
+				name := registry.Name
+				installed := installedMap[name]
 
 				method := installed.InstallMethod
 				if method == "" {
 					method = pkg.MethodVersion
 				}
+				target := registry
 
-				fmt.Printf("\n%s: %s → %s\n", name, installed.Ref(method), registry.Ref(method))
-				if !updateYes && !askYes("Proceed with update?") {
+				switch {
+				case updateRef != "":
+					fmt.Printf("\n%s: %s → custom ref %s\n", name, installed.Ref(method), updateRef)
+					target, method = registry.PinnedTo(updateRef, installer.IsCommit(registry, updateRef))
+				case installed.CustomRef != "":
+					// Back to the registry's ref, under the method a fresh
+					// install would use: the pin decided the one recorded.
+					method = installer.ResolveMethod("")
+					fmt.Printf("\n%s: custom ref %s → %s\n", name, installed.CustomRef, registry.Ref(method))
+				case pkg.HasUpdate(registry, installed):
+					fmt.Printf("\n%s: %s → %s\n", name, installed.Ref(method), pkg.UpdateRef(registry, installed, method))
+				case pkg.NeedsRebuild(registry, installed) && updateRebuildChanged:
+					fmt.Printf("\n%s: rebuild at %s, its registry entry changed\n", name, installed.Ref(method))
+				case pkg.NeedsRebuild(registry, installed):
+					fmt.Printf("%s is up to date, but its registry entry changed since it was installed; "+
+						"rebuild it with --rebuild-changed\n", name)
+					continue
+				default:
+					fmt.Printf("%s is already up to date\n", name)
 					continue
 				}
 
-				candidatePkg := *registry
+				if !confirmUpdate(config, target, installed, method, "Proceed with update?") {
+					continue
+				}
+
+				candidatePkg := *target
 				if err := installer.Update(&candidatePkg, method); err != nil {
 					return fmt.Errorf("updating %s: %w", name, err)
 				}
@@ -94,35 +153,91 @@ name the packages to update explicitly.`,
 			return nil
 		}
 
-		if len(outdated) == 0 {
+		if len(pinned) > 0 {
+			method := newInstaller(config).ResolveMethod("")
+			fmt.Printf("\n%d pinned to a custom ref, not updated:\n\n", len(pinned))
+			for _, c := range pinned {
+				fmt.Printf("  %-16s %s (registry: %s)\n", c.registry.Name, c.installed.CustomRef, c.registry.Ref(method))
+			}
+			fmt.Println("\nRun 'clipack update <name>' to return one to the registry's ref.")
+		}
+		if len(outdated) == 0 && len(rebuilds) == 0 {
 			fmt.Println("All packages are up to date.")
 			return nil
 		}
 
-		fmt.Printf("\n%d update(s) available:\n\n", len(outdated))
-		for _, c := range outdated {
-			method := c.installed.InstallMethod
-			if method == "" {
-				method = pkg.MethodVersion
+		if len(outdated) > 0 {
+			fmt.Printf("\n%d update(s) available:\n\n", len(outdated))
+			for _, c := range outdated {
+				method := c.installed.InstallMethod
+				if method == "" {
+					method = pkg.MethodVersion
+				}
+				// The previous implementation printed installedPackages[0].Version
+				// here, i.e. the version of an unrelated package.
+				changed := ""
+				if len(pkg.ScriptChanges(c.registry, c.installed, method)) > 0 {
+					changed = "  (build scripts changed)"
+				}
+				fmt.Printf("  %-16s %s → %s%s\n", c.registry.Name, c.installed.Ref(method), pkg.UpdateRef(c.registry, c.installed, method), changed)
+			}
+		}
+		if len(rebuilds) > 0 {
+			fmt.Printf("\n%d rebuild(s) recommended, the registry entry changed but not the ref:\n\n", len(rebuilds))
+			for _, c := range rebuilds {
+				method := c.installed.InstallMethod
+				if method == "" {
+					method = pkg.MethodVersion
+				}
+				changed := ""
+				if len(pkg.ScriptChanges(c.registry, c.installed, method)) > 0 {
+					changed = "  (build scripts changed)"
+				}
+				fmt.Printf("  %-16s %s%s\n", c.registry.Name, c.installed.Ref(method), changed)
 			}
-			// The previous implementation printed installedPackages[0].Version
-			// here, i.e. the version of an unrelated package.
-			fmt.Printf("  %-16s %s → %s\n", c.registry.Name, c.installed.Ref(method), c.registry.Ref(method))
 		}
 		fmt.Println()
 
-		if !updateAll {
+		var apply []candidate
+		if updateAll {
+			apply = append(apply, outdated...)
+		}
+		if updateRebuildChanged {
+			apply = append(apply, rebuilds...)
+		}
+		if len(outdated) > 0 && !updateAll {
 			fmt.Println("Run 'clipack update --all' to apply, or 'clipack update <name>' for one package.")
+		}
+		if len(rebuilds) > 0 && !updateRebuildChanged {
+			fmt.Println("Run 'clipack update --rebuild-changed' to rebuild them.")
+		}
+		if len(apply) == 0 {
 			return nil
 		}
 
+		// Applied in dependency order, so a package built against another is
+		// rebuilt after it rather than against the version being replaced.
+		var registries []*pkg.Package
+		for _, c := range apply {
+			registries = append(registries, c.registry)
+		}
+		sorted, err := pkg.SortByDependencies(registries)
+		if err != nil {
+			return err
+		}
+		byName := make(map[string]candidate, len(apply))
+		for _, c := range apply {
+			byName[c.registry.Name] = c
+		}
+
 		installer := newInstaller(config)
-		for _, c := range outdated {
+		for _, p := range sorted {
+			c := byName[p.Name]
 			method := c.installed.InstallMethod
 			if method == "" {
 				method = pkg.MethodVersion
 			}
-			if !updateYes && !askYes(fmt.Sprintf("Update %s?", c.registry.Name)) {
+			if !confirmUpdate(config, c.registry, c.installed, method, fmt.Sprintf("Update %s?", c.registry.Name)) {
 				continue
 			}
 			candidatePkg := *c.registry
@@ -135,9 +250,34 @@ name the packages to update explicitly.`,
 	},
 }
 
+// confirmUpdate asks whether to go ahead with one update by method, showing
+// first how the shell it runs changed since the package was installed. -y
+// skips the question, except for a change to that shell when
+// review_script_changes is set: that is new code, and -y was given for
+// version bumps.
+func confirmUpdate(config *cnfg.Config, registry, installed *pkg.Package, method, question string) bool {
+	changes := pkg.ScriptChanges(registry, installed, method)
+	if len(changes) > 0 {
+		fmt.Println("The build scripts changed since it was installed:")
+		for _, c := range changes {
+			fmt.Printf("\n  %s\n", c.Field)
+			for _, line := range strings.Split(strings.TrimRight(c.Diff, "\n"), "\n") {
+				fmt.Println("    " + line)
+			}
+		}
+		fmt.Println()
+		if config.Options.ReviewScriptChanges {
+			return askYes(question)
+		}
+	}
+	return updateYes || askYes(question)
+}
+
 func init() {
 	updateCmd.Flags().BoolVarP(&updateForceRefresh, "force-refresh", "f", false, "Force refresh of the registry cache")
 	updateCmd.Flags().BoolVarP(&updateAll, "all", "a", false, "Update every outdated package")
 	updateCmd.Flags().BoolVarP(&updateYes, "yes", "y", false, "Do not ask for confirmation")
+	updateCmd.Flags().BoolVar(&updateRebuildChanged, "rebuild-changed", false, "Rebuild packages whose registry entry changed without the ref moving")
+	updateCmd.Flags().StringVar(&updateRef, "ref", "", "Rebuild the package at this tag, branch or commit instead of the registry's ref")
 	rootCmd.AddCommand(updateCmd)
 }
@@ -10,10 +10,13 @@ import (
 func TestCommandsAreRegistered(t *testing.T) {
 	want := []string{
 		"add-executables-path",
+		"edit",
 		"install",
 		"list",
 		"preview",
+		"registry",
 		"remove",
+		"schema",
 		"tui",
 		"update",
 		"update-config",
@@ -66,10 +69,13 @@ func TestFlagsAreDeclared(t *testing.T) {
 		{"update", "all", "a"},
 		{"update", "yes", "y"},
 		{"remove", "yes", "y"},
+		{"remove", "force", ""},
 		{"list", "force-refresh", "f"},
 		{"list", "installed", "i"},
 		{"list", "updates", "u"},
 		{"preview", "force-refresh", "f"},
+		{"edit", "reset", ""},
+		{"edit", "yes", "y"},
 	}
 
 	for _, tt := range tests {
@@ -85,10 +91,20 @@ func TestFlagsAreDeclared(t *testing.T) {
 	}
 }
 
+// --offline belongs to every command, so it is declared once on the root.
+func TestOfflineIsAGlobalFlag(t *testing.T) {
+	if rootCmd.PersistentFlags().Lookup("offline") == nil {
+		t.Error("the root command has no persistent --offline flag")
+	}
+	if findCommand(t, "install").InheritedFlags().Lookup("offline") == nil {
+		t.Error("install does not inherit --offline")
+	}
+}
+
 func TestArgumentValidators(t *testing.T) {
 	// These commands take no positional arguments, so a typo is reported rather
 	// than silently ignored.
-	for _, name := range []string{"list", "tui", "update-config", "add-executables-path"} {
+	for _, name := range []string{"list", "tui", "update-config", "add-executables-path", "schema"} {
 		t.Run(name, func(t *testing.T) {
 			cmd := findCommand(t, name)
 			if cmd.Args == nil {
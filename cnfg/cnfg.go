@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -38,6 +39,9 @@ var ErrNoRegistry = errors.New("no registry is configured")
 
 // RegistryConfig holds the configuration for the registry.
 type RegistryConfig struct {
+	// URL is where the registry lives. A github.com URL is read over HTTP as a
+	// single tarball; any other git remote is cloned with the git binary; and
+	// file:///path or a plain path reads a directory on disk as it stands.
 	URL string `yaml:"url"`
 	// RegistryRepoURL is optional and redundant: resolveRepo derives
 	// owner/repo from either field, so URL alone is enough. Kept because
@@ -46,9 +50,32 @@ type RegistryConfig struct {
 	// Token is an optional override. The token normally comes from the
 	// environment (see RegistryToken) so the secret need not sit in the file;
 	// a value here still wins when someone deliberately writes one.
-	Token          string        `yaml:"token,omitempty"`
+	Token string `yaml:"token,omitempty"`
+	// TokenCommand prints the token — `pass show github/clipack`, `gh auth
+	// token` — for a secret that should live in neither the file nor the
+	// environment. See CommandToken.
+	TokenCommand   string        `yaml:"token_command,omitempty"`
 	Branch         string        `yaml:"branch"`
 	UpdateInterval time.Duration `yaml:"update_interval"`
+	// Layers are further registries stacked over this one. See RegistryLayer.
+	Layers []RegistryLayer `yaml:"layers,omitempty"`
+
+	// TarballURL and RawURL replace https://codeload.github.com and
+	// https://raw.githubusercontent.com for a GitHub registry, for a network
+	// that only reaches GitHub through a mirror. The paths under them are
+	// GitHub's.
+	TarballURL string `yaml:"tarball_url,omitempty"`
+	RawURL     string `yaml:"raw_url,omitempty"`
+	// Proxy is the proxy every download goes through, overriding
+	// HTTPS_PROXY and friends.
+	Proxy string `yaml:"proxy,omitempty"`
+	// CABundle is a PEM file of certificates to trust on top of the system's,
+	// for a mirror or proxy signed by a private CA.
+	CABundle string `yaml:"ca_bundle,omitempty"`
+	// TrustedKeys are the public keys — OpenSSH or minisign, written out or
+	// as the path of a .pub file — a registry must be signed with. Empty
+	// accepts an unsigned registry, as before signing existed.
+	TrustedKeys []string `yaml:"trusted_keys,omitempty"`
 }
 
 // RegistryToken returns the token to authenticate registry requests with.
@@ -56,7 +83,9 @@ type RegistryConfig struct {
 // and then GITHUB_TOKEN environment variables. That keeps a private registry
 // reachable without the secret ever touching the config file — e.g. a shell
 // helper that decrypts the token from a password store into GH_TOKEN for the
-// session. Empty means anonymous, which is all a public registry needs.
+// session. Empty means anonymous, which is all a public registry needs — or,
+// with registry.token_command set, that the token is to be asked for when a
+// request needs one (see CommandToken).
 func (c *Config) RegistryToken() string {
 	if c.Registry.Token != "" {
 		return c.Registry.Token
@@ -67,6 +96,16 @@ func (c *Config) RegistryToken() string {
 	return os.Getenv("GITHUB_TOKEN")
 }
 
+// Network is how clipack reaches the network: the proxy and CA bundle from
+// the registry section, which every download uses, not only the registry's.
+func (c *Config) Network() utils.Network {
+	n := utils.Network{Proxy: c.Registry.Proxy}
+	if c.Registry.CABundle != "" {
+		n.CABundle = ExpandPath(c.Registry.CABundle)
+	}
+	return n
+}
+
 // RegistryHelp is the instruction printed whenever no registry is configured.
 // One field, one example — registryRepoURL is derived, and a private registry
 // is authenticated from the environment rather than a token written to the file.
@@ -84,6 +123,7 @@ Name one in %s:
         branch: main
 
 A registry is a git repository with an index.yaml listing package definitions.
+Any git remote works, and so does a directory on disk (url: file:///path).
 
 For a private registry, export a token instead of writing it to the file:
 
@@ -92,6 +132,21 @@ For a private registry, export a token instead of writing it to the file:
 (a token under registry.token still works as an override).`, path)
 }
 
+// RateLimitHelp is the instruction printed when the registry's server refused
+// a request for the rate limit: when it lifts, and — for an anonymous client,
+// which GitHub allows a fraction of an authenticated one's requests — how to
+// get a higher limit. A zero until means the server did not say.
+func RateLimitHelp(until time.Time, authenticated bool) string {
+	msg := "rate limited"
+	if !until.IsZero() {
+		msg += " until " + until.Local().Format("15:04")
+	}
+	if authenticated {
+		return msg + ", the token's limit is spent"
+	}
+	return msg + ", set GH_TOKEN to raise the limit"
+}
+
 // PathsConfig holds the configuration for various paths used in the application.
 type PathsConfig struct {
 	Base     string `yaml:"base"`
@@ -110,6 +165,11 @@ type PathsConfig struct {
 	// time, in a directory that is already on PATH — and a package says which
 	// of its binaries deserve to be there with install.expose.
 	Expose string `yaml:"expose,omitempty"`
+	// Packages is the overlay directory: package definitions kept on this
+	// machine, <category>/<name>.yaml, that replace the registry's entry of
+	// the same name or add one it does not have. Like Expose it belongs to
+	// the user, and it lives beside config.yaml rather than under Base.
+	Packages string `yaml:"packages,omitempty"`
 }
 
 // OptionsConfig holds the configuration for various options in the application.
@@ -118,6 +178,10 @@ type OptionsConfig struct {
 	BackupConfigs bool   `yaml:"backup_configs"`
 	CleanupBuild  bool   `yaml:"cleanup_build"`
 	InstallMethod string `yaml:"install_method"`
+	// ReviewScriptChanges asks before an update whose install.steps,
+	// install.setup or post-install scripts changed, even under -y, which
+	// otherwise answers every question.
+	ReviewScriptChanges bool `yaml:"review_script_changes"`
 }
 
 // Config holds the entire configuration structure.
@@ -126,6 +190,26 @@ type Config struct {
 	Paths    PathsConfig    `yaml:"paths"`
 	Options  OptionsConfig  `yaml:"options"`
 	Theme    Theme          `yaml:"theme"`
+
+	// Offline forbids network access for this run. It is never read from or
+	// written to config.yaml: having no network is a property of the moment,
+	// not of the machine, and it comes from --offline or OfflineEnv.
+	Offline bool `yaml:"-"`
+}
+
+// OfflineEnv turns offline mode on for every command, the same as --offline.
+const OfflineEnv = "CLIPACK_OFFLINE"
+
+// OfflineFromEnv reports whether OfflineEnv asks for offline mode. Any value
+// but an empty one or a false boolean does: CLIPACK_OFFLINE=yes means what it
+// says.
+func OfflineFromEnv() bool {
+	value := strings.TrimSpace(os.Getenv(OfflineEnv))
+	if value == "" {
+		return false
+	}
+	on, err := strconv.ParseBool(value)
+	return on || err != nil
 }
 
 // ConfigDir returns the directory holding clipack's own configuration.
@@ -177,6 +261,16 @@ func DefaultExposeDir() string {
 	return filepath.Join(home, ".local", "bin")
 }
 
+// DefaultOverlayDir is where local package definitions are read from:
+// ~/.config/clipack/packages.
+func DefaultOverlayDir() string {
+	dir, err := ConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "packages")
+}
+
 // ExpandPath resolves ~ and makes the path absolute.
 func ExpandPath(path string) string {
 	path = strings.TrimSpace(path)
@@ -213,7 +307,8 @@ func NewDefaultConfig(installDir string) *Config {
 			Man:      filepath.Join(installDir, "man"),
 			// Outside installDir on purpose: this one is the user's own bin
 			// directory, not a directory clipack manages.
-			Expose: DefaultExposeDir(),
+			Expose:   DefaultExposeDir(),
+			Packages: DefaultOverlayDir(),
 		},
 		Options: OptionsConfig{
 			AutoSymlink:   true,
@@ -222,7 +317,8 @@ func NewDefaultConfig(installDir string) *Config {
 			InstallMethod: "version",
 		},
 		// Written out explicitly so the knob is discoverable in the file.
-		Theme: Theme{Name: DefaultThemeName},
+		Theme:   Theme{Name: DefaultThemeName},
+		Offline: OfflineFromEnv(),
 	}
 }
 
@@ -293,15 +389,22 @@ func LoadConfig() (*Config, error) {
 		}
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
+	config.Offline = OfflineFromEnv()
 
 	return &config, nil
 }
 
 // validateConfig fills in defaults and rejects unusable configurations.
 func validateConfig(config *Config) error {
-	if config.Registry.URL == "" && config.Registry.RegistryRepoURL == "" {
+	if len(config.RegistryLayers()) == 0 {
 		return ErrNoRegistry
 	}
+	if err := validateLayers(config); err != nil {
+		return err
+	}
+	if err := validateNetwork(&config.Registry); err != nil {
+		return err
+	}
 	if config.Registry.Branch == "" {
 		config.Registry.Branch = DefaultBranch
 	}
@@ -328,6 +431,16 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("path %q must be absolute, got %q", "expose", config.Paths.Expose)
 	}
 
+	// The overlay directory follows the same rules, for the same reasons.
+	if config.Paths.Packages == "" {
+		config.Paths.Packages = DefaultOverlayDir()
+	} else if strings.HasPrefix(config.Paths.Packages, "~") {
+		config.Paths.Packages = ExpandPath(config.Paths.Packages)
+	}
+	if config.Paths.Packages != "" && !filepath.IsAbs(config.Paths.Packages) {
+		return fmt.Errorf("path %q must be absolute, got %q", "packages", config.Paths.Packages)
+	}
+
 	named := map[string]string{
 		"base":     config.Paths.Base,
 		"registry": config.Paths.Registry,
@@ -409,6 +522,7 @@ func UpdateConfig() error {
 		// this one is not derived from it at all, so repointing the install
 		// tree must not drag a customised expose directory along with it.
 		config.Paths.Expose = existing.Paths.Expose
+		config.Paths.Packages = existing.Paths.Packages
 	}
 
 	if err := config.Save(); err != nil {
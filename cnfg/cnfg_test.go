@@ -171,6 +171,62 @@ func TestSaveAndLoadRoundTrip(t *testing.T) {
 	}
 }
 
+func TestOfflineFromEnv(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":      false,
+		"0":     false,
+		"false": false,
+		"1":     true,
+		"true":  true,
+		"yes":   true,
+	} {
+		t.Setenv(OfflineEnv, value)
+		if got := OfflineFromEnv(); got != want {
+			t.Errorf("%s=%q: OfflineFromEnv() = %v, want %v", OfflineEnv, value, got, want)
+		}
+	}
+}
+
+func TestRateLimitHelp(t *testing.T) {
+	until := time.Date(2026, 5, 1, 14, 32, 0, 0, time.Local)
+
+	if got, want := RateLimitHelp(until, false), "rate limited until 14:32, set GH_TOKEN to raise the limit"; got != want {
+		t.Errorf("anonymous: got %q, want %q", got, want)
+	}
+	// A token is already the higher limit; telling its owner to set one is
+	// advice they cannot act on.
+	if got := RateLimitHelp(until, true); strings.Contains(got, "GH_TOKEN") {
+		t.Errorf("authenticated: got %q, want no advice to set a token", got)
+	}
+	if got := RateLimitHelp(time.Time{}, false); strings.Contains(got, "until") {
+		t.Errorf("no reset time: got %q, want none made up", got)
+	}
+}
+
+func TestLoadConfigReadsOfflineFromTheEnvironment(t *testing.T) {
+	withHome(t)
+	config := NewDefaultConfig(filepath.Join(t.TempDir(), "packages"))
+	config.Registry.URL = "https://github.com/owner/repo.git"
+	config.Offline = true
+	if err := config.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(OfflineEnv, "")
+	loaded, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Offline {
+		t.Error("Offline was saved to the file; it belongs to one run")
+	}
+
+	t.Setenv(OfflineEnv, "1")
+	if loaded, err = LoadConfig(); err != nil || !loaded.Offline {
+		t.Errorf("LoadConfig() = %v, %v; want Offline from %s", loaded, err, OfflineEnv)
+	}
+}
+
 func TestExistsAndConfigPath(t *testing.T) {
 	withHome(t)
 
@@ -278,6 +334,16 @@ func TestValidateConfigErrors(t *testing.T) {
 			mutate: func(c *Config) { c.Paths.Man = "relative/man" },
 			want:   `"man" must be absolute`,
 		},
+		{
+			name:   "mirror without a scheme",
+			mutate: func(c *Config) { c.Registry.TarballURL = "mirror.example.com/codeload" },
+			want:   "registry.tarball_url",
+		},
+		{
+			name:   "proxy with an unknown scheme",
+			mutate: func(c *Config) { c.Registry.Proxy = "ftp://proxy.example.com" },
+			want:   "http, https or socks5",
+		},
 	}
 
 	for _, tt := range tests {
@@ -613,6 +679,36 @@ func TestExposePathDefaultsAndExpands(t *testing.T) {
 	}
 }
 
+func TestOverlayPathDefaultsAndExpands(t *testing.T) {
+	home := withHome(t)
+
+	config := &Config{
+		Registry: RegistryConfig{URL: "https://github.com/owner/repo.git"},
+		Paths: PathsConfig{
+			Base: "/a", Registry: "/a/r", Bin: "/a/b", Configs: "/a/c", Build: "/a/bu", Man: "/a/m",
+		},
+	}
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	if want := filepath.Join(home, ".config", "clipack", "packages"); config.Paths.Packages != want {
+		t.Errorf("Paths.Packages = %q, want it defaulted to %q", config.Paths.Packages, want)
+	}
+
+	config.Paths.Packages = "~/clipack-local"
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validateConfig() error = %v", err)
+	}
+	if got := filepath.Join(home, "clipack-local"); config.Paths.Packages != got {
+		t.Errorf("Paths.Packages = %q, want %q", config.Paths.Packages, got)
+	}
+
+	config.Paths.Packages = "relative/packages"
+	if err := validateConfig(config); err == nil {
+		t.Error("validateConfig() error = nil, want a relative overlay path rejected")
+	}
+}
+
 func TestExposePathIsNotCreatedWithTheManagedTree(t *testing.T) {
 	home := withHome(t)
 	config := NewDefaultConfig(filepath.Join(t.TempDir(), "packages"))
@@ -0,0 +1,145 @@
+package cnfg
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// RegistryLayer is one registry in the stack clipack reads packages from.
+//
+// A team rarely wants a registry of its own INSTEAD of the public one: it wants
+// the public one, plus its internal tools, plus whatever one person is trying
+// out. So registry.url stays the bottom layer and registry.layers stacks more
+// on top, and a package is taken from the highest layer that defines it. That
+// makes a layer equally good for adding packages and for replacing an entry
+// that is wrong for this team's machines.
+//
+//	registry:
+//	    url: https://github.com/lvim-tech/clipack-registry.git
+//	    layers:
+//	        - name: team
+//	          url: https://git.example.com/tools/clipack-registry.git
+//	        - url: ~/src/my-registry
+type RegistryLayer struct {
+	// Name is what the layer is called in the interface and the cache file.
+	// Empty derives it from the URL's last path element.
+	Name string `yaml:"name,omitempty"`
+	// URL takes every form registry.url does.
+	URL string `yaml:"url"`
+	// Branch defaults to the one registry.branch names.
+	Branch string `yaml:"branch,omitempty"`
+}
+
+// RegistryLayers returns every configured registry, lowest priority first, with
+// names and branches filled in. The first is registry.url itself, when it is
+// set; an empty result means no registry is configured at all.
+func (c *Config) RegistryLayers() []RegistryLayer {
+	branch := c.Registry.Branch
+	if branch == "" {
+		branch = DefaultBranch
+	}
+
+	var layers []RegistryLayer
+	base := c.Registry.URL
+	if base == "" {
+		base = c.Registry.RegistryRepoURL
+	}
+	if base != "" {
+		layers = append(layers, RegistryLayer{Name: LayerName(base), URL: base, Branch: branch})
+	}
+
+	for _, layer := range c.Registry.Layers {
+		if layer.Name == "" {
+			layer.Name = LayerName(layer.URL)
+		}
+		if layer.Branch == "" {
+			layer.Branch = branch
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+// LayerName derives a layer's name from its URL: the last path element, without
+// a .git suffix. "https://github.com/lvim-tech/clipack-registry.git" is
+// "clipack-registry", "git@host:team/tools.git" is "tools" and "~/src/reg" is
+// "reg". Anything outside [a-z0-9._-] becomes a dash, because the name is also
+// part of a file name.
+func LayerName(url string) string {
+	trimmed := strings.TrimRight(strings.TrimSpace(url), "/")
+	// The API form ends in /contents, which names nothing.
+	trimmed = strings.TrimSuffix(trimmed, "/contents")
+	if i := strings.LastIndex(trimmed, ":"); i > strings.LastIndex(trimmed, "/") {
+		trimmed = trimmed[i+1:]
+	}
+	name := strings.TrimSuffix(path.Base(trimmed), ".git")
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	if out := strings.Trim(b.String(), "-."); out != "" {
+		return out
+	}
+	return "registry"
+}
+
+// validateLayers rejects a layer without a URL and two layers sharing a name.
+// The second is not cosmetic: the name keys the layer's cache, so two layers
+// with one name would overwrite each other's packages on every refresh.
+func validateLayers(config *Config) error {
+	seen := map[string]string{}
+	for i, layer := range config.RegistryLayers() {
+		if strings.TrimSpace(layer.URL) == "" {
+			return fmt.Errorf("registry layer %d has no url", i)
+		}
+		if LayerName(layer.Name) != layer.Name {
+			return fmt.Errorf("registry layer name %q may only contain a-z, 0-9, '.', '_' and '-'", layer.Name)
+		}
+		if other, ok := seen[layer.Name]; ok {
+			return fmt.Errorf("registries %s and %s are both named %q; set a different name: on one of them",
+				other, layer.URL, layer.Name)
+		}
+		seen[layer.Name] = layer.URL
+	}
+	return nil
+}
+
+// validateNetwork checks the endpoints and the proxy are URLs clipack can use,
+// so a typo is reported when the configuration loads rather than as a failed
+// download later. The CA bundle is read when the first request is made.
+func validateNetwork(r *RegistryConfig) error {
+	endpoints := []struct{ key, value string }{
+		{"tarball_url", r.TarballURL},
+		{"raw_url", r.RawURL},
+	}
+	for _, e := range endpoints {
+		if e.value == "" {
+			continue
+		}
+		u, err := url.Parse(e.value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("registry.%s %q must be an http or https URL", e.key, e.value)
+		}
+	}
+
+	if r.Proxy != "" {
+		u, err := url.Parse(r.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("registry.proxy %q must be a URL, like http://proxy.example.com:3128", r.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("registry.proxy %q: the scheme must be http, https or socks5", r.Proxy)
+		}
+	}
+	return nil
+}
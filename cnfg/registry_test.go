@@ -0,0 +1,105 @@
+package cnfg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLayerName(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/lvim-tech/clipack-registry.git", "clipack-registry"},
+		{"https://github.com/lvim-tech/clipack-registry/", "clipack-registry"},
+		{"https://api.github.com/repos/lvim-tech/clipack-registry/contents", "clipack-registry"},
+		{"git@git.example.com:team/tools.git", "tools"},
+		{"git@git.example.com:tools.git", "tools"},
+		{"file:///srv/Team Registry", "team-registry"},
+		{"~/src/reg", "reg"},
+		{"", "registry"},
+	}
+
+	for _, tt := range tests {
+		if got := LayerName(tt.url); got != tt.want {
+			t.Errorf("LayerName(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryLayersPutsTheBaseRegistryFirst(t *testing.T) {
+	config := &Config{Registry: RegistryConfig{
+		URL:    "https://github.com/lvim-tech/clipack-registry.git",
+		Branch: "develop",
+		Layers: []RegistryLayer{
+			{Name: "team", URL: "https://git.example.com/team/registry.git"},
+			{URL: "~/src/mine", Branch: "wip"},
+		},
+	}}
+
+	layers := config.RegistryLayers()
+	if len(layers) != 3 {
+		t.Fatalf("got %d layers, want 3", len(layers))
+	}
+	if layers[0].Name != "clipack-registry" || layers[0].Branch != "develop" {
+		t.Errorf("layers[0] = %+v, want the base registry on its branch", layers[0])
+	}
+	// An unset branch follows registry.branch; a set one is kept.
+	if layers[1].Name != "team" || layers[1].Branch != "develop" {
+		t.Errorf("layers[1] = %+v, want team on develop", layers[1])
+	}
+	if layers[2].Name != "mine" || layers[2].Branch != "wip" {
+		t.Errorf("layers[2] = %+v, want a derived name and its own branch", layers[2])
+	}
+}
+
+func TestRegistryLayersWithoutABaseRegistry(t *testing.T) {
+	config := &Config{Registry: RegistryConfig{
+		Layers: []RegistryLayer{{URL: "/srv/registry"}},
+	}}
+
+	layers := config.RegistryLayers()
+	if len(layers) != 1 || layers[0].URL != "/srv/registry" {
+		t.Errorf("layers = %+v, want the one layer alone", layers)
+	}
+	if err := validateLayers(config); err != nil {
+		t.Errorf("validateLayers() error = %v, want layers alone to be a valid setup", err)
+	}
+}
+
+func TestValidateLayers(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers []RegistryLayer
+		want   string
+	}{
+		{
+			name:   "two layers with one name",
+			layers: []RegistryLayer{{URL: "https://git.example.com/a/clipack-registry.git"}},
+			want:   "both named",
+		},
+		{
+			name:   "a layer without a url",
+			layers: []RegistryLayer{{Name: "team"}},
+			want:   "has no url",
+		},
+		{
+			name:   "a name that cannot be a file name",
+			layers: []RegistryLayer{{Name: "Team/One", URL: "/srv/registry"}},
+			want:   "may only contain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Registry: RegistryConfig{
+				URL:    "https://github.com/lvim-tech/clipack-registry.git",
+				Layers: tt.layers,
+			}}
+			err := validateLayers(config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("validateLayers() error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
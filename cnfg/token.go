@@ -0,0 +1,82 @@
+package cnfg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// commandToken is what one token command printed, or why it printed nothing.
+type commandToken struct {
+	token string
+	err   error
+}
+
+var (
+	tokensMu sync.Mutex
+	// tokens holds each command's result for the life of the process, so a
+	// secret store that prompts for a passphrase does it once, not once per
+	// request, and a command that failed is not run again to fail the same
+	// way.
+	tokens = map[string]commandToken{}
+)
+
+// HasTokenCommand reports whether a token can be had from
+// registry.token_command when the environment and the file hold none.
+func (c *Config) HasTokenCommand() bool {
+	return strings.TrimSpace(c.Registry.TokenCommand) != ""
+}
+
+// CommandToken runs registry.token_command and returns the first line it
+// printed. It is run the first time it is asked for — which is the first
+// request that was refused without a token — and never again in the process.
+//
+// The token is never part of an error: a failure says which command failed
+// and what it wrote to stderr, never what it wrote to stdout.
+func (c *Config) CommandToken() (string, error) {
+	command := strings.TrimSpace(c.Registry.TokenCommand)
+	if command == "" {
+		return "", errors.New("registry.token_command is not set")
+	}
+
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	if t, ok := tokens[command]; ok {
+		return t.token, t.err
+	}
+	token, err := runTokenCommand(command)
+	tokens[command] = commandToken{token: token, err: err}
+	return token, err
+}
+
+func runTokenCommand(command string) (string, error) {
+	shell, args := "/bin/sh", []string{"-c", command}
+	if runtime.GOOS == "windows" {
+		shell, args = "cmd", []string{"/C", command}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(shell, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("registry.token_command %q: %w: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("registry.token_command %q: %w", command, err)
+	}
+
+	// pass and the like print the secret on the first line and metadata
+	// after it.
+	token, _, _ := strings.Cut(stdout.String(), "\n")
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("registry.token_command %q printed no token", command)
+	}
+	return token, nil
+}
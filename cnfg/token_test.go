@@ -0,0 +1,83 @@
+package cnfg
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// tokenCommand returns a command that prints output and counts its runs in a
+// file, which it returns too. The path makes the command unique to the test,
+// so no other test's cached result answers for it.
+func tokenCommand(t *testing.T, script string) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the command under test is POSIX shell")
+	}
+	runs := filepath.Join(t.TempDir(), "runs")
+	return "echo run >> '" + runs + "'; " + script, runs
+}
+
+func runCount(t *testing.T, runs string) int {
+	t.Helper()
+	data, err := os.ReadFile(runs)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "run\n")
+}
+
+func TestCommandTokenIsRunOnce(t *testing.T) {
+	command, runs := tokenCommand(t, `printf 'ghp_secret\nlogin: someone\n'`)
+	config := &Config{Registry: RegistryConfig{TokenCommand: command}}
+
+	for range 3 {
+		token, err := config.CommandToken()
+		if err != nil {
+			t.Fatalf("CommandToken() error = %v", err)
+		}
+		if token != "ghp_secret" {
+			t.Fatalf("token = %q, want the first line", token)
+		}
+	}
+	if n := runCount(t, runs); n != 1 {
+		t.Errorf("the command ran %d times, want once for the process", n)
+	}
+}
+
+func TestCommandTokenErrorDoesNotCarryTheToken(t *testing.T) {
+	command, runs := tokenCommand(t, `printf '%s_%s\n' ghp secret; echo 'not in the password store' >&2; exit 1`)
+	config := &Config{Registry: RegistryConfig{TokenCommand: command}}
+
+	_, err := config.CommandToken()
+	if err == nil {
+		t.Fatal("CommandToken() error = nil, want the failure")
+	}
+	if strings.Contains(err.Error(), "ghp_secret") {
+		t.Errorf("error = %q, want stdout kept out of it", err)
+	}
+	if !strings.Contains(err.Error(), "not in the password store") {
+		t.Errorf("error = %q, want what the command said on stderr", err)
+	}
+
+	// A failure is remembered too: a prompt that was dismissed is not put up
+	// again for every request that follows.
+	config.CommandToken()
+	if n := runCount(t, runs); n != 1 {
+		t.Errorf("the command ran %d times, want once", n)
+	}
+}
+
+func TestCommandTokenThatPrintsNothing(t *testing.T) {
+	command, _ := tokenCommand(t, "true")
+	config := &Config{Registry: RegistryConfig{TokenCommand: command}}
+
+	if _, err := config.CommandToken(); err == nil || !strings.Contains(err.Error(), "printed no token") {
+		t.Errorf("CommandToken() error = %v, want no token reported", err)
+	}
+}
@@ -0,0 +1,547 @@
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+)
+
+// Archive is a source published as a release archive rather than a git
+// repository: clipack downloads it, checks it against SHA256 and unpacks it
+// into the build directory before the steps run.
+//
+// The checksum is not optional. A clone is pinned by the ref it checks out; an
+// archive at a URL is whatever the server hands over that day, and a release
+// asset can be replaced after the fact. The digest in the registry is what
+// makes the download the thing that was reviewed.
+type Archive struct {
+	// URL ends in .tar.gz, .tgz, .tar.xz, .txz or .zip, which is how the
+	// format is told. https:// or, for a local mirror, file://.
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// Bounds on a source or release archive. They are the registry tarball's
+// reasoning with a project's numbers: a source release of a large project is
+// hundreds of megabytes, where the registry is YAML, so its limits would turn
+// away archives that are what they claim to be. The inflated bound is still
+// what stops an archive that expands without end.
+const (
+	maxArchiveBytes         = 1 << 30 // 1 GiB, compressed
+	maxArchiveInflatedBytes = 4 << 30 // 4 GiB, decompressed
+)
+
+// Archives holds an archive per ref, so the version and the commit of one
+// package can each be built from their own tarball — or only one of them,
+// with the other still cloned.
+type Archives struct {
+	Version Archive `yaml:"version,omitempty"`
+	Commit  Archive `yaml:"commit,omitempty"`
+}
+
+// For returns the archive a build with the given method unpacks, or a zero
+// Archive when that ref is cloned instead — or not built at all, for a
+// release. A branch tip has no archive the registry could have published.
+func (a Archives) For(method string) Archive {
+	switch method {
+	case MethodCommit:
+		return a.Commit
+	case MethodRelease, MethodHead:
+		return Archive{}
+	}
+	return a.Version
+}
+
+// Empty reports whether neither ref comes from an archive.
+func (a Archives) Empty() bool {
+	return a.Version.URL == "" && a.Commit.URL == ""
+}
+
+// archiveFormat names how an archive is unpacked, from the end of its URL path.
+// A query string is ignored: release hosts sign their redirects with one.
+func archiveFormat(rawURL string) (string, error) {
+	name := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		name = u.Path
+	}
+	name = strings.ToLower(path.Base(name))
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(name, ".tar.xz"), strings.HasSuffix(name, ".txz"):
+		return "tar.xz", nil
+	case strings.HasSuffix(name, ".zip"):
+		return "zip", nil
+	}
+	return "", fmt.Errorf("%s is not a .tar.gz, .tar.xz or .zip archive", rawURL)
+}
+
+// checkArchive validates a declaration before anything is downloaded.
+func checkArchive(a Archive) error {
+	if _, err := archiveFormat(a.URL); err != nil {
+		return err
+	}
+	return checkDownload(a)
+}
+
+// checkDownload is the part of checkArchive that holds for any verified
+// download, an archive or not: where it comes from and its checksum.
+func checkDownload(a Archive) error {
+	if !strings.HasPrefix(a.URL, "https://") && !strings.HasPrefix(a.URL, "file://") {
+		return fmt.Errorf("%s: archives are downloaded over https only", a.URL)
+	}
+	if a.SHA256 == "" {
+		return fmt.Errorf("%s has no sha256; clipack does not unpack an archive it cannot verify", a.URL)
+	}
+	if sum, err := hex.DecodeString(a.SHA256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("sha256 %q is not 64 hexadecimal digits", a.SHA256)
+	}
+	return nil
+}
+
+// unpackSource downloads the archive a build with the given method uses,
+// verifies it and unpacks it into buildDir. A method without an archive is
+// left to its clone step.
+func (in *Installer) unpackSource(p *Package, method, buildDir string) error {
+	archive := p.Install.Source.Archive.For(method)
+	if archive.URL == "" {
+		return nil
+	}
+	if err := checkArchive(archive); err != nil {
+		return fmt.Errorf("archive source: %w", err)
+	}
+	format, _ := archiveFormat(archive.URL)
+
+	temp, err := in.downloadVerified(p.Name, archive, buildDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		temp.Close()
+		os.Remove(temp.Name())
+	}()
+
+	if err := unpackArchive(temp, format, buildDir, true); err != nil {
+		return fmt.Errorf("unpacking %s: %w", archive.URL, err)
+	}
+	return nil
+}
+
+// downloadVerified downloads a into a temporary file beside buildDir, checks
+// it against its sha256 and returns it rewound. The caller removes it.
+func (in *Installer) downloadVerified(name string, a Archive, buildDir string) (*os.File, error) {
+	in.emit(Event{Kind: EventInfo, Package: name, Text: "Downloading " + a.URL})
+
+	// Beside the build directory rather than in it, so the archive is not part
+	// of the tree the steps see.
+	temp, err := os.CreateTemp(filepath.Dir(buildDir), "."+name+"-archive-*")
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*os.File, error) {
+		temp.Close()
+		os.Remove(temp.Name())
+		return nil, err
+	}
+
+	sum, err := downloadArchive(in.Config, a.URL, temp)
+	if err != nil {
+		return fail(fmt.Errorf("downloading %s: %w", a.URL, err))
+	}
+	if !strings.EqualFold(sum, a.SHA256) {
+		return fail(fmt.Errorf("%s does not match its sha256: got %s, want %s", a.URL, sum, strings.ToLower(a.SHA256)))
+	}
+	in.infof("Verified sha256 %s", sum)
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return temp, nil
+}
+
+// downloadArchive copies the archive at rawURL into w and returns its sha256.
+// The registry token is not sent: it is for the registry's host, and an
+// archive can live anywhere. The proxy and CA bundle are, since they are
+// about the network rather than the host.
+func downloadArchive(config *cnfg.Config, rawURL string, w io.Writer) (string, error) {
+	var body io.ReadCloser
+	if strings.HasPrefix(rawURL, "file://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", err
+		}
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return "", err
+		}
+		body = f
+	} else {
+		resp, err := get(rawURL, config, "")
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("status %d", resp.StatusCode)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	src := &limitedReader{r: body, limit: maxArchiveBytes, what: "archive"}
+	if _, err := io.Copy(io.MultiWriter(w, hash), src); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// unpackArchive extracts an archive into dst under bounds like the registry
+// tarball's: no more than maxArchiveInflatedBytes in total, and nothing
+// written outside dst — neither by a name that climbs out of it nor through a
+// symlink an earlier entry created.
+//
+// Release archives almost always wrap everything in one <name>-<version>/
+// directory. With strip, that directory is removed, so the steps start in the
+// tree itself exactly as they would after a clone.
+func unpackArchive(f *os.File, format, dst string, strip bool) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	budget := &limitedReader{limit: maxArchiveInflatedBytes, what: "unpacked archive"}
+
+	var err error
+	switch format {
+	case "tar.gz":
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		err = unpackTar(gz, dst, budget)
+	case "tar.xz":
+		err = unpackXZ(f, dst, budget)
+	case "zip":
+		err = unpackZip(f, dst, budget)
+	default:
+		err = fmt.Errorf("unknown archive format %q", format)
+	}
+	if err != nil || !strip {
+		return err
+	}
+	return stripTopLevel(dst)
+}
+
+// unpackXZ decompresses through the xz tool, which every distribution clipack
+// builds on ships: the standard library has no xz reader.
+func unpackXZ(f *os.File, dst string, budget *limitedReader) error {
+	if _, err := exec.LookPath("xz"); err != nil {
+		return errors.New("a .tar.xz archive needs the xz command, which is not installed")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("xz", "--decompress", "--stdout")
+	cmd.Stdin = f
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	unpackErr := unpackTar(out, dst, budget)
+	// The tar stream can end before the compressed one does, and xz blocks on
+	// a full pipe until the rest is read — through the same budget, so
+	// trailing data cannot be used to outlast it.
+	if unpackErr == nil {
+		_, unpackErr = io.Copy(io.Discard, budget)
+	}
+	if unpackErr != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return unpackErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("xz: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func unpackTar(r io.Reader, dst string, budget *limitedReader) error {
+	budget.r = r
+	tr := tar.NewReader(budget)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tar: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if _, err := archiveTarget(dst, header.Name, true); err != nil && !errors.Is(err, errArchiveRoot) {
+				return err
+			}
+		case tar.TypeReg:
+			target, err := archiveTarget(dst, header.Name, false)
+			if err != nil {
+				return err
+			}
+			if err := writeArchiveFile(target, tr, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := archiveSymlink(dst, header.Name, header.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := archiveTarget(dst, header.Name, false)
+			if err != nil {
+				return err
+			}
+			source, err := archiveLinkSource(dst, header.Linkname)
+			if err != nil {
+				return fmt.Errorf("hard link %s: %w", header.Name, err)
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		default:
+			// Devices, fifos and the pax/GNU metadata entries the reader
+			// does not fold away have no place in a source tree.
+		}
+	}
+}
+
+func unpackZip(f *os.File, dst string, budget *limitedReader) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("zip: %w", err)
+	}
+
+	for _, entry := range zr.File {
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			if _, err := archiveTarget(dst, entry.Name, true); err != nil && !errors.Is(err, errArchiveRoot) {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			rc, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			link, err := io.ReadAll(io.LimitReader(rc, 4096))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := archiveSymlink(dst, entry.Name, string(link)); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			target, err := archiveTarget(dst, entry.Name, false)
+			if err != nil {
+				return err
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			budget.r = rc
+			err = writeArchiveFile(target, budget, mode.Perm())
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errArchiveRoot is an entry naming the destination itself, "./" — harmless
+// for a directory, and nothing a file can be written to.
+var errArchiveRoot = errors.New("entry is the archive root")
+
+// archiveLinkSource resolves what a hard link entry links to. It is a file an
+// earlier entry wrote, so nothing is created for it: the name has to stay
+// inside dst, and every directory on the way and the file itself have to be
+// there and be what they look like — a symlink among them would let the link
+// reach a file outside dst.
+func archiveLinkSource(dst, name string) (string, error) {
+	source, err := under(dst, filepath.Clean(filepath.FromSlash(name)))
+	if err != nil {
+		return "", fmt.Errorf("archive entry %s: %w", name, err)
+	}
+	rel, err := filepath.Rel(dst, source)
+	if err != nil {
+		return "", err
+	}
+	path := dst
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", fmt.Errorf("archive entry %s: %w", name, err)
+		}
+		last := i == len(parts)-1
+		if last && !info.Mode().IsRegular() || !last && !info.IsDir() {
+			return "", fmt.Errorf("archive entry %s is not a file in the archive", name)
+		}
+	}
+	return source, nil
+}
+
+// archiveTarget resolves an entry name inside dst and creates the directories
+// leading to it. under() refuses a name that climbs out; walking the existing
+// parents refuses one that would be written through a symlink, which a name
+// check alone cannot see — "link/../../x" is inside dst by its name.
+func archiveTarget(dst, name string, isDir bool) (string, error) {
+	clean := strings.TrimSuffix(filepath.Clean(filepath.FromSlash(name)), string(filepath.Separator))
+	if clean == "." || clean == "" {
+		return "", errArchiveRoot
+	}
+	target, err := under(dst, clean)
+	if err != nil {
+		return "", fmt.Errorf("archive entry %s: %w", name, err)
+	}
+
+	parent := filepath.Dir(target)
+	if isDir {
+		parent = target
+	}
+	rel, err := filepath.Rel(dst, parent)
+	if err != nil {
+		return "", err
+	}
+	dir := dst
+	if rel != "." {
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			dir = filepath.Join(dir, part)
+			info, err := os.Lstat(dir)
+			switch {
+			case os.IsNotExist(err):
+				if err := os.Mkdir(dir, 0o755); err != nil {
+					return "", err
+				}
+			case err != nil:
+				return "", err
+			case info.Mode()&os.ModeSymlink != 0:
+				return "", fmt.Errorf("archive entry %s is written through the symlink %s", name, dir)
+			case !info.IsDir():
+				return "", fmt.Errorf("archive entry %s: %s is not a directory", name, dir)
+			}
+		}
+	}
+	return target, nil
+}
+
+// archiveSymlink recreates a symlink, provided it points inside dst: a link
+// out of the tree is where a later entry, or a build step, would write
+// through it.
+//
+// Where the link points is checked by its name, joined to the directory the
+// link is in, and a name is only what the link resolves to when no symlink is
+// followed on the way. The directory is a real one: archiveTarget walked it.
+// What is left is a ".." after a name in the link itself, which the system
+// applies to wherever that name resolves — "up/../.." beside an "up" linking
+// to ".." climbs two levels above dst, while its name stays inside. So ".."
+// may only lead a link, climbing from its real directory; past the first
+// name it only descends, through links held to the same rule.
+func archiveSymlink(dst, name, link string) error {
+	target, err := archiveTarget(dst, name, false)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(link) {
+		return fmt.Errorf("archive symlink %s points at the absolute path %s", name, link)
+	}
+	named := false
+	for _, part := range strings.Split(filepath.ToSlash(link), "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if named {
+				return fmt.Errorf("archive symlink %s climbs out of %s after descending into it", name, link)
+			}
+		default:
+			named = true
+		}
+	}
+	rel, err := filepath.Rel(dst, filepath.Join(filepath.Dir(target), link))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("archive symlink %s points outside the build directory", name)
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(link, target)
+}
+
+// writeArchiveFile writes one file, replacing whatever an earlier entry of the
+// same name left rather than opening it — that could be a symlink.
+func writeArchiveFile(target string, r io.Reader, perm os.FileMode) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm|0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// stripTopLevel lifts the contents of a lone top-level directory into dst.
+func stripTopLevel(dst string) error {
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return nil
+	}
+
+	// Renamed first, so a child with the same name as its parent does not
+	// collide with it on the way up.
+	wrapper := filepath.Join(dst, ".clipack-unpacked")
+	if err := os.Rename(filepath.Join(dst, entries[0].Name()), wrapper); err != nil {
+		return err
+	}
+	children, err := os.ReadDir(wrapper)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := os.Rename(filepath.Join(wrapper, child.Name()), filepath.Join(dst, child.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(wrapper)
+}
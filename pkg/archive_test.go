@@ -0,0 +1,356 @@
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is one member of a test archive. Link makes it a symlink, and
+// HardLink a hard link to an earlier entry.
+type archiveEntry struct {
+	Name     string
+	Content  string
+	Link     string
+	HardLink string
+}
+
+func tarGz(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.Name, Mode: 0o644, Size: int64(len(e.Content)), Typeflag: tar.TypeReg}
+		switch {
+		case e.Link != "":
+			header = &tar.Header{Name: e.Name, Linkname: e.Link, Mode: 0o777, Typeflag: tar.TypeSymlink}
+		case e.HardLink != "":
+			header = &tar.Header{Name: e.Name, Linkname: e.HardLink, Mode: 0o644, Typeflag: tar.TypeLink}
+		case strings.HasSuffix(e.Name, "/"):
+			header = &tar.Header{Name: e.Name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.Content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serveArchive writes an archive to disk and returns the declaration for it.
+func serveArchive(t *testing.T, name string, data []byte) Archive {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return Archive{URL: "file://" + file, SHA256: hex.EncodeToString(sum[:])}
+}
+
+// archivePackage builds its binary from the unpacked tree. The clone step is
+// there to show it is dropped for a ref that has an archive.
+func archivePackage(archive Archive) *Package {
+	return &Package{
+		Name:    "demo",
+		Version: "v1.0.0",
+		Install: Install{
+			Source: Source{URL: "https://example.com/demo.git", Archive: Archives{Version: archive}},
+			Steps: []string{
+				"git clone https://example.com/demo.git .",
+				"mkdir -p out && cp src/demo out/demo",
+			},
+			Binaries: []string{"out/demo"},
+		},
+	}
+}
+
+var demoTree = []archiveEntry{
+	{Name: "demo-1.0.0/"},
+	{Name: "demo-1.0.0/src/demo", Content: "#!/bin/sh\necho demo\n"},
+	{Name: "demo-1.0.0/README", Content: "readme"},
+}
+
+func TestInstallFromATarball(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the shell syntax under test is POSIX")
+	}
+
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	archive := serveArchive(t, "demo-1.0.0.tar.gz", tarGz(t, demoTree))
+	if err := in.Install(archivePackage(archive), MethodVersion); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(config.Paths.Bin, "demo"))
+	if err != nil || !strings.Contains(string(data), "echo demo") {
+		t.Errorf("bin/demo = %q, %v; want the file from the archive", data, err)
+	}
+}
+
+func TestInstallFromAZip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the shell syntax under test is POSIX")
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range demoTree {
+		w, err := zw.Create(e.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.Content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+	archive := serveArchive(t, "demo-1.0.0.zip", buf.Bytes())
+	if err := in.Install(archivePackage(archive), MethodVersion); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if !exists(filepath.Join(config.Paths.Bin, "demo")) {
+		t.Error("the binary built from the zip was not installed")
+	}
+}
+
+func TestInstallFromATarXz(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz is not installed")
+	}
+
+	gz := tarGz(t, demoTree)
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tarball bytes.Buffer
+	if _, err := tarball.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("xz", "--compress", "--stdout")
+	cmd.Stdin = &tarball
+	xz, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+	archive := serveArchive(t, "demo-1.0.0.tar.xz", xz)
+	if err := in.Install(archivePackage(archive), MethodVersion); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if !exists(filepath.Join(config.Paths.Bin, "demo")) {
+		t.Error("the binary built from the .tar.xz was not installed")
+	}
+}
+
+func TestInstallRefusesAnArchiveThatDoesNotMatchItsChecksum(t *testing.T) {
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	archive := serveArchive(t, "demo-1.0.0.tar.gz", tarGz(t, demoTree))
+	archive.SHA256 = strings.Repeat("0", 64)
+
+	err := in.Install(archivePackage(archive), MethodVersion)
+	if err == nil || !strings.Contains(err.Error(), "does not match its sha256") {
+		t.Fatalf("Install() error = %v, want a checksum mismatch", err)
+	}
+	if exists(filepath.Join(config.Paths.Build, "demo", "src")) {
+		t.Error("an unverified archive was unpacked")
+	}
+}
+
+func TestCheckArchive(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		archive Archive
+		want    string
+	}{
+		{"no checksum", Archive{URL: "https://example.com/a.tar.gz"}, "no sha256"},
+		{"short checksum", Archive{URL: "https://example.com/a.tar.gz", SHA256: "abc"}, "64 hexadecimal"},
+		{"plaintext", Archive{URL: "http://example.com/a.tar.gz", SHA256: sum}, "https only"},
+		{"unknown format", Archive{URL: "https://example.com/a.rar", SHA256: sum}, "not a .tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkArchive(tt.archive); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("checkArchive() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if err := checkArchive(Archive{URL: "https://example.com/a.tar.gz?sig=1", SHA256: strings.ToUpper(sum)}); err != nil {
+		t.Errorf("checkArchive() = %v, want a signed URL and an upper-case digest accepted", err)
+	}
+}
+
+func TestUnpackArchiveRefusesToLeaveTheBuildDirectory(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+	}{
+		{"climbing name", []archiveEntry{{Name: "../escaped", Content: "x"}}},
+		{"absolute symlink", []archiveEntry{{Name: "link", Link: "/etc"}}},
+		{"symlink out", []archiveEntry{{Name: "link", Link: "../.."}}},
+		{"write through a symlink", []archiveEntry{
+			{Name: "sub/"},
+			{Name: "sub/up", Link: ".."},
+			{Name: "sub/up/inside", Content: "x"},
+		}},
+		// Inside by its name, two levels above the tree once up is followed.
+		{"symlink out through a symlink", []archiveEntry{
+			{Name: "sub/"},
+			{Name: "sub/up", Link: ".."},
+			{Name: "sub/out", Link: "up/../.."},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dst := filepath.Join(root, "build")
+			archive := serveArchive(t, "a.tar.gz", tarGz(t, tt.entries))
+
+			f, err := os.Open(strings.TrimPrefix(archive.URL, "file://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			if err := unpackArchive(f, "tar.gz", dst, true); err == nil {
+				t.Error("unpackArchive() error = nil, want the entry refused")
+			}
+			if exists(filepath.Join(root, "escaped")) {
+				t.Error("a file was written outside the build directory")
+			}
+		})
+	}
+}
+
+func TestUnpackArchiveKeepsALinkInsideTheTree(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "build")
+	archive := serveArchive(t, "a.tar.gz", tarGz(t, []archiveEntry{
+		{Name: "pkg-1/lib/real", Content: "x"},
+		{Name: "pkg-1/lib/alias", Link: "real"},
+		{Name: "pkg-1/lib/top", Link: "./../top"},
+		{Name: "pkg-1/top", Content: "y"},
+	}))
+	f, err := os.Open(strings.TrimPrefix(archive.URL, "file://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := unpackArchive(f, "tar.gz", dst, true); err != nil {
+		t.Fatalf("unpackArchive() error = %v", err)
+	}
+	// The wrapping pkg-1/ is gone, so the tree starts where a clone would.
+	if link, err := os.Readlink(filepath.Join(dst, "lib", "alias")); err != nil || link != "real" {
+		t.Errorf("lib/alias = %q, %v; want the link kept", link, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "lib", "top")); err != nil || link != "./../top" {
+		t.Errorf("lib/top = %q, %v; want a link climbing from its own directory kept", link, err)
+	}
+	if !exists(filepath.Join(dst, "top")) {
+		t.Error("the top-level directory was not stripped")
+	}
+}
+
+func TestUnpackArchiveHardLinks(t *testing.T) {
+	unpack := func(t *testing.T, entries []archiveEntry) (string, error) {
+		dst := filepath.Join(t.TempDir(), "build")
+		archive := serveArchive(t, "a.tar.gz", tarGz(t, entries))
+		f, err := os.Open(strings.TrimPrefix(archive.URL, "file://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		return dst, unpackArchive(f, "tar.gz", dst, false)
+	}
+
+	dst, err := unpack(t, []archiveEntry{
+		{Name: "lib/real", Content: "x"},
+		{Name: "bin/alias", HardLink: "lib/real"},
+	})
+	if err != nil {
+		t.Fatalf("unpackArchive() error = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "bin", "alias")); err != nil || string(data) != "x" {
+		t.Errorf("bin/alias = %q, %v; want the linked file", data, err)
+	}
+
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+	}{
+		{"missing source", []archiveEntry{{Name: "alias", HardLink: "nowhere/real"}}},
+		{"source outside", []archiveEntry{{Name: "alias", HardLink: "../real"}}},
+		{"source through a symlink", []archiveEntry{
+			{Name: "lib/real", Content: "x"},
+			{Name: "sub/"},
+			{Name: "sub/up", Link: ".."},
+			{Name: "alias", HardLink: "sub/up/lib/real"},
+		}},
+		{"source is a directory", []archiveEntry{
+			{Name: "lib/"},
+			{Name: "alias", HardLink: "lib"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, err := unpack(t, tt.entries)
+			if err == nil {
+				t.Error("unpackArchive() error = nil, want the link refused")
+			}
+			// Only what the archive wrote: the source of a link is looked
+			// for, not made.
+			if exists(filepath.Join(dst, "nowhere")) {
+				t.Error("a directory was created for the link's source")
+			}
+		})
+	}
+}
+
+func TestExpandStepsDropsTheCloneForAnArchive(t *testing.T) {
+	in := NewInstaller(testConfig(t), nil)
+	p := archivePackage(Archive{URL: "https://example.com/demo.tar.gz", SHA256: strings.Repeat("0", 64)})
+
+	steps := in.expandSteps(p, MethodVersion)
+	if len(steps) != 1 || strings.Contains(steps[0], "git clone") {
+		t.Errorf("version steps = %v, want the clone dropped", steps)
+	}
+	// The commit has no archive, so it is still cloned.
+	p.Commit = "abc"
+	if steps := in.expandSteps(p, MethodCommit); !strings.Contains(steps[0], "git clone") {
+		t.Errorf("commit steps = %v, want a clone", steps)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/lvim-tech/clipack/cnfg"
@@ -14,17 +17,141 @@ import (
 // ErrCacheStale is returned when the cache is missing or past its interval.
 var ErrCacheStale = errors.New("registry cache is missing or outdated")
 
-// PackageCache holds the cached packages and the last updated timestamp.
+// cacheFormat is the layout of the cache files this clipack writes. It goes up
+// with any change to Package that an older cache cannot be decoded into
+// faithfully — a field that changes type, say — and a cache in any format but
+// this one is discarded and fetched again rather than half read.
+const cacheFormat = 1
+
+// clipackVersion is recorded in every cache written: the module version for a
+// `go install …@vX`, "(devel)" for a local build.
+var clipackVersion = func() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}()
+
+// CacheHeader says what a cache file holds and where it came from. It is
+// written ahead of the packages as a gob value of its own, so it can be read —
+// and a cache in another format or of another registry turned away — without
+// decoding a package list that may no longer fit the Package type.
+type CacheHeader struct {
+	Format int
+	// Clipack is the version of clipack that wrote the file.
+	Clipack string
+	// URL and Branch are the registry the packages were read from. Both are
+	// empty in a cache migrated from before there was a header.
+	URL    string
+	Branch string
+	// Commit is the registry commit the packages were read at, when the
+	// registry could say.
+	Commit      string
+	LastUpdated time.Time
+	// Validators are what the registry's server said identifies this copy,
+	// for asking next time whether it changed; empty for a source that cannot
+	// say.
+	Validators Validators
+	// SignedBy is the fingerprint of the trusted key the registry was
+	// verified with; empty when no key was required.
+	SignedBy string
+}
+
+// from reports whether the cache was read from layer.
+func (h CacheHeader) from(layer cnfg.RegistryLayer) bool {
+	return h.URL == strings.TrimSpace(layer.URL) && h.Branch == layer.Branch
+}
+
+// PackageCache is a cache file: its header and the packages.
 type PackageCache struct {
+	CacheHeader
+	Packages []*Package
+}
+
+// legacyCache is the layout before the header: one gob value with everything
+// in it, and no record of which registry the packages came from.
+type legacyCache struct {
 	Packages    []*Package
 	LastUpdated time.Time
 }
 
-// GetCacheFilePath returns the path to the cache file. It no longer creates
+// OfflineError is a registry served from a cache past its interval, because
+// reading the registry itself failed. It says how old the packages are, which
+// is what decides whether to trust them.
+type OfflineError struct {
+	Age time.Duration
+	Err error
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("offline, cache is %s old: %v", FormatAge(e.Age), e.Err)
+}
+
+func (e *OfflineError) Unwrap() error { return e.Err }
+
+// Offline returns the oldest cache an error says was served in place of a
+// registry, or nil when every registry was read.
+func Offline(err error) *OfflineError {
+	var oldest *OfflineError
+	var walk func(error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case *OfflineError:
+			if oldest == nil || e.Age > oldest.Age {
+				oldest = e
+			}
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		}
+	}
+	walk(err)
+	return oldest
+}
+
+// FormatAge renders a cache's age the way the notice reads it: in hours, or
+// minutes when it is younger than one.
+func FormatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "less than a minute"
+	case age < time.Hour:
+		return plural(int(age/time.Minute), "minute")
+	}
+	return plural(int(age/time.Hour), "hour")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// GetCacheFilePath returns the path to the cache file of the first registry
+// layer — the only one, in a configuration without layers. It no longer creates
 // directories or calls log.Fatalf: a path getter must never terminate the
 // process, which would tear down the TUI mid-render.
 func GetCacheFilePath(config *cnfg.Config) string {
-	return filepath.Join(config.Paths.Registry, "packages_cache.gob")
+	layers := config.RegistryLayers()
+	if len(layers) == 0 {
+		return filepath.Join(config.Paths.Registry, "packages_cache.gob")
+	}
+	return cachePathFor(config, layers[0])
+}
+
+// cachePathFor is where one registry layer is cached. The bottom layer keeps
+// the file name the cache had before there were layers, so upgrading does not
+// throw away a fresh cache; every layer above it is named after the layer.
+func cachePathFor(config *cnfg.Config, layer cnfg.RegistryLayer) string {
+	if layers := config.RegistryLayers(); len(layers) == 0 || layers[0].Name == layer.Name {
+		return filepath.Join(config.Paths.Registry, "packages_cache.gob")
+	}
+	return filepath.Join(config.Paths.Registry, "packages_cache-"+layer.Name+".gob")
 }
 
 // GetCacheTimestampFilePath returns the legacy timestamp file path. The
@@ -34,26 +161,110 @@ func GetCacheTimestampFilePath(config *cnfg.Config) string {
 	return filepath.Join(config.Paths.Registry, "cache_timestamp.gob")
 }
 
-func readCache(config *cnfg.Config) (*PackageCache, error) {
-	file, err := os.Open(GetCacheFilePath(config))
+// readCache reads a cache file whatever its age and origin. A file from before
+// the header is migrated: its packages are kept, under a header that names no
+// registry. Anything in a format this clipack does not write is an error, and
+// the file is overwritten by the next fetch.
+func readCache(path string) (*PackageCache, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	// A legacy file decodes into the header too, matching on LastUpdated,
+	// and leaves Format at zero.
+	dec := gob.NewDecoder(file)
 	var cache PackageCache
-	if err := gob.NewDecoder(file).Decode(&cache); err != nil {
+	if err := dec.Decode(&cache.CacheHeader); err != nil {
 		return nil, fmt.Errorf("decoding cache: %w", err)
 	}
+	switch cache.Format {
+	case 0:
+		return readLegacyCache(file)
+	case cacheFormat:
+	default:
+		return nil, fmt.Errorf("cache is in format %d, written by clipack %s; this clipack reads format %d",
+			cache.Format, cache.Clipack, cacheFormat)
+	}
+
+	if err := dec.Decode(&cache.Packages); err != nil {
+		return nil, fmt.Errorf("decoding cache written by clipack %s: %w", cache.Clipack, err)
+	}
 	return &cache, nil
 }
 
-// LoadFromCache loads packages from the cache if they are still fresh.
+// readLegacyCache decodes file, from the start, in the layout before the
+// header.
+func readLegacyCache(file *os.File) (*PackageCache, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var legacy legacyCache
+	if err := gob.NewDecoder(file).Decode(&legacy); err != nil {
+		return nil, fmt.Errorf("decoding cache: %w", err)
+	}
+	return &PackageCache{
+		CacheHeader: CacheHeader{Format: cacheFormat, LastUpdated: legacy.LastUpdated},
+		Packages:    legacy.Packages,
+	}, nil
+}
+
+// readLayerCache reads one layer's cache whatever its age. A cache of another
+// registry — the configuration was changed since it was written — is no cache
+// of this one, and neither is an empty one.
+func readLayerCache(config *cnfg.Config, layer cnfg.RegistryLayer) (*PackageCache, error) {
+	cache, err := readCache(cachePathFor(config, layer))
+	if err != nil {
+		return nil, err
+	}
+	if cache.URL != "" && !cache.from(layer) {
+		return nil, fmt.Errorf("%w: it is of %s", ErrCacheStale, cache.URL)
+	}
+	// A cache from before registry.trusted_keys was set, or verified with a
+	// key that has since been taken out of it, is not trusted either.
+	if len(config.Registry.TrustedKeys) > 0 && !signedByTrusted(config, cache.SignedBy) {
+		return nil, fmt.Errorf("%w: it was not verified with registry.trusted_keys", ErrCacheStale)
+	}
+	if len(cache.Packages) == 0 {
+		return nil, ErrCacheStale
+	}
+	return cache, nil
+}
+
+// LoadFromCache loads the merged packages of every registry layer, provided
+// every layer's cache is still fresh. One stale layer makes the whole set
+// stale: serving the others alone would quietly drop that layer's packages.
 func LoadFromCache(config *cnfg.Config) ([]*Package, error) {
-	cache, err := readCache(config)
+	layers := config.RegistryLayers()
+	if len(layers) == 0 {
+		return nil, ErrCacheStale
+	}
+
+	sets := make([][]*Package, 0, len(layers))
+	for _, layer := range layers {
+		packages, err := loadLayerCache(config, layer)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, packages)
+	}
+	// A broken override is reported by the load that goes through the
+	// registries; here it is only left out.
+	packages, _ := applyOverlay(mergeLayers(sets), config)
+	return packages, nil
+}
+
+// loadLayerCache loads one layer's packages if its cache is still fresh. A
+// migrated cache never is: nothing says it is of the registry configured now.
+func loadLayerCache(config *cnfg.Config, layer cnfg.RegistryLayer) ([]*Package, error) {
+	cache, err := readLayerCache(config, layer)
 	if err != nil {
 		return nil, err
 	}
+	if cache.URL == "" {
+		return nil, ErrCacheStale
+	}
 
 	interval := config.Registry.UpdateInterval
 	if interval <= 0 {
@@ -62,21 +273,29 @@ func LoadFromCache(config *cnfg.Config) ([]*Package, error) {
 	if time.Since(cache.LastUpdated) > interval {
 		return nil, ErrCacheStale
 	}
-	if len(cache.Packages) == 0 {
-		return nil, ErrCacheStale
-	}
 
 	return cache.Packages, nil
 }
 
-// SaveToCache writes packages to the cache atomically, so an interrupted write
-// cannot leave a truncated gob file behind.
+// SaveToCache writes packages as the cache of the first registry layer — the
+// only one, in a configuration without layers.
 func SaveToCache(packages []*Package, config *cnfg.Config) error {
+	layers := config.RegistryLayers()
+	if len(layers) == 0 {
+		return cnfg.ErrNoRegistry
+	}
+	return saveLayerCache(packages, CacheHeader{}, config, layers[0])
+}
+
+// saveLayerCache writes one layer's packages atomically, so an interrupted
+// write cannot leave a truncated gob file behind. The header's commit and
+// validators are the caller's; the rest is filled in here.
+func saveLayerCache(packages []*Package, header CacheHeader, config *cnfg.Config, layer cnfg.RegistryLayer) error {
 	if err := os.MkdirAll(config.Paths.Registry, 0o755); err != nil {
 		return fmt.Errorf("creating cache directory: %w", err)
 	}
 
-	cacheFilePath := GetCacheFilePath(config)
+	cacheFilePath := cachePathFor(config, layer)
 	tmp, err := os.CreateTemp(config.Paths.Registry, "packages_cache-*.tmp")
 	if err != nil {
 		return err
@@ -84,8 +303,18 @@ func SaveToCache(packages []*Package, config *cnfg.Config) error {
 	tmpName := tmp.Name()
 	defer os.Remove(tmpName)
 
-	cache := PackageCache{Packages: packages, LastUpdated: time.Now()}
-	if err := gob.NewEncoder(tmp).Encode(&cache); err != nil {
+	header.Format = cacheFormat
+	header.Clipack = clipackVersion
+	header.URL = strings.TrimSpace(layer.URL)
+	header.Branch = layer.Branch
+	header.LastUpdated = time.Now()
+
+	enc := gob.NewEncoder(tmp)
+	if err := enc.Encode(&header); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encoding cache: %w", err)
+	}
+	if err := enc.Encode(packages); err != nil {
 		tmp.Close()
 		return fmt.Errorf("encoding cache: %w", err)
 	}
@@ -102,13 +331,18 @@ func SaveToCache(packages []*Package, config *cnfg.Config) error {
 	return nil
 }
 
-// ClearCache removes the cached registry.
+// ClearCache removes the cached registry, every layer of it — including the
+// caches of layers that have since been taken out of the configuration.
 func ClearCache(config *cnfg.Config) error {
-	if err := os.Remove(GetCacheFilePath(config)); err != nil && !os.IsNotExist(err) {
+	paths, err := filepath.Glob(filepath.Join(config.Paths.Registry, "packages_cache*.gob"))
+	if err != nil {
 		return err
 	}
-	if err := os.Remove(GetCacheTimestampFilePath(config)); err != nil && !os.IsNotExist(err) {
-		return err
+	paths = append(paths, GetCacheTimestampFilePath(config))
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 	return nil
 }
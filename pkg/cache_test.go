@@ -1,9 +1,11 @@
 package pkg
 
 import (
+	"encoding/gob"
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -35,6 +37,106 @@ func TestSaveAndLoadCache(t *testing.T) {
 	}
 }
 
+func TestCacheRecordsWhereItCameFrom(t *testing.T) {
+	config := testConfig(t)
+	if err := SaveToCache([]*Package{{Name: "bat"}}, config); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := readCache(GetCacheFilePath(config))
+	if err != nil {
+		t.Fatalf("readCache() error = %v", err)
+	}
+	if cache.Format != cacheFormat || cache.Clipack == "" {
+		t.Errorf("header = %+v, want the format and the clipack version", cache.CacheHeader)
+	}
+	if cache.URL != config.Registry.URL || cache.Branch != config.Registry.Branch {
+		t.Errorf("header names %s@%s, want the configured registry", cache.URL, cache.Branch)
+	}
+}
+
+func TestCacheOfAnotherRegistryIsNotServed(t *testing.T) {
+	config := testConfig(t)
+	if err := SaveToCache([]*Package{{Name: "from-the-old-registry"}}, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same layer name, so the same cache file: only the header can tell.
+	config.Registry.URL = "https://github.com/example/registry.git"
+	config.Registry.Branch = "next"
+	if _, err := LoadFromCache(config); !errors.Is(err, ErrCacheStale) {
+		t.Errorf("LoadFromCache() error = %v, want the other branch's cache refused", err)
+	}
+
+	config.Offline = true
+	if _, err := LoadAllPackagesFromRegistry(config); !errors.Is(err, ErrOffline) {
+		t.Errorf("offline load error = %v, want no cache, rather than the other registry's", err)
+	}
+}
+
+// writeLegacyCache writes a cache the way clipack did before the header.
+func writeLegacyCache(t *testing.T, path string, packages []*Package) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := gob.NewEncoder(file).Encode(&legacyCache{Packages: packages, LastUpdated: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyCacheIsMigrated(t *testing.T) {
+	config := testConfig(t)
+	if err := os.MkdirAll(config.Paths.Registry, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeLegacyCache(t, GetCacheFilePath(config), []*Package{{Name: "bat", Version: "v0.25.0"}})
+
+	cache, err := readCache(GetCacheFilePath(config))
+	if err != nil {
+		t.Fatalf("readCache() error = %v, want the old layout read", err)
+	}
+	if len(cache.Packages) != 1 || cache.Packages[0].Version != "v0.25.0" {
+		t.Errorf("packages = %v, want them carried over", cache.Packages)
+	}
+
+	// Fresh by its timestamp, but nothing says it is of this registry.
+	if _, err := LoadFromCache(config); !errors.Is(err, ErrCacheStale) {
+		t.Errorf("LoadFromCache() error = %v, want a migrated cache refreshed", err)
+	}
+	// Offline, it is still better than nothing.
+	config.Offline = true
+	if packages, err := LoadAllPackagesFromRegistry(config); err != nil || len(packages) != 1 {
+		t.Errorf("offline load = %v, %v; want the migrated packages", packages, err)
+	}
+}
+
+func TestCacheInAnotherFormatIsDiscarded(t *testing.T) {
+	config := testConfig(t)
+	if err := os.MkdirAll(config.Paths.Registry, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(GetCacheFilePath(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := gob.NewEncoder(file)
+	enc.Encode(&CacheHeader{Format: cacheFormat + 1, Clipack: "v9.0.0"})
+	enc.Encode([]*Package{{Name: "bat"}})
+	file.Close()
+
+	_, err = readCache(GetCacheFilePath(config))
+	if err == nil || !strings.Contains(err.Error(), "v9.0.0") {
+		t.Errorf("readCache() error = %v, want the format refused, naming who wrote it", err)
+	}
+	if _, err := LoadFromCache(config); err == nil {
+		t.Error("LoadFromCache() served a cache in a format it does not write")
+	}
+}
+
 func TestSaveToCacheCreatesMissingDirectory(t *testing.T) {
 	config := testConfig(t)
 
@@ -184,3 +286,22 @@ func TestClearCache(t *testing.T) {
 		t.Errorf("ClearCache() on an empty cache error = %v, want nil", err)
 	}
 }
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{30 * time.Second, "less than a minute"},
+		{time.Minute, "1 minute"},
+		{45 * time.Minute, "45 minutes"},
+		{time.Hour + 20*time.Minute, "1 hour"},
+		{5 * time.Hour, "5 hours"},
+		{50 * time.Hour, "50 hours"},
+	}
+	for _, tt := range tests {
+		if got := FormatAge(tt.age); got != tt.want {
+			t.Errorf("FormatAge(%v) = %q, want %q", tt.age, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Dependencies returns every package p needs installed before it is built:
+// its runtime dependencies and its build dependencies, each once, in the order
+// they were written.
+func (p *Package) Dependencies() []string {
+	return mergeRequirements(p.Depends, p.BuildDepends)
+}
+
+// CycleError reports packages that depend on each other, directly or through
+// others, so that no order installs every one after what it needs.
+type CycleError struct {
+	// Cycle names the packages in the order they depend on each other, with
+	// the first repeated at the end: a → b → a.
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.Cycle, " → ")
+}
+
+// ResolveOrder returns the named packages together with everything they
+// depend on, transitively, ordered so every package comes after the ones it
+// needs. A dependency the registry does not have is an error rather than
+// something to skip: the build that needs it would fail later, and further
+// from the cause.
+func ResolveOrder(packages []*Package, names []string) ([]*Package, error) {
+	var roots []*Package
+	for _, name := range names {
+		p := FindByName(packages, name)
+		if p == nil {
+			return nil, fmt.Errorf("package %q not found in registry", name)
+		}
+		roots = append(roots, p)
+	}
+
+	return dependencyOrder(roots, func(from *Package, name string) (*Package, error) {
+		if dep := FindByName(packages, name); dep != nil {
+			return dep, nil
+		}
+		return nil, fmt.Errorf("%s depends on %s, which is not in the registry", from.Name, name)
+	})
+}
+
+// SortByDependencies orders a set of packages so every one comes after those
+// in the set it depends on. Dependencies outside the set are left alone — this
+// is the order for work on packages already chosen, like the updates `update
+// --all` applies, not a way of choosing more.
+func SortByDependencies(set []*Package) ([]*Package, error) {
+	return dependencyOrder(set, func(_ *Package, name string) (*Package, error) {
+		return FindByName(set, name), nil
+	})
+}
+
+// dependencyOrder is a depth-first topological sort from roots, following
+// whatever lookup returns for a dependency; a nil package is not followed.
+// The roots keep their relative order wherever the dependencies allow it, so
+// the result reads like the list the user gave.
+func dependencyOrder(roots []*Package, lookup func(from *Package, name string) (*Package, error)) ([]*Package, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var out []*Package
+	var stack []string
+
+	var visit func(p *Package) error
+	visit = func(p *Package) error {
+		switch state[p.Name] {
+		case done:
+			return nil
+		case visiting:
+			start := 0
+			for i, name := range stack {
+				if name == p.Name {
+					start = i
+				}
+			}
+			cycle := append(append([]string{}, stack[start:]...), p.Name)
+			return &CycleError{Cycle: cycle}
+		}
+
+		state[p.Name] = visiting
+		stack = append(stack, p.Name)
+		for _, name := range p.Dependencies() {
+			dep, err := lookup(p, name)
+			if err != nil {
+				return err
+			}
+			if dep == nil {
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[p.Name] = done
+		out = append(out, p)
+		return nil
+	}
+
+	for _, p := range roots {
+		if err := visit(p); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Dependents returns the installed packages that depend on name: those that
+// need it to run, and those that only need it to build.
+func Dependents(installed map[string]*Package, name string) (runtime, build []string) {
+	for _, p := range installed {
+		switch {
+		case p.Name == name:
+		case containsName(p.Depends, name):
+			runtime = append(runtime, p.Name)
+		case containsName(p.BuildDepends, name):
+			build = append(build, p.Name)
+		}
+	}
+	sort.Strings(runtime)
+	sort.Strings(build)
+	return runtime, build
+}
+
+// DependedOnError is Remove refusing to take away a package that installed
+// packages still need at run time.
+type DependedOnError struct {
+	Name string
+	By   []string
+}
+
+func (e *DependedOnError) Error() string {
+	return fmt.Sprintf("%s is needed by %s; remove them first, or together with it",
+		e.Name, strings.Join(e.By, ", "))
+}
+
+// checkBuildDepends refuses a build whose build dependencies are not
+// installed. The steps would fail anyway, but on a "command not found" halfway
+// through a compile, which says nothing about which package was missing.
+func (in *Installer) checkBuildDepends(p *Package) error {
+	var missing []string
+	for _, name := range p.BuildDepends {
+		if _, err := in.readManifest(in.pathsFor(name)); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s needs %s to build, and it is not installed", p.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// warnMissingDepends says which runtime dependencies are not installed. The
+// build does not need them, so they do not stop it; the program will.
+func (in *Installer) warnMissingDepends(p *Package) {
+	for _, name := range p.Depends {
+		if _, err := in.readManifest(in.pathsFor(name)); err != nil {
+			in.warnf("%s depends on %s, which is not installed", p.Name, name)
+		}
+	}
+}
+
+// buildPath is the environment a package's steps run with once its build
+// dependencies are installed: the entry's own variables, with clipack's bin
+// directory put first on PATH so the steps find the tools it built. An entry
+// that sets PATH itself gets the directory in front of its own value.
+func (in *Installer) buildPath(p *Package) map[string]string {
+	if len(p.BuildDepends) == 0 || in.Config.Paths.Bin == "" {
+		return p.Install.Environment
+	}
+
+	env := make(map[string]string, len(p.Install.Environment)+1)
+	for k, v := range p.Install.Environment {
+		env[k] = v
+	}
+	path, ok := env["PATH"]
+	if !ok {
+		path = os.Getenv("PATH")
+	}
+	env["PATH"] = in.Config.Paths.Bin + string(os.PathListSeparator) + path
+	return env
+}
@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func packageNames(packages []*Package) string {
+	var out []string
+	for _, p := range packages {
+		out = append(out, p.Name)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestResolveOrderPutsDependenciesFirst(t *testing.T) {
+	packages := []*Package{
+		{Name: "yazi", Depends: []string{"ueberzugpp"}},
+		{Name: "ueberzugpp", BuildDepends: []string{"cmake"}},
+		{Name: "cmake"},
+		{Name: "unrelated"},
+	}
+
+	order, err := ResolveOrder(packages, []string{"yazi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := packageNames(order); got != "cmake ueberzugpp yazi" {
+		t.Errorf("ResolveOrder() = %s, want the transitive dependencies before yazi", got)
+	}
+}
+
+func TestResolveOrderKeepsEachPackageOnce(t *testing.T) {
+	packages := []*Package{
+		{Name: "a", Depends: []string{"c"}},
+		{Name: "b", Depends: []string{"c"}, BuildDepends: []string{"c"}},
+		{Name: "c"},
+	}
+
+	order, err := ResolveOrder(packages, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := packageNames(order); got != "c a b" {
+		t.Errorf("ResolveOrder() = %s, want c a b", got)
+	}
+}
+
+func TestResolveOrderReportsTheCycle(t *testing.T) {
+	packages := []*Package{
+		{Name: "a", Depends: []string{"b"}},
+		{Name: "b", BuildDepends: []string{"c"}},
+		{Name: "c", Depends: []string{"a"}},
+	}
+
+	_, err := ResolveOrder(packages, []string{"a"})
+	var cycle *CycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("error = %v, want a *CycleError", err)
+	}
+	if got := err.Error(); got != "dependency cycle: a → b → c → a" {
+		t.Errorf("error = %q, want the cycle spelled out", got)
+	}
+}
+
+func TestResolveOrderRefusesAnUnknownDependency(t *testing.T) {
+	packages := []*Package{{Name: "a", Depends: []string{"ghost"}}}
+
+	_, err := ResolveOrder(packages, []string{"a"})
+	if err == nil || !strings.Contains(err.Error(), "a depends on ghost") {
+		t.Errorf("error = %v, want the missing dependency named", err)
+	}
+}
+
+func TestSortByDependenciesStaysInsideTheSet(t *testing.T) {
+	set := []*Package{
+		{Name: "plugin", Depends: []string{"host", "elsewhere"}},
+		{Name: "host"},
+	}
+
+	order, err := SortByDependencies(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := packageNames(order); got != "host plugin" {
+		t.Errorf("SortByDependencies() = %s, want host plugin and nothing pulled in", got)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	installed := map[string]*Package{
+		"host":   {Name: "host"},
+		"plugin": {Name: "plugin", Depends: []string{"host"}},
+		"tool":   {Name: "tool", BuildDepends: []string{"host"}},
+	}
+
+	users, builders := Dependents(installed, "host")
+	if strings.Join(users, ",") != "plugin" || strings.Join(builders, ",") != "tool" {
+		t.Errorf("Dependents() = %v, %v; want [plugin], [tool]", users, builders)
+	}
+}
+
+func TestRemoveRefusesAPackageStillDependedOn(t *testing.T) {
+	config := testConfig(t)
+	writeManifest(t, config.Paths.Configs, "host", "name: host\n")
+	writeManifest(t, config.Paths.Configs, "plugin", "name: plugin\ndepends: [host]\n")
+	in := NewInstaller(config, nil)
+
+	err := in.Remove(&Package{Name: "host"})
+	var dependedOn *DependedOnError
+	if !errors.As(err, &dependedOn) || strings.Join(dependedOn.By, ",") != "plugin" {
+		t.Fatalf("Remove() error = %v, want it refused because plugin needs host", err)
+	}
+	if !exists(filepath.Join(config.Paths.Configs, "host")) {
+		t.Error("host was removed despite the refusal")
+	}
+
+	in.IgnoreDependents = true
+	if err := in.Remove(&Package{Name: "host"}); err != nil {
+		t.Fatalf("Remove() with IgnoreDependents error = %v", err)
+	}
+	if exists(filepath.Join(config.Paths.Configs, "host")) {
+		t.Error("host is still installed")
+	}
+}
+
+func TestRemoveWarnsAboutABuildDependency(t *testing.T) {
+	config := testConfig(t)
+	writeManifest(t, config.Paths.Configs, "cmake", "name: cmake\n")
+	writeManifest(t, config.Paths.Configs, "tool", "name: tool\nbuild-depends: [cmake]\n")
+	rec := &recorder{}
+	in := NewInstaller(config, rec.report)
+
+	if err := in.Remove(&Package{Name: "cmake"}); err != nil {
+		t.Fatalf("Remove() error = %v, want a build dependency removed", err)
+	}
+	if warns := rec.texts(EventWarn); len(warns) != 1 || !strings.Contains(warns[0], "needed to build tool") {
+		t.Errorf("warnings = %v, want one about tool", warns)
+	}
+}
+
+func TestBuildDependsPutsBinOnThePath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the shell syntax under test is POSIX")
+	}
+
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	tool := filepath.Join(config.Paths.Bin, "hosttool")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\necho built-with-host\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeManifest(t, config.Paths.Configs, "host", "name: host\n")
+
+	p := &Package{
+		Name:         "plugin",
+		Version:      "v1",
+		BuildDepends: []string{"host"},
+		Install: Install{
+			Steps:    []string{"mkdir -p out", "hosttool > out/plugin"},
+			Binaries: []string{"out/plugin"},
+		},
+	}
+	if err := in.Install(p, MethodVersion); err != nil {
+		t.Fatalf("Install() error = %v, want hosttool found on PATH", err)
+	}
+	data, err := os.ReadFile(filepath.Join(config.Paths.Bin, "plugin"))
+	if err != nil || strings.TrimSpace(string(data)) != "built-with-host" {
+		t.Errorf("plugin = %q, %v; want the output of hosttool", data, err)
+	}
+}
+
+func TestInstallRefusesAMissingBuildDependency(t *testing.T) {
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	p := &Package{Name: "plugin", BuildDepends: []string{"host"}, Install: Install{Steps: []string{"true"}}}
+	err := in.Install(p, MethodVersion)
+	if err == nil || !strings.Contains(err.Error(), "needs host to build") {
+		t.Errorf("Install() error = %v, want the missing build dependency named", err)
+	}
+}
@@ -0,0 +1,237 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lvim-tech/clipack/cnfg"
+)
+
+// A head install follows a branch rather than the registry, so whether it has
+// an update is a question for the remote: `git ls-remote` names the branch's
+// tip without fetching anything. Asked on every `clipack list`, that would be
+// a round trip per followed repository per run, so the answer is cached and
+// the remote is asked again only once headCheckInterval has passed.
+const (
+	headCheckInterval = 15 * time.Minute
+	// headCheckTimeout bounds one ls-remote, so that a remote that does not
+	// answer holds up a listing for this long and no longer.
+	headCheckTimeout = 30 * time.Second
+	// maxParallelHeadChecks is how many remotes are asked at once.
+	maxParallelHeadChecks = 4
+)
+
+// headTip is one branch as it was last seen upstream.
+type headTip struct {
+	Commit  string
+	Checked time.Time
+}
+
+// headCachePath is where the tips are kept, beside the registry cache.
+func headCachePath(config *cnfg.Config) string {
+	return filepath.Join(config.Paths.Registry, "heads_cache.gob")
+}
+
+// headKey names the branch p follows: the repository and the branch in it.
+func headKey(p *Package) string {
+	return p.CloneURL() + " " + p.HeadBranch()
+}
+
+// CheckHeads fills in Upstream on every installed package that follows a
+// branch, which is what HasUpdate compares the commit it was built from with.
+// A tip checked within headCheckInterval is taken from the cache unless force
+// is set; offline, the cache is all there is, however old. A remote that
+// cannot be asked keeps the tip it was last seen at, and the failures are
+// returned together once every other package has its answer.
+func CheckHeads(config *cnfg.Config, installed map[string]*Package, force bool) error {
+	followed := followedHeads(installed)
+	if len(followed) == 0 {
+		return nil
+	}
+
+	tips := readHeadCache(config)
+	var stale []string
+	for key := range followed {
+		tip, ok := tips[key]
+		if config.Offline || (ok && !force && time.Since(tip.Checked) < headCheckInterval) {
+			continue
+		}
+		stale = append(stale, key)
+	}
+
+	var errs []error
+	if len(stale) > 0 {
+		type result struct {
+			commit string
+			err    error
+		}
+		results := make([]result, len(stale))
+		sem := make(chan struct{}, maxParallelHeadChecks)
+		var wg sync.WaitGroup
+		for i, key := range stale {
+			wg.Add(1)
+			go func(i int, p *Package) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				commit, err := lsRemote(p.CloneURL(), p.HeadBranch())
+				results[i] = result{commit: commit, err: err}
+			}(i, followed[key][0])
+		}
+		wg.Wait()
+
+		for i, key := range stale {
+			tip := tips[key]
+			// Checked either way: a remote that is down is not asked again
+			// on every run until it is back.
+			tip.Checked = time.Now()
+			if err := results[i].err; err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", followed[key][0].Name, err))
+			} else {
+				tip.Commit = results[i].commit
+			}
+			tips[key] = tip
+		}
+		if err := saveHeadCache(config, tips); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for key, packages := range followed {
+		for _, p := range packages {
+			p.Upstream = tips[key].Commit
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CachedHeads fills in Upstream as CheckHeads does, from the cache alone and
+// however old it is: for a listing read again after a build, which recorded
+// the tip it built and has no reason to ask the remote about the others.
+func CachedHeads(config *cnfg.Config, installed map[string]*Package) {
+	followed := followedHeads(installed)
+	if len(followed) == 0 {
+		return
+	}
+	tips := readHeadCache(config)
+	for key, packages := range followed {
+		for _, p := range packages {
+			p.Upstream = tips[key].Commit
+		}
+	}
+}
+
+// followedHeads groups the installed packages that follow a branch by the
+// branch they follow.
+func followedHeads(installed map[string]*Package) map[string][]*Package {
+	followed := map[string][]*Package{}
+	for _, p := range installed {
+		if p.InstallMethod == MethodHead && p.CustomRef == "" && p.CloneURL() != "" {
+			followed[headKey(p)] = append(followed[headKey(p)], p)
+		}
+	}
+	return followed
+}
+
+// recordHead notes the commit a head build just cloned as its branch's tip:
+// it is newer than whatever the cache says, and a cached tip older than the
+// build would otherwise offer it as an update until the cache ran out.
+func (in *Installer) recordHead(p *Package) {
+	if p.ResolvedCommit == "" || p.CloneURL() == "" {
+		return
+	}
+	tips := readHeadCache(in.Config)
+	tips[headKey(p)] = headTip{Commit: p.ResolvedCommit, Checked: time.Now()}
+	if err := saveHeadCache(in.Config, tips); err != nil {
+		in.warnf("could not record the tip of %s: %v", p.HeadBranch(), err)
+	}
+}
+
+// lsRemote asks url which commit branch is at, HEAD for the default branch.
+func lsRemote(url, branch string) (string, error) {
+	ref := branch
+	if branch != "HEAD" {
+		ref = "refs/heads/" + branch
+	}
+	commit, err := lsRemoteRef(url, ref)
+	if err == nil && commit == "" {
+		return "", fmt.Errorf("%s has no branch %s", url, branch)
+	}
+	return commit, err
+}
+
+// lsRemoteRef asks url which object the first of refs it has is at, or ""
+// when it has none of them.
+func lsRemoteRef(url string, refs ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), headCheckTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"ls-remote", "--quiet", url}, refs...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("git ls-remote %s: no answer in %s", url, headCheckTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git ls-remote: %s", msg)
+		}
+		return "", fmt.Errorf("git ls-remote: %w", err)
+	}
+
+	commit, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	return commit, nil
+}
+
+// readHeadCache returns the cached tips. A cache that is missing or cannot be
+// read is an empty one: every branch is simply asked about again.
+func readHeadCache(config *cnfg.Config) map[string]headTip {
+	tips := map[string]headTip{}
+	f, err := os.Open(headCachePath(config))
+	if err != nil {
+		return tips
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&tips); err != nil {
+		return map[string]headTip{}
+	}
+	return tips
+}
+
+// saveHeadCache writes the tips atomically, as saveLayerCache does the
+// registry.
+func saveHeadCache(config *cnfg.Config, tips map[string]headTip) error {
+	if err := os.MkdirAll(config.Paths.Registry, 0o755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(config.Paths.Registry, "heads_cache-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if err := gob.NewEncoder(tmp).Encode(tips); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encoding head cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, headCachePath(config)); err != nil {
+		return fmt.Errorf("writing head cache: %w", err)
+	}
+	return nil
+}
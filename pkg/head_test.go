@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeadInstallFollowsTheBranch(t *testing.T) {
+	repo, _, second := taggedRepo(t)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	if err := in.Install(clonedPackage(repo), MethodHead); err != nil {
+		t.Fatalf("Install(head) error = %v", err)
+	}
+	installed, err := InstalledMap(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := installed["tool"]
+	if tool.ResolvedCommit != second || tool.InstallMethod != MethodHead {
+		t.Fatalf("manifest %s at %s, want head at the tip %s", tool.InstallMethod, tool.ResolvedCommit, second)
+	}
+
+	// The registry moving its tag says nothing about a followed branch.
+	registry := clonedPackage(repo)
+	registry.Version = "v9"
+	if err := CheckHeads(config, installed, false); err != nil {
+		t.Fatalf("CheckHeads() error = %v", err)
+	}
+	if tool.Upstream != second || HasUpdate(registry, tool) {
+		t.Errorf("upstream %q, update %v; want the tip the build recorded and no update", tool.Upstream, HasUpdate(registry, tool))
+	}
+
+	git(t, repo, "commit", "--quiet", "--allow-empty", "-m", "three")
+	third := git(t, repo, "rev-parse", "HEAD")
+
+	// Checked moments ago, so the remote is not asked again until forced.
+	if err := CheckHeads(config, installed, false); err != nil {
+		t.Fatalf("CheckHeads() error = %v", err)
+	}
+	if HasUpdate(registry, tool) {
+		t.Error("HasUpdate() = true from a tip that was not checked again")
+	}
+	if err := CheckHeads(config, installed, true); err != nil {
+		t.Fatalf("CheckHeads(force) error = %v", err)
+	}
+	if tool.Upstream != third || !HasUpdate(registry, tool) {
+		t.Fatalf("upstream %q, update %v; want the new tip %s offered", tool.Upstream, HasUpdate(registry, tool), third)
+	}
+	if got := UpdateRef(registry, tool, MethodHead); got != "HEAD@"+third[:12] {
+		t.Errorf("UpdateRef() = %q, want the branch at its new tip", got)
+	}
+
+	if err := in.Update(clonedPackage(repo), MethodHead); err != nil {
+		t.Fatalf("Update(head) error = %v", err)
+	}
+	// Read again after the build, the tip it recorded is what it is
+	// compared with, without asking the remote.
+	installed, _ = InstalledMap(config)
+	CachedHeads(config, installed)
+	if tool := installed["tool"]; tool.Upstream != third || HasUpdate(registry, tool) {
+		t.Errorf("cached upstream %q, update %v; want the tip the update built", tool.Upstream, HasUpdate(registry, tool))
+	}
+	if err := CheckHeads(config, installed, false); err != nil {
+		t.Fatalf("CheckHeads() error = %v", err)
+	}
+	if tool := installed["tool"]; tool.ResolvedCommit != third || HasUpdate(registry, tool) {
+		t.Errorf("after the update at %s, update %v; want the tip and nothing to offer", tool.ResolvedCommit, HasUpdate(registry, tool))
+	}
+}
+
+func TestHeadInstallOfANamedBranch(t *testing.T) {
+	repo, first, _ := taggedRepo(t)
+	git(t, repo, "branch", "stable", first)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	p := clonedPackage(repo)
+	p.Install.Source.Branch = "stable"
+	steps := in.expandSteps(p, MethodHead)
+	if !strings.Contains(steps[0], "--branch 'stable'") {
+		t.Errorf("clone step = %q, want the branch cloned", steps[0])
+	}
+	if err := in.Install(p, MethodHead); err != nil {
+		t.Fatalf("Install(head) error = %v", err)
+	}
+	manifest, err := in.readManifest(in.pathsFor("tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ResolvedCommit != first || manifest.Ref(MethodHead) != "stable@"+first[:12] {
+		t.Errorf("manifest at %s, ref %q; want stable's tip %s", manifest.ResolvedCommit, manifest.Ref(MethodHead), first)
+	}
+}
+
+func TestCheckHeads(t *testing.T) {
+	repo, first, second := taggedRepo(t)
+	config := testConfig(t)
+	followed := func(branch string) map[string]*Package {
+		p := clonedPackage(repo)
+		p.InstallMethod, p.ResolvedCommit = MethodHead, first
+		p.Install.Source.Branch = branch
+		return map[string]*Package{"tool": p}
+	}
+
+	// Offline, nothing is asked, and with nothing cached nothing is known.
+	config.Offline = true
+	installed := followed("")
+	if err := CheckHeads(config, installed, true); err != nil || installed["tool"].Upstream != "" {
+		t.Errorf("CheckHeads() offline = %v, upstream %q; want the remote left alone", err, installed["tool"].Upstream)
+	}
+
+	config.Offline = false
+	if err := CheckHeads(config, installed, false); err != nil || installed["tool"].Upstream != second {
+		t.Fatalf("CheckHeads() = %v, upstream %q; want the default branch at %s", err, installed["tool"].Upstream, second)
+	}
+
+	// A branch the remote does not have is reported, and the others still
+	// get their answer.
+	missing := followed("")
+	other := followed("gone")["tool"]
+	other.Name = "other"
+	missing["other"] = other
+	err := CheckHeads(config, missing, true)
+	if err == nil || !strings.Contains(err.Error(), "other") {
+		t.Errorf("CheckHeads() error = %v, want the missing branch named", err)
+	}
+	if missing["tool"].Upstream != second || missing["other"].Upstream != "" {
+		t.Errorf("upstreams %q and %q, want only the branch that exists", missing["tool"].Upstream, missing["other"].Upstream)
+	}
+
+	// Neither a commit install nor a custom pin is followed.
+	pinned := followed("")
+	pinned["tool"].InstallMethod = MethodCommit
+	if err := CheckHeads(config, pinned, true); err != nil || pinned["tool"].Upstream != "" {
+		t.Errorf("CheckHeads() on a commit install = %v, upstream %q", err, pinned["tool"].Upstream)
+	}
+}
@@ -57,6 +57,9 @@ func DiscardReporter(Event) {}
 type Installer struct {
 	Config *cnfg.Config
 	Report Reporter
+	// IgnoreDependents lets Remove take away a package other installed
+	// packages depend on. It is for the caller that has asked the user.
+	IgnoreDependents bool
 
 	mu sync.Mutex
 	// tail holds the most recent output of the step being run, so a failure
@@ -219,6 +222,7 @@ func (in *Installer) resolveResource(res Resource, paths Paths) (string, string,
 		"build":    in.Config.Paths.Build,
 		"man":      in.Config.Paths.Man,
 		"registry": in.Config.Paths.Registry,
+		"sources":  SourcesDir(in.Config),
 	} {
 		if managed != "" && overlaps(dst, managed) {
 			return "", "", fmt.Errorf("resource target %q overlaps the %s directory", res.Target, name)
@@ -239,6 +243,43 @@ func (in *Installer) ResolveMethod(method string) string {
 	return MethodVersion
 }
 
+// IsCommit reports whether ref, pinned on p, is a commit rather than a tag or
+// branch. Seven to sixty-four hex digits read as a commit, but so does a tag
+// such as 20240101 or deadbeef, so a ref of that shape is looked up among the
+// source's tags and branches first and is a commit only when none has its
+// name. Offline, the source mirror stands in for the remote; with neither, or
+// a remote that cannot be asked, the shape decides.
+func (in *Installer) IsCommit(p *Package, ref string) bool {
+	if !isCommitLike(ref) {
+		return false
+	}
+	url := p.CloneURL()
+	if url == "" {
+		return true
+	}
+	refs := []string{"refs/tags/" + ref, "refs/heads/" + ref}
+
+	if in.Config.Offline && isRemote(url) {
+		mirror := in.mirrorFor(url)
+		if mirror == "" {
+			return true
+		}
+		for _, name := range refs {
+			if _, err := mirrorGitOutput(mirror, "rev-parse", "--verify", "--quiet", name); err == nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	found, err := lsRemoteRef(url, refs...)
+	if err != nil {
+		in.warnf("could not look %s up in %s, taking it for a commit: %v", ref, url, err)
+		return true
+	}
+	return found == ""
+}
+
 // Install builds and installs a package. Existing build directories are removed
 // without prompting; callers confirm beforehand.
 func (in *Installer) Install(p *Package, method string) error {
@@ -253,7 +294,16 @@ func (in *Installer) Install(p *Package, method string) error {
 // and took the installed ghostty with it; staging is what that cost.
 func (in *Installer) install(p *Package, method string, previous *Package) error {
 	method = in.ResolveMethod(method)
+	if err := CheckMethod(method); err != nil {
+		return err
+	}
+	if err := in.checkOffline(p, method); err != nil {
+		return err
+	}
 	paths := in.pathsFor(p.Name)
+	// Taken before anything is done to the entry: a release rewrites its
+	// install section to what the archive holds.
+	entryHash := p.ContentHash(method)
 
 	in.emit(Event{Kind: EventInfo, Package: p.Name,
 		Text: fmt.Sprintf("Installing %s (%s: %s)", p.Name, method, p.Ref(method))})
@@ -271,6 +321,14 @@ func (in *Installer) install(p *Package, method string, previous *Package) error
 		}
 	}
 
+	// Nothing is built for a release, so what the build needs does not apply.
+	if method != MethodRelease {
+		if err := in.checkBuildDepends(p); err != nil {
+			return err
+		}
+	}
+	in.warnMissingDepends(p)
+
 	if err := os.RemoveAll(paths.Build); err != nil {
 		return fmt.Errorf("removing build directory: %w", err)
 	}
@@ -280,8 +338,29 @@ func (in *Installer) install(p *Package, method string, previous *Package) error
 		}
 	}
 
-	if err := in.runSteps(p, method, paths.Build); err != nil {
-		return err
+	if method == MethodRelease {
+		if err := in.unpackRelease(p, paths.Build); err != nil {
+			return err
+		}
+		p.ResolvedCommit = ""
+	} else {
+		if err := in.unpackSource(p, method, paths.Build); err != nil {
+			return err
+		}
+		if err := checkSourceTools(p, method); err != nil {
+			return err
+		}
+		// Before the steps are written, since they clone from the mirror
+		// only when there is one.
+		in.prepareMirror(p, method)
+		resolved, err := in.runSteps(p, method, paths.Build)
+		if err != nil {
+			return err
+		}
+		p.ResolvedCommit = resolved
+		if method == MethodHead {
+			in.recordHead(p)
+		}
 	}
 
 	// Only now, with a finished build in hand, does the previous version go.
@@ -306,6 +385,7 @@ func (in *Installer) install(p *Package, method string, previous *Package) error
 	errs = append(errs, in.installAdditionalConfig(p, paths)...)
 
 	p.InstallMethod = method
+	p.EntryHash = entryHash
 	if err := in.writeManifest(p, paths); err != nil {
 		errs = append(errs, err)
 	}
@@ -354,9 +434,28 @@ func (in *Installer) Update(p *Package, method string) error {
 }
 
 // Remove uninstalls a package based on its installed manifest.
+//
+// A package another installed package needs at run time is refused with a
+// *DependedOnError unless IgnoreDependents is set; one that is only needed to
+// build others is removed with a warning, since what was built keeps working
+// until its next update.
 func (in *Installer) Remove(p *Package) error {
 	paths := in.pathsFor(p.Name)
 
+	if installed, err := InstalledMap(in.Config); err == nil {
+		users, builders := Dependents(installed, p.Name)
+		if len(users) > 0 && !in.IgnoreDependents {
+			return &DependedOnError{Name: p.Name, By: users}
+		}
+		if len(users) > 0 {
+			in.warnf("%s is still needed by %s", p.Name, strings.Join(users, ", "))
+		}
+		if len(builders) > 0 {
+			in.warnf("%s is needed to build %s; updating them will fail until it is installed again",
+				p.Name, strings.Join(builders, ", "))
+		}
+	}
+
 	in.emit(Event{Kind: EventInfo, Package: p.Name, Text: "Removing " + p.Name})
 	in.removeArtifacts(p, paths)
 
@@ -438,11 +537,26 @@ func (in *Installer) removeArtifacts(p *Package, paths Paths) {
 	}
 }
 
-// runSteps executes the install steps inside buildDir.
-func (in *Installer) runSteps(p *Package, method, buildDir string) error {
+// runSteps executes the install steps inside buildDir and returns the commit
+// the tree was built from, empty when nothing was cloned.
+//
+// HEAD is read right after the clone steps, before anything is built on top
+// of them, and compared with what the registry pinned; then once more after
+// the last step, since a step that clones or checks out again would otherwise
+// install something nobody reviewed under the name of something that was.
+func (in *Installer) runSteps(p *Package, method, buildDir string) (string, error) {
 	steps := in.expandSteps(p, method)
 	total := len(steps)
+	env := in.buildPath(p)
+
+	lastClone := -1
+	for i, step := range steps {
+		if isCloneStep(step) {
+			lastClone = i
+		}
+	}
 
+	var resolved string
 	for i, step := range steps {
 		in.emit(Event{Kind: EventStep, Package: p.Name, Step: i + 1, Total: total, Text: step})
 		// Reset per step: the failure is explained from the output of the step
@@ -451,12 +565,37 @@ func (in *Installer) runSteps(p *Package, method, buildDir string) error {
 		in.tail = newOutputTail(diagnosticTailLines)
 		in.mu.Unlock()
 
-		if err := in.runCommand(step, buildDir, p.Install.Environment); err != nil {
+		if err := in.runCommand(step, buildDir, env); err != nil {
 			in.explain()
-			return fmt.Errorf("step %d/%d %q failed: %w", i+1, total, step, err)
+			return "", fmt.Errorf("step %d/%d %q failed: %w", i+1, total, step, err)
+		}
+
+		if i == lastClone {
+			head, err := in.checkCheckout(p, method, buildDir, false)
+			if err != nil {
+				return "", err
+			}
+			resolved = head
+			in.infof("Checked out %s", head)
 		}
 	}
-	return nil
+
+	if lastClone >= 0 && lastClone < total-1 {
+		if _, err := in.checkCheckout(p, method, buildDir, true); err != nil {
+			return "", err
+		}
+	}
+	return resolved, nil
+}
+
+// checkCheckout is verifyCheckout with the mismatch explained to the user.
+func (in *Installer) checkCheckout(p *Package, method, buildDir string, afterBuild bool) (string, error) {
+	head, err := in.verifyCheckout(p, method, buildDir, afterBuild)
+	var mismatch *CommitMismatchError
+	if errors.As(err, &mismatch) {
+		in.emit(Event{Kind: EventHint, Package: p.Name, Text: mismatch.hint()})
+	}
+	return head, err
 }
 
 // diagnosticTailLines is how much of a step's output is kept for diagnosis.
@@ -470,35 +609,151 @@ const diagnosticTailLines = 400
 // update, so updates silently pulled the default branch HEAD.
 func (in *Installer) expandSteps(p *Package, method string) []string {
 	cloneURL := p.CloneURL()
+	// An archive for this ref has already been unpacked where the clone
+	// would have gone, so a clone step would only fail on the non-empty
+	// directory.
+	unpacked := p.Install.Source.Archive.For(method).URL != ""
+	mirror := in.mirrorFor(cloneURL)
 	var steps []string
 
 	for _, step := range p.Install.Steps {
+		if unpacked && cloneURLFromStep(step) != "" {
+			continue
+		}
 		if !strings.Contains(step, "git clone") || cloneURL == "" {
 			steps = append(steps, step)
 			continue
 		}
 
-		if method == MethodCommit && p.Commit != "" {
-			steps = append(steps,
-				fmt.Sprintf("git clone %s .", shellQuote(cloneURL)),
-				fmt.Sprintf("git checkout %s", shellQuote(p.Commit)),
-			)
-			continue
+		switch {
+		case method == MethodCommit && p.Commit != "":
+			switch {
+			case fullSHA(p.Commit) && (mirror == "" || !mirrorHasCommit(mirror, p.Commit)):
+				steps = append(steps, shallowFetch(cloneURL, p.Commit))
+			case mirror != "":
+				// A plain path rather than file://, so git hardlinks the
+				// mirror's objects instead of copying the whole history.
+				// Nothing is checked out but the commit, the only one whose
+				// files the mirror was filled in with.
+				steps = append(steps, in.mirroredClone(cloneURL, mirror, func(repo string) string {
+					return fmt.Sprintf("git clone --no-checkout %s .", repo)
+				})...)
+			default:
+				steps = append(steps, fmt.Sprintf("git clone %s .", shellQuote(cloneURL)))
+			}
+			steps = append(steps, fmt.Sprintf("git checkout %s", shellQuote(p.Commit)))
+		case method == MethodHead && p.Install.Source.Branch != "":
+			steps = append(steps, in.mirroredClone(cloneURL, shallowMirror(mirror), func(repo string) string {
+				return fmt.Sprintf("git clone --branch %s --single-branch --depth 1 %s .",
+					shellQuote(p.Install.Source.Branch), repo)
+			})...)
+		case method == MethodHead:
+			// The remote's default branch, which is what a clone naming no
+			// branch checks out.
+			steps = append(steps, in.mirroredClone(cloneURL, shallowMirror(mirror), func(repo string) string {
+				return fmt.Sprintf("git clone --depth 1 %s .", repo)
+			})...)
+		case p.Version != "":
+			steps = append(steps, in.mirroredClone(cloneURL, shallowMirror(mirror), func(repo string) string {
+				return fmt.Sprintf("git clone --branch %s --single-branch --depth 1 %s .",
+					shellQuote(p.Version), repo)
+			})...)
+		default:
+			steps = append(steps, in.mirroredClone(cloneURL, shallowMirror(mirror), func(repo string) string {
+				return fmt.Sprintf("git clone --depth 1 %s .", repo)
+			})...)
 		}
+		steps = append(steps, sourceSteps(p.Install.Source)...)
+	}
 
-		if p.Version != "" {
-			steps = append(steps, fmt.Sprintf(
-				"git clone --branch %s --single-branch --depth 1 %s .",
-				shellQuote(p.Version), shellQuote(cloneURL)))
-			continue
-		}
+	return steps
+}
 
-		steps = append(steps, fmt.Sprintf("git clone --depth 1 %s .", shellQuote(cloneURL)))
+// shallowMirror is the mirror as a shallow clone has to name it: file://, since
+// a clone from a plain path ignores --depth.
+func shallowMirror(mirror string) string {
+	if mirror == "" {
+		return ""
 	}
+	return "file://" + mirror
+}
 
+// sourceSteps fetch what the clone leaves out, once the ref is checked out:
+// the submodules at the commits it pins, and the LFS files, of the
+// submodules too when there are both.
+func sourceSteps(source Source) []string {
+	var steps []string
+	switch source.Submodules {
+	case SubmodulesFull:
+		steps = append(steps, "git submodule update --init --recursive")
+	case SubmodulesShallow:
+		steps = append(steps, "git submodule update --init --recursive --depth 1")
+	}
+	if source.LFS {
+		step := "git lfs pull"
+		if len(steps) > 0 {
+			step += " && git submodule foreach --recursive 'git lfs pull'"
+		}
+		steps = append(steps, step)
+	}
 	return steps
 }
 
+// checkSourceTools fails a build before it starts when its source needs
+// something the machine lacks: a submodules value clipack does not know, or
+// git-lfs for a source with LFS files, without which the build would quietly
+// run on the pointers.
+func checkSourceTools(p *Package, method string) error {
+	source := p.Install.Source
+	if source.Archive.For(method).URL != "" {
+		return nil
+	}
+	if err := checkSubmodules(source.Submodules); err != nil {
+		return fmt.Errorf("%s: %w", p.Name, err)
+	}
+	if source.LFS {
+		if _, err := exec.LookPath("git-lfs"); err != nil {
+			return fmt.Errorf("%s keeps files in Git LFS, which needs git-lfs, and it is not installed", p.Name)
+		}
+	}
+	return nil
+}
+
+// shallowFetch fetches the one commit a commit-pinned build needs rather than
+// the project's whole history. Asking for a commit by its SHA is up to the
+// server — protocol v2 allows it, an older or stricter one refuses what it
+// does not advertise — so a refusal falls back to the full clone the step used
+// to be. Either way the checkout that follows finds the commit.
+func shallowFetch(cloneURL, commit string) string {
+	return fmt.Sprintf("git init --quiet . && git remote add origin %[1]s && "+
+		"git fetch --quiet --depth 1 origin %[2]s || { rm -rf .git && git clone %[1]s .; }",
+		shellQuote(cloneURL), shellQuote(commit))
+}
+
+// fullSHA reports whether commit is a whole SHA-1 or SHA-256 object name, the
+// only form a server can be asked for; an abbreviated one needs the history
+// to be resolved against.
+func fullSHA(commit string) bool {
+	return (len(commit) == 40 || len(commit) == 64) && isCommitLike(commit)
+}
+
+// mirroredClone is the clone step clone writes for a repository, cloning from
+// the source's mirror when there is one. The network is the fallback for
+// a mirror that lacks the ref, unless clipack is offline and the mirror is all
+// there is. Either way origin ends up naming the source, not the mirror, for
+// whatever a build asks git about its remote.
+func (in *Installer) mirroredClone(cloneURL, mirror string, clone func(repo string) string) []string {
+	network := clone(shellQuote(cloneURL))
+	if mirror == "" {
+		return []string{network}
+	}
+	step := clone(shellQuote(mirror))
+	if !in.Config.Offline {
+		step += " || " + network
+	}
+	return []string{step, fmt.Sprintf("git remote set-url origin %s", shellQuote(cloneURL))}
+}
+
 // runCommand executes a step through a shell inside dir. Using a shell (rather
 // than strings.Fields) preserves quoting, pipes and && in registry steps, and
 // cmd.Dir replaces the old process-wide os.Chdir, which corrupted relative
@@ -858,8 +1113,12 @@ func (in *Installer) installAdditionalConfig(p *Package, paths Paths) []error {
 
 		var content []byte
 		switch {
+		case strings.HasPrefix(ac.Content, "https://") && in.Config.Offline:
+			errs = append(errs, fmt.Errorf("additional config %s: not downloading %s: %w",
+				ac.Filename, ac.Content, ErrOffline))
+			continue
 		case strings.HasPrefix(ac.Content, "https://"):
-			downloaded, err := utils.DownloadContent(ac.Content)
+			downloaded, err := in.Config.Network().DownloadContent(ac.Content)
 			if err != nil {
 				errs = append(errs, fmt.Errorf("downloading %s: %w", ac.Filename, err))
 				continue
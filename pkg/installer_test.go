@@ -119,6 +119,22 @@ func TestExpandSteps(t *testing.T) {
 		}
 	})
 
+	t.Run("a full commit is fetched on its own", func(t *testing.T) {
+		full := *p
+		full.Commit = "abcdef1234567890abcdef1234567890abcdef12"
+		steps := in.expandSteps(&full, MethodCommit)
+		if len(steps) != 3 {
+			t.Fatalf("got %d steps, want 3 (fetch, checkout, make): %v", len(steps), steps)
+		}
+		if !strings.Contains(steps[0], "git fetch --quiet --depth 1 origin '"+full.Commit+"'") ||
+			!strings.HasSuffix(steps[0], "|| { rm -rf .git && git clone 'https://example.com/tool.git' .; }") {
+			t.Errorf("fetch step = %q, want the one commit with the full clone as the fallback", steps[0])
+		}
+		if steps[1] != "git checkout '"+full.Commit+"'" {
+			t.Errorf("checkout step = %q", steps[1])
+		}
+	})
+
 	t.Run("commit method without a commit falls back to the version", func(t *testing.T) {
 		noCommit := &Package{
 			Version: "v1.2.3",
@@ -146,6 +162,32 @@ func TestExpandSteps(t *testing.T) {
 		}
 	})
 
+	t.Run("submodules and LFS follow the checkout", func(t *testing.T) {
+		withSource := *p
+		withSource.Install.Source.Submodules = SubmodulesShallow
+		withSource.Install.Source.LFS = true
+
+		for method, checkout := range map[string]int{MethodVersion: 0, MethodCommit: 1} {
+			steps := in.expandSteps(&withSource, method)
+			if len(steps) != checkout+4 {
+				t.Fatalf("%s: got %d steps, want the clone, submodules, LFS and make: %v", method, len(steps), steps)
+			}
+			if got := steps[checkout+1]; got != "git submodule update --init --recursive --depth 1" {
+				t.Errorf("%s: submodule step = %q", method, got)
+			}
+			if got := steps[checkout+2]; got != "git lfs pull && git submodule foreach --recursive 'git lfs pull'" {
+				t.Errorf("%s: LFS step = %q", method, got)
+			}
+		}
+
+		withSource.Install.Source.Submodules = SubmodulesFull
+		withSource.Install.Source.LFS = false
+		steps := in.expandSteps(&withSource, MethodVersion)
+		if len(steps) != 3 || steps[1] != "git submodule update --init --recursive" {
+			t.Errorf("steps = %v, want the submodules cloned in full", steps)
+		}
+	})
+
 	t.Run("unknown clone URL leaves the step alone", func(t *testing.T) {
 		unknown := &Package{
 			Version: "v1.0.0",
@@ -158,6 +200,30 @@ func TestExpandSteps(t *testing.T) {
 	})
 }
 
+func TestInstallWithLFSNeedsGitLFS(t *testing.T) {
+	if _, err := exec.LookPath("git-lfs"); err == nil {
+		t.Skip("git-lfs is installed")
+	}
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	p := &Package{
+		Name:    "demo",
+		Version: "v1.0.0",
+		Install: Install{
+			Source: Source{URL: "https://example.com/demo.git", LFS: true},
+			Steps:  []string{"git clone https://example.com/demo.git ."},
+		},
+	}
+	err := in.Install(p, MethodVersion)
+	if err == nil || !strings.Contains(err.Error(), "needs git-lfs") {
+		t.Fatalf("Install() error = %v, want git-lfs asked for before the clone", err)
+	}
+	if exists(SourcesDir(config)) {
+		t.Error("the source was mirrored before the check")
+	}
+}
+
 func TestRunCommandUsesShellAndDir(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("the shell syntax under test is POSIX")
@@ -387,6 +453,9 @@ func TestInstallEndToEnd(t *testing.T) {
 	if installed["demo"].InstallMethod != MethodVersion {
 		t.Errorf("recorded install_method = %q, want version", installed["demo"].InstallMethod)
 	}
+	if installed["demo"].EntryHash != buildablePackage().ContentHash(MethodVersion) {
+		t.Errorf("recorded entry_hash = %q, want the hash of the entry installed", installed["demo"].EntryHash)
+	}
 
 	// The step counter has to be complete and in order for the progress UI.
 	steps := rec.kinds(EventStep)
@@ -0,0 +1,313 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"gopkg.in/yaml.v3"
+)
+
+// Lint severities. An error is something an install would trip over or get
+// wrong; a warning is something that works but is probably not what was meant.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Finding is one problem LintRegistry found.
+type Finding struct {
+	// File is the path inside the registry, e.g. packages/cli/bat.yaml.
+	File string `json:"file"`
+	// Package is the entry's name, when the file got far enough to have one.
+	Package string `json:"package,omitempty"`
+	// Field is the YAML path of the offending value, e.g. install.expose[1].
+	Field    string `json:"field,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (f Finding) String() string {
+	where := f.File
+	if f.Field != "" {
+		where += ": " + f.Field
+	}
+	return fmt.Sprintf("%s: %s: %s", where, f.Severity, f.Message)
+}
+
+// toolchainRe is the shape of a requirements toolchain entry: a tool, and
+// optionally one comparison against a version — "pkg-config", "zig >= 0.16",
+// "go == 1.24.2".
+var toolchainRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*(\s+(==|!=|>=|<=|>|<)\s+[0-9][0-9A-Za-z._+-]*)?$`)
+
+// LintRegistry checks every entry of a registry's files for what parsePackages
+// lets through. Loading is deliberately forgiving — one broken entry must not
+// hide the other eighty — which is also why nothing there says what is wrong
+// with an entry until an install of it fails. This is the strict counterpart,
+// meant for the registry's own CI and for whoever is editing a checkout.
+//
+// config supplies the directories that resource targets are checked against,
+// so the containment rules are exactly the ones an install applies.
+func LintRegistry(config *cnfg.Config, files map[string][]byte) []Finding {
+	var findings []Finding
+	add := func(f Finding) { findings = append(findings, f) }
+
+	index, err := parseIndex(files)
+	if err != nil {
+		add(Finding{File: "index.yaml", Severity: SeverityError, Message: err.Error()})
+		return findings
+	}
+
+	in := NewInstaller(config, nil)
+	listed := map[string]bool{}
+	owners := map[string]string{} // binary name -> package that installs it
+	var parsed []*Package
+	fileOf := map[string]string{} // package name -> its file
+
+	for _, entry := range index.Packages {
+		file := strings.TrimPrefix(entry, "/")
+		if listed[file] {
+			add(Finding{File: "index.yaml", Severity: SeverityWarning,
+				Message: fmt.Sprintf("%s is listed twice", file)})
+			continue
+		}
+		listed[file] = true
+
+		data, ok := files[file]
+		if !ok {
+			add(Finding{File: "index.yaml", Severity: SeverityError,
+				Message: fmt.Sprintf("%s is listed but does not exist", file)})
+			continue
+		}
+
+		p, fileFindings := lintEntry(in, file, data)
+		findings = append(findings, fileFindings...)
+		if p == nil {
+			continue
+		}
+		parsed = append(parsed, p)
+		fileOf[p.Name] = file
+
+		for _, name := range p.BinaryNames() {
+			if other, ok := owners[name]; ok && other != p.Name {
+				add(Finding{File: file, Package: p.Name, Field: "install.binaries", Severity: SeverityError,
+					Message: fmt.Sprintf("binary %q is also installed by %s; one would overwrite the other in bin/", name, other)})
+				continue
+			}
+			owners[name] = p.Name
+		}
+	}
+
+	findings = append(findings, lintDependencies(parsed, fileOf)...)
+
+	// A package file the index does not list is never loaded, which is almost
+	// always an entry someone added and forgot to register.
+	for file := range files {
+		if strings.HasPrefix(file, "packages/") && !listed[file] {
+			add(Finding{File: file, Severity: SeverityWarning, Message: "not listed in index.yaml, so it is never loaded"})
+		}
+	}
+
+	// A signed registry whose entries changed after signing refuses to load
+	// on every machine that trusts its key.
+	if list, ok := files[DigestsFile]; ok {
+		if want, err := Digests(files); err == nil && !bytes.Equal(list, want) {
+			add(Finding{File: DigestsFile, Severity: SeverityError,
+				Message: "does not match the entries, so the registry no longer verifies; sign it again with clipack registry sign"})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].File < findings[j].File })
+	return findings
+}
+
+// lintEntry checks one package file. The package is returned when it parsed
+// well enough for the checks that span packages.
+func lintEntry(in *Installer, file string, data []byte) (*Package, []Finding) {
+	var findings []Finding
+	name := ""
+	add := func(field, severity, format string, args ...any) {
+		findings = append(findings, Finding{File: file, Package: name, Field: field, Severity: severity,
+			Message: fmt.Sprintf(format, args...)})
+	}
+
+	p, err := LoadPackageFromBytes(data)
+	if err != nil {
+		add("", SeverityError, "parsing: %v", err)
+		return nil, findings
+	}
+	name = p.Name
+
+	// Against the schema as well, for the misspelt key a lenient parse
+	// silently drops — "binary:" instead of "binaries:" installs nothing and
+	// says nothing.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err == nil {
+		for _, v := range ValidateSchema(PackageSchema(), file, &doc) {
+			msg := v.Message
+			if v.Line > 0 {
+				msg = fmt.Sprintf("line %d: %s", v.Line, msg)
+			}
+			add(v.Path, SeverityError, "%s", msg)
+		}
+	}
+
+	if p.Name == "" {
+		// A missing key is already reported by the schema; an empty one is not.
+		if len(findings) == 0 {
+			add("name", SeverityError, "name is empty; the entry is skipped when the registry loads")
+		}
+		return nil, findings
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(path.Base(file), ".yaml"), ".yml")
+	if base != p.Name {
+		add("name", SeverityWarning, "name %q does not match the file name %q", p.Name, path.Base(file))
+	}
+
+	// packages/<category>/<name>.yaml: the category is the directory, and a
+	// category field in the file is overwritten by it at load.
+	parts := strings.Split(file, "/")
+	switch {
+	case len(parts) < 3 || parts[0] != "packages":
+		add("", SeverityError, "not under packages/<category>/, so it has no category")
+	case p.Category != "" && p.Category != parts[len(parts)-2]:
+		add("category", SeverityWarning, "category %q is ignored; the directory says %q",
+			p.Category, parts[len(parts)-2])
+	}
+
+	// An archive replaces the clone for its ref; a ref without one still
+	// needs a repository to check out.
+	archives := p.Install.Source.Archive
+	needsClone := archives.Version.URL == "" || (p.Commit != "" && archives.Commit.URL == "")
+	// An entry that only publishes a release has nothing to build, so
+	// nothing to clone either.
+	if len(p.Install.Steps) == 0 && !p.Install.Release.Empty() {
+		needsClone = false
+	}
+	if needsClone && p.CloneURL() == "" {
+		add("install.source.url", SeverityError, "no clone URL: set install.source.url or start the steps with a git clone")
+	}
+	if err := checkSubmodules(p.Install.Source.Submodules); err != nil {
+		add("install.source.submodules", SeverityError, "%v", err)
+	}
+	for _, method := range []string{MethodVersion, MethodCommit} {
+		if a := archives.For(method); a.URL != "" {
+			if err := checkArchive(a); err != nil {
+				add("install.source.archive."+method, SeverityError, "%v", err)
+			}
+		}
+	}
+
+	if release := p.Install.Release; !release.Empty() {
+		if p.Version == "" {
+			add("install.release", SeverityError, "a release is the version's binaries, and there is no version")
+		}
+		for _, arch := range release.Arches() {
+			if _, err := release.resolve(p.Version, arch); err != nil {
+				add("install.release.assets."+arch, SeverityError, "%v", err)
+			}
+		}
+	}
+
+	for i, exposed := range p.Install.Expose {
+		if !containsName(p.BinaryNames(), exposed) {
+			add(fmt.Sprintf("install.expose[%d]", i), SeverityError,
+				"%q is not a binary the package installs (it installs %s)", exposed, strings.Join(p.BinaryNames(), ", "))
+		}
+	}
+
+	paths := in.pathsFor(p.Name)
+	for i, res := range p.Install.Resources {
+		if _, _, err := in.resolveResource(res, paths); err != nil {
+			add(fmt.Sprintf("install.resources[%d]", i), SeverityError, "%v", err)
+		}
+	}
+
+	for i, page := range p.Install.Man {
+		if _, ok := manTarget(paths.Man, page); !ok {
+			add(fmt.Sprintf("install.man[%d]", i), SeverityError,
+				"%q has no section suffix like .1 or .5, so it cannot be installed", page)
+		}
+	}
+
+	for i, ac := range p.Install.AdditionalConfig {
+		field := fmt.Sprintf("install.additional-config[%d]", i)
+		if _, err := under(paths.Config, ac.Filename); err != nil {
+			add(field+".filename", SeverityError, "%v", err)
+		}
+		if strings.HasPrefix(ac.Content, "http://") {
+			add(field+".content", SeverityError, "%s is plaintext http, which installs refuse; use https", ac.Content)
+		}
+	}
+
+	for _, set := range []struct {
+		field string
+		req   MethodRequirements
+	}{
+		{"requirements", p.Requirements.MethodRequirements},
+		{"requirements.version", p.Requirements.Version},
+		{"requirements.commit", p.Requirements.Commit},
+	} {
+		for i, tool := range set.req.Toolchain {
+			if !toolchainRe.MatchString(strings.TrimSpace(tool)) {
+				add(fmt.Sprintf("%s.toolchain[%d]", set.field, i), SeverityError,
+					"%q is not a requirement; write a tool and optionally a version, like \"zig >= 0.16\"", tool)
+			}
+		}
+	}
+
+	return p, findings
+}
+
+// lintDependencies checks what depends and build-depends name against the
+// rest of the registry: every name has to be an entry, and no chain of them may
+// lead back to where it started, or no install order exists.
+func lintDependencies(packages []*Package, fileOf map[string]string) []Finding {
+	var findings []Finding
+	for _, p := range packages {
+		for _, set := range []struct {
+			field string
+			names []string
+		}{
+			{"depends", p.Depends},
+			{"build-depends", p.BuildDepends},
+		} {
+			for i, name := range set.names {
+				field := fmt.Sprintf("%s[%d]", set.field, i)
+				switch {
+				case name == p.Name:
+					findings = append(findings, Finding{File: fileOf[p.Name], Package: p.Name, Field: field,
+						Severity: SeverityError, Message: "a package cannot depend on itself"})
+				case fileOf[name] == "":
+					findings = append(findings, Finding{File: fileOf[p.Name], Package: p.Name, Field: field,
+						Severity: SeverityError, Message: fmt.Sprintf("%q is not a package in this registry", name)})
+				}
+			}
+		}
+	}
+
+	var cycle *CycleError
+	if _, err := SortByDependencies(packages); errors.As(err, &cycle) && len(cycle.Cycle) > 2 {
+		first := cycle.Cycle[0]
+		findings = append(findings, Finding{File: fileOf[first], Package: first, Field: "depends",
+			Severity: SeverityError, Message: err.Error()})
+	}
+	return findings
+}
+
+// CountFindings returns how many findings are errors and how many warnings.
+func CountFindings(findings []Finding) (errs, warnings int) {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	return errs, warnings
+}
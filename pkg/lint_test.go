@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+// lintFiles builds a registry around the given package files, listing every
+// one of them in the index.
+func lintFiles(entries map[string]string) map[string][]byte {
+	files := map[string][]byte{}
+	var index strings.Builder
+	index.WriteString("packages:\n")
+	for name, content := range entries {
+		index.WriteString("  - " + name + "\n")
+		files[name] = []byte(content)
+	}
+	files["index.yaml"] = []byte(index.String())
+	return files
+}
+
+const cleanEntry = `name: bat
+version: v0.25.0
+requirements:
+  toolchain: ["cargo >= 1.74", pkg-config]
+install:
+  source:
+    url: https://github.com/sharkdp/bat.git
+  binaries: [target/release/bat]
+  expose: [bat]
+  man: [doc/bat.1]
+`
+
+func TestLintRegistryPassesACleanEntry(t *testing.T) {
+	findings := LintRegistry(testConfig(t), lintFiles(map[string]string{"packages/cli/bat.yaml": cleanEntry}))
+	if len(findings) != 0 {
+		t.Errorf("LintRegistry() = %v, want nothing to report", findings)
+	}
+}
+
+func TestLintRegistryFindings(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		entry string
+		field string
+		want  string
+	}{
+		{"unknown field", "packages/cli/bat.yaml",
+			strings.Replace(cleanEntry, "binaries:", "binary:", 1), "install.binary", "did you mean binaries"},
+		{"no category", "packages/bat.yaml", cleanEntry, "", "packages/<category>/"},
+		{"no clone url", "packages/cli/bat.yaml",
+			strings.Replace(cleanEntry, "url: https://github.com/sharkdp/bat.git", "url: \"\"", 1),
+			"install.source.url", "no clone URL"},
+		{"unknown expose", "packages/cli/bat.yaml",
+			strings.Replace(cleanEntry, "expose: [bat]", "expose: [batcat]", 1), "install.expose[0]", "batcat"},
+		{"escaping resource", "packages/cli/bat.yaml",
+			cleanEntry + "  resources:\n    - source: out\n      target: ../etc/bat\n", "install.resources[0]", "outside"},
+		{"man without section", "packages/cli/bat.yaml",
+			strings.Replace(cleanEntry, "doc/bat.1", "doc/bat.md", 1), "install.man[0]", "section"},
+		{"plaintext download", "packages/cli/bat.yaml",
+			cleanEntry + "  additional-config:\n    - filename: config.sh\n      content: http://example.com/c.sh\n",
+			"install.additional-config[0].content", "http"},
+		{"malformed requirement", "packages/cli/bat.yaml",
+			strings.Replace(cleanEntry, `"cargo >= 1.74"`, `"cargo at least 1.74"`, 1),
+			"requirements.toolchain[0]", "not a requirement"},
+		{"unknown submodules", "packages/cli/bat.yaml",
+			strings.Replace(cleanEntry, "    url: https://github.com/sharkdp/bat.git\n",
+				"    url: https://github.com/sharkdp/bat.git\n    submodules: recursive\n", 1),
+			"install.source.submodules", "use true, shallow or false"},
+		{"unverified archive", "packages/cli/bat.yaml",
+			strings.Replace(cleanEntry, "    url: https://github.com/sharkdp/bat.git\n",
+				"    url: https://github.com/sharkdp/bat.git\n    archive:\n      version:\n        url: https://example.com/bat.tar.gz\n", 1),
+			"install.source.archive.version", "no sha256"},
+		{"release without a checksum", "packages/cli/bat.yaml",
+			cleanEntry + "  release:\n    url: https://example.com/bat-{version}-{arch}.tar.gz\n    binaries: [bat]\n    assets:\n      x86_64: {}\n",
+			"install.release.assets.x86_64", "no sha256"},
+		{"broken yaml", "packages/cli/bat.yaml", "name: [bat\n", "", "parsing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := LintRegistry(testConfig(t), lintFiles(map[string]string{tt.file: tt.entry}))
+			for _, f := range findings {
+				if f.Severity == SeverityError && f.File == tt.file && f.Field == tt.field &&
+					strings.Contains(f.Message, tt.want) {
+					return
+				}
+			}
+			t.Errorf("LintRegistry() = %v, want an error at %q mentioning %q", findings, tt.field, tt.want)
+		})
+	}
+}
+
+func TestLintRegistryFindsBinariesTwoPackagesInstall(t *testing.T) {
+	files := lintFiles(map[string]string{
+		"packages/cli/bat.yaml":   cleanEntry,
+		"packages/cli/bat2.yaml":  strings.Replace(cleanEntry, "name: bat", "name: bat2", 1),
+		"packages/cli/other.yaml": "name: other\ninstall:\n  source:\n    url: https://example.com/o.git\n",
+	})
+
+	errs, _ := CountFindings(LintRegistry(testConfig(t), files))
+	if errs != 1 {
+		t.Errorf("got %d errors, want the shared bin/bat reported once", errs)
+	}
+}
+
+func TestLintRegistryChecksDependencies(t *testing.T) {
+	files := lintFiles(map[string]string{
+		"packages/cli/bat.yaml": strings.Replace(cleanEntry, "version:", "depends: [ghost]\nversion:", 1),
+		"packages/cli/a.yaml":   "name: a\ndepends: [b]\ninstall:\n  source:\n    url: https://example.com/a.git\n",
+		"packages/cli/b.yaml":   "name: b\nbuild-depends: [a]\ninstall:\n  source:\n    url: https://example.com/b.git\n",
+	})
+
+	var unknown, cycle bool
+	for _, f := range LintRegistry(testConfig(t), files) {
+		switch {
+		case f.Field == "depends[0]" && strings.Contains(f.Message, `"ghost"`):
+			unknown = true
+		// The cycle is named from whichever of the two the index lists first.
+		case strings.Contains(f.Message, "a → b → a") || strings.Contains(f.Message, "b → a → b"):
+			cycle = true
+		}
+	}
+	if !unknown || !cycle {
+		t.Errorf("unknown dependency reported = %v, cycle reported = %v; want both", unknown, cycle)
+	}
+}
+
+func TestLintRegistryWarnsAboutUnlistedFiles(t *testing.T) {
+	files := lintFiles(map[string]string{"packages/cli/bat.yaml": cleanEntry})
+	files["packages/cli/fd.yaml"] = []byte("name: fd\n")
+
+	findings := LintRegistry(testConfig(t), files)
+	if len(findings) != 1 || findings[0].Severity != SeverityWarning || findings[0].File != "packages/cli/fd.yaml" {
+		t.Errorf("LintRegistry() = %v, want one warning about the unlisted fd.yaml", findings)
+	}
+}
+
+func TestLintRegistryFindsAStaleDigestList(t *testing.T) {
+	files := lintFiles(map[string]string{"packages/cli/bat.yaml": cleanEntry})
+	list, err := Digests(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[DigestsFile] = list
+	if findings := LintRegistry(testConfig(t), files); len(findings) != 0 {
+		t.Fatalf("LintRegistry() = %v, want a current list to pass", findings)
+	}
+
+	files["packages/cli/bat.yaml"] = []byte(strings.Replace(cleanEntry, "v0.25.0", "v0.26.0", 1))
+	findings := LintRegistry(testConfig(t), files)
+	if len(findings) != 1 || findings[0].File != DigestsFile || findings[0].Severity != SeverityError {
+		t.Errorf("LintRegistry() = %v, want the digest list reported out of date", findings)
+	}
+}
@@ -0,0 +1,327 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"github.com/lvim-tech/clipack/utils"
+)
+
+// A source mirror is a bare repository kept per clone URL, so that an install
+// or an update fetches only what changed upstream since the last one and
+// clones from this machine. Without one every build starts from an empty
+// directory and a clone over the network.
+//
+// The mirror is a partial clone: it has the commits and trees of every branch
+// and tag, but the files only of the commits clipack has built. A commit and
+// its trees are a small part of what a project's history weighs; the files of
+// every version of it are most of the rest, and a build needs one version.
+// Before a build the mirror fetches the files of the commit it will check out
+// and nothing else, since a clone from it cannot fetch what it lacks.
+//
+// The mirror carries branches and tags and nothing else: a GitHub repository
+// also advertises every pull request under refs/pull, which is most of what a
+// `git clone --mirror` of a busy project would download.
+var mirrorRefspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// SourcesDir is where the mirrors live: under the base directory, beside the
+// build tree they are cloned into.
+func SourcesDir(config *cnfg.Config) string {
+	return filepath.Join(config.Paths.Base, "sources")
+}
+
+// mirrorPath names the mirror of url: readable enough to tell which source it
+// is from a directory listing, and suffixed with a hash of the URL itself, so
+// two URLs that read the same once sanitised still get a mirror each.
+func mirrorPath(config *cnfg.Config, url string) string {
+	sum := sha256.Sum256([]byte(url))
+	name := url
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+	name = strings.TrimSuffix(strings.TrimSuffix(name, "/"), ".git")
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+	return filepath.Join(SourcesDir(config), name+"-"+hex.EncodeToString(sum[:4])+".git")
+}
+
+// mirrorFor returns the mirror of url when there is one, or "".
+func (in *Installer) mirrorFor(url string) string {
+	if url == "" {
+		return ""
+	}
+	path := mirrorPath(in.Config, url)
+	if _, err := os.Stat(filepath.Join(path, "HEAD")); err != nil {
+		return ""
+	}
+	return path
+}
+
+// prepareMirror syncs the mirror of the source p's clone steps clone and
+// fills in the commit the build will check out. A build from an archive
+// clones nothing, and a local path is no faster to clone from a mirror than
+// from where it is. Nor is a mirror made for a commit named in full: the
+// history's commits and trees are more than a build that can fetch its one
+// commit needs. One that is there already is used when it has the commit.
+func (in *Installer) prepareMirror(p *Package, method string) {
+	url := p.CloneURL()
+	if url == "" || !isRemote(url) || p.Install.Source.Archive.For(method).URL != "" {
+		return
+	}
+	if method == MethodCommit && fullSHA(p.Commit) && in.mirrorFor(url) == "" {
+		return
+	}
+	for _, step := range p.Install.Steps {
+		if strings.Contains(step, "git clone") {
+			in.syncMirror(url)
+			in.fillMirror(url, mirrorRef(p, method))
+			return
+		}
+	}
+}
+
+// mirrorRef names, as the mirror can resolve it, the commit a build of p by
+// method checks out: the ones expandSteps clones.
+func mirrorRef(p *Package, method string) string {
+	switch {
+	case method == MethodCommit && p.Commit != "":
+		return p.Commit
+	case method == MethodHead && p.Install.Source.Branch != "":
+		return "refs/heads/" + p.Install.Source.Branch
+	case method == MethodHead:
+		return "HEAD"
+	case p.Version != "":
+		return p.Version
+	default:
+		return "HEAD"
+	}
+}
+
+// fillMirror fetches into the mirror of url the files of the commit ref names
+// that it does not have yet. A ref the mirror does not know, or files the
+// remote will not hand over one by one, leave the clone going to the network
+// as it would without a mirror.
+func (in *Installer) fillMirror(url, ref string) {
+	path := in.mirrorFor(url)
+	if path == "" || in.Config.Offline {
+		return
+	}
+	if fullSHA(ref) && !mirrorHasCommit(path, ref) {
+		return
+	}
+	commit, err := mirrorGitOutput(path, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return
+	}
+	// --missing=print lists what is absent instead of fetching it one object
+	// at a time, each line an object name behind a "?".
+	objects, err := mirrorGitOutput(path, "rev-list", "--objects", "--missing=print", commit)
+	if err != nil {
+		in.warnf("could not read the source mirror of %s: %v", url, err)
+		return
+	}
+	var missing []string
+	for _, line := range strings.Split(objects, "\n") {
+		if oid, ok := strings.CutPrefix(line, "?"); ok {
+			missing = append(missing, oid)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	// The fetch git itself runs for a partial clone's missing objects, in
+	// one round trip for all of them.
+	if _, err := mirrorGit(path, strings.NewReader(strings.Join(missing, "\n")+"\n"),
+		"fetch", "--quiet", "--no-tags", "--no-write-fetch-head", "--recurse-submodules=no",
+		"--filter=blob:none", "--stdin", "origin"); err != nil {
+		in.warnf("could not fetch %s into the source mirror of %s: %v", ref, url, err)
+	}
+}
+
+// syncMirror brings the mirror of url up to date before a build clones from
+// it, creating it on first use. Nothing here fails the install: a mirror that
+// cannot be fetched is still as good as it was after the last fetch, and
+// one that cannot be made leaves the clone going to the network as before.
+// Offline, the mirror is used as it stands.
+func (in *Installer) syncMirror(url string) {
+	if in.Config.Offline {
+		return
+	}
+	path := mirrorPath(in.Config, url)
+
+	if in.mirrorFor(url) != "" {
+		if err := runMirrorGit(path, "fetch", "--quiet", "--prune", "origin"); err != nil {
+			in.warnf("could not update the source mirror of %s, building from what it already has: %v", url, err)
+		}
+		return
+	}
+
+	in.infof("Mirroring %s", url)
+	if err := createMirror(path, url); err != nil {
+		// Half a mirror would be taken for a whole one next time.
+		_ = os.RemoveAll(path)
+		in.warnf("could not mirror %s, cloning it directly: %v", url, err)
+	}
+}
+
+func createMirror(path, url string) error {
+	if err := utils.EnsureDirectoryExists(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+	}
+	// A bare clone takes the branches and tags, and the remote's HEAD for a
+	// clone that names no ref, but keeps no refspec to fetch them again with.
+	// The filter is kept for every fetch after it; a server that does not
+	// support it sends the files as well, and the mirror is a whole one.
+	if err := runGit("", "clone", "--quiet", "--bare", "--filter=blob:none", url, path); err != nil {
+		return err
+	}
+	for _, refspec := range mirrorRefspecs {
+		if err := runMirrorGit(path, "config", "--add", "remote.origin.fetch", refspec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorHasCommit reports whether the mirror at path has commit, named in
+// full. rev-parse would fetch a commit the mirror lacks from the remote, as a
+// partial clone does for any object it is missing; rev-list with --missing
+// only looks.
+func mirrorHasCommit(path, commit string) bool {
+	_, err := mirrorGitOutput(path, "rev-list", "--no-walk", "--missing=allow-any", commit)
+	return err == nil
+}
+
+// runMirrorGit is runGit against a bare repository, which is its own git
+// directory and has no work tree.
+func runMirrorGit(path string, args ...string) error {
+	_, err := mirrorGitOutput(path, args...)
+	return err
+}
+
+func mirrorGitOutput(path string, args ...string) (string, error) {
+	return mirrorGit(path, nil, args...)
+}
+
+// mirrorGit runs git against a bare repository with stdin as its input.
+func mirrorGit(path string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = path
+	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_DIR="+path)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// SourceMirror is one mirror as `clipack cache sources` reports it.
+type SourceMirror struct {
+	Path string
+	// URL is the source the mirror fetches from; empty when the directory is
+	// not a mirror clipack can read.
+	URL string
+	// Size is the mirror's disk usage in bytes.
+	Size int64
+	// Fetched is when it was last brought up to date.
+	Fetched time.Time
+	// Users are the installed packages that build from the source, by name.
+	// A mirror nothing uses is what --prune removes.
+	Users []string
+}
+
+// SourceMirrors lists the mirrors under SourcesDir, sorted by URL, each with
+// the installed packages that build from it.
+func SourceMirrors(config *cnfg.Config, installed []*Package) ([]SourceMirror, error) {
+	entries, err := os.ReadDir(SourcesDir(config))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string][]string{}
+	for _, p := range installed {
+		if url := p.CloneURL(); url != "" {
+			users[url] = append(users[url], p.Name)
+		}
+	}
+
+	var mirrors []SourceMirror
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		m := SourceMirror{Path: filepath.Join(SourcesDir(config), entry.Name())}
+		m.URL, _ = mirrorGitOutput(m.Path, "config", "--get", "remote.origin.url")
+		m.Size = diskUsage(m.Path)
+		m.Fetched = lastFetch(m.Path)
+		m.Users = users[m.URL]
+		sort.Strings(m.Users)
+		mirrors = append(mirrors, m)
+	}
+	sort.Slice(mirrors, func(i, j int) bool { return mirrors[i].URL < mirrors[j].URL })
+	return mirrors, nil
+}
+
+// RemoveMirror deletes one mirror. The next build from its source clones over
+// the network and makes it again.
+func RemoveMirror(m SourceMirror) error {
+	if err := os.RemoveAll(m.Path); err != nil {
+		return fmt.Errorf("removing %s: %w", m.Path, err)
+	}
+	return nil
+}
+
+// lastFetch is when the mirror was last fetched: every fetch rewrites
+// FETCH_HEAD. A mirror that has none is dated by its directory.
+func lastFetch(path string) time.Time {
+	for _, name := range []string{"FETCH_HEAD", "."} {
+		if info, err := os.Stat(filepath.Join(path, name)); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// diskUsage adds up the size of every file under path. Whatever cannot be read
+// is not counted rather than failing the listing.
+func diskUsage(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceMirrorFeedsTheClone(t *testing.T) {
+	repo, first, second := taggedRepo(t)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+	url := "file://" + repo
+
+	in.syncMirror(url)
+	mirror := in.mirrorFor(url)
+	if mirror == "" {
+		t.Fatal("syncMirror() made no mirror")
+	}
+
+	p := clonedPackage(repo)
+	steps := in.expandSteps(p, MethodVersion)
+	if !strings.Contains(steps[0], "'file://"+mirror+"'") || !strings.Contains(steps[0], " || ") {
+		t.Errorf("clone step = %q, want the mirror with the source as the fallback", steps[0])
+	}
+
+	build := t.TempDir()
+	if _, err := in.runSteps(p, MethodVersion, build); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+	if head, _ := gitRevParse(build, "HEAD"); head != first {
+		t.Errorf("HEAD = %s, want the tag's commit %s", head, first)
+	}
+	if origin := git(t, build, "remote", "get-url", "origin"); origin != url {
+		t.Errorf("origin = %q, want the source rather than the mirror", origin)
+	}
+
+	// An update fetches what is new into the mirror and clones from there.
+	git(t, repo, "tag", "v2", second)
+	in.syncMirror(url)
+	if got, err := mirrorGitOutput(mirror, "rev-parse", "v2^{commit}"); err != nil || got != second {
+		t.Errorf("mirror v2 = %q, %v; want the fetched tag at %s", got, err, second)
+	}
+
+	// Abbreviated, so only the mirror's history can resolve it.
+	p.Commit = second[:12]
+	build = t.TempDir()
+	if _, err := in.runSteps(p, MethodCommit, build); err != nil {
+		t.Fatalf("runSteps(commit) error = %v", err)
+	}
+	if head, _ := gitRevParse(build, "HEAD"); head != second {
+		t.Errorf("HEAD = %s, want the pinned commit %s", head, second)
+	}
+}
+
+func TestSourceMirrorFallsBackToTheSource(t *testing.T) {
+	repo, _, second := taggedRepo(t)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+	in.syncMirror("file://" + repo)
+
+	// Tagged after the mirror was fetched, and not fetched since.
+	git(t, repo, "tag", "v2", second)
+	p := clonedPackage(repo)
+	p.Version = "v2"
+
+	build := t.TempDir()
+	if _, err := in.runSteps(p, MethodVersion, build); err != nil {
+		t.Fatalf("runSteps() error = %v, want the clone to go to the source", err)
+	}
+	if head, _ := gitRevParse(build, "HEAD"); head != second {
+		t.Errorf("HEAD = %s, want %s", head, second)
+	}
+}
+
+func TestOfflineInstallClonesFromAMirror(t *testing.T) {
+	repo, first, _ := taggedRepo(t)
+	config := testConfig(t)
+	config.Offline = true
+	in := NewInstaller(config, nil)
+
+	p := &Package{
+		Name:    "tool",
+		Version: "v1",
+		Install: Install{
+			Steps:    []string{"git clone https://example.com/tool.git .", "mkdir -p out && cp tool out/tool"},
+			Binaries: []string{"out/tool"},
+		},
+	}
+	if err := in.checkOffline(p, MethodVersion); err == nil {
+		t.Fatal("checkOffline() = nil with no mirror, want the download refused")
+	}
+
+	// A mirror made while online, standing in for the remote.
+	path := mirrorPath(config, p.CloneURL())
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, "", "clone", "--quiet", "--bare", repo, path)
+
+	if err := in.Install(p, MethodVersion); err != nil {
+		t.Fatalf("Install() offline with a mirror error = %v", err)
+	}
+	manifest, err := in.readManifest(in.pathsFor("tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ResolvedCommit != first {
+		t.Errorf("resolved_commit = %q, want %s", manifest.ResolvedCommit, first)
+	}
+}
+
+func TestSourceMirrors(t *testing.T) {
+	repo, _, _ := taggedRepo(t)
+	other, _, _ := taggedRepo(t)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+	in.syncMirror("file://" + repo)
+	in.syncMirror("file://" + other)
+
+	installed := []*Package{clonedPackage(repo)}
+	mirrors, err := SourceMirrors(config, installed)
+	if err != nil {
+		t.Fatalf("SourceMirrors() error = %v", err)
+	}
+	if len(mirrors) != 2 {
+		t.Fatalf("got %d mirrors, want 2", len(mirrors))
+	}
+	for _, m := range mirrors {
+		if m.Size == 0 || m.Fetched.IsZero() {
+			t.Errorf("%s: size %d, fetched %v; want both known", m.URL, m.Size, m.Fetched)
+		}
+		used := m.URL == "file://"+repo
+		if used != (len(m.Users) == 1 && m.Users[0] == "tool") {
+			t.Errorf("%s: users = %v", m.URL, m.Users)
+		}
+		if !used {
+			if err := RemoveMirror(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	mirrors, _ = SourceMirrors(config, installed)
+	if len(mirrors) != 1 || mirrors[0].URL != "file://"+repo {
+		t.Errorf("after removal = %v, want only the one in use", mirrors)
+	}
+}
+
+// remoteURL makes a URL that reads as a remote one stand for repo, so that
+// a build from it takes the mirror path, and lets repo serve what a partial
+// clone asks for, as GitHub does.
+func remoteURL(t *testing.T, repo string) string {
+	t.Helper()
+	git(t, repo, "config", "uploadpack.allowFilter", "true")
+	git(t, repo, "config", "uploadpack.allowAnySHA1InWant", "true")
+	const url = "https://example.com/tool.git"
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "url.file://"+repo+".insteadOf")
+	t.Setenv("GIT_CONFIG_VALUE_0", url)
+	return url
+}
+
+func TestSourceMirrorHoldsOnlyTheFilesItBuilt(t *testing.T) {
+	repo, first, _ := taggedRepo(t)
+	url := remoteURL(t, repo)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	p := clonedPackage(repo)
+	p.Install.Source.URL = url
+	p.Install.Steps[0] = "git clone " + url + " ."
+	if err := in.Install(p, MethodVersion); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	mirror := in.mirrorFor(url)
+	if mirror == "" {
+		t.Fatal("Install() made no mirror")
+	}
+	if got, _ := mirrorGitOutput(mirror, "config", "remote.origin.partialclonefilter"); got != "blob:none" {
+		t.Errorf("partialclonefilter = %q, want the mirror made without the files", got)
+	}
+
+	// Every commit is there, and of the files only the ones v1 built from.
+	if got, _ := mirrorGitOutput(mirror, "rev-list", "--all", "--count"); got != "2" {
+		t.Errorf("%s commits in the mirror, want both", got)
+	}
+	missing, err := mirrorGitOutput(mirror, "rev-list", "--objects", "--missing=print", "--all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "?" + git(t, repo, "rev-parse", "HEAD:tool"); !strings.Contains(missing, want) {
+		t.Errorf("the mirror has the files of the tip, want only those of %s", first)
+	}
+	if strings.Contains(missing, "?"+git(t, repo, "rev-parse", "v1:tool")) {
+		t.Error("the mirror lacks the files of the version it built")
+	}
+}
+
+func TestCommitInstallByFullSHA(t *testing.T) {
+	repo, _, second := taggedRepo(t)
+	url := remoteURL(t, repo)
+	config := testConfig(t)
+	config.Options.CleanupBuild = false
+	in := NewInstaller(config, nil)
+	p := clonedPackage(repo)
+	p.Install.Source.URL = url
+	p.Install.Steps[0] = "git clone " + url + " ."
+	build := in.pathsFor("tool").Build
+	missing := func(mirror, object string) bool {
+		t.Helper()
+		objects, err := mirrorGitOutput(mirror, "rev-list", "--objects", "--missing=print", "--all")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(objects, "?"+object)
+	}
+
+	// With no mirror the one commit is fetched, and no mirror is made for it.
+	p.Commit = second
+	if err := in.Install(p, MethodCommit); err != nil {
+		t.Fatalf("Install(commit) error = %v", err)
+	}
+	if in.mirrorFor(url) != "" {
+		t.Error("a mirror was made for a commit named in full")
+	}
+	if got := git(t, build, "rev-list", "--all", "--count"); got != "1" {
+		t.Errorf("%s commits in the build directory, want the one fetched", got)
+	}
+
+	// A mirror made by a version build has the commit, and builds it.
+	p.Commit = ""
+	if err := in.Update(p, MethodVersion); err != nil {
+		t.Fatalf("Update(version) error = %v", err)
+	}
+	mirror := in.mirrorFor(url)
+	if mirror == "" {
+		t.Fatal("Update(version) made no mirror")
+	}
+	p.Commit = second
+	if err := in.Update(p, MethodCommit); err != nil {
+		t.Fatalf("Update(commit) error = %v", err)
+	}
+	if head, _ := gitRevParse(build, "HEAD"); head != second {
+		t.Errorf("HEAD = %s, want the pinned commit %s", head, second)
+	}
+	if got := git(t, build, "rev-list", "--all", "--count"); got != "2" {
+		t.Errorf("%s commits in the build directory, want the mirror's history", got)
+	}
+	if missing(mirror, git(t, repo, "rev-parse", second+":tool")) {
+		t.Error("the mirror was not filled in with the commit it built")
+	}
+
+	// A commit no branch or tag reaches is not in the mirror, and is
+	// fetched from the source without being fetched into the mirror too.
+	loose := git(t, repo, "commit-tree", "-p", second, "-m", "loose", second+"^{tree}")
+	p.Commit = loose
+	if err := in.Update(p, MethodCommit); err != nil {
+		t.Fatalf("Update(commit) error = %v", err)
+	}
+	if head, _ := gitRevParse(build, "HEAD"); head != loose {
+		t.Errorf("HEAD = %s, want the pinned commit %s", head, loose)
+	}
+	if got := git(t, build, "rev-list", "--all", "--count"); got != "1" {
+		t.Errorf("%s commits in the build directory, want the one fetched", got)
+	}
+	if mirrorHasCommit(mirror, loose) {
+		t.Error("the commit was fetched into the mirror")
+	}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+)
+
+// ErrOffline is what anything that would reach the network returns in
+// offline mode, wrapped in a message saying what it would have fetched.
+var ErrOffline = errors.New("clipack is offline (--offline or " + cnfg.OfflineEnv + ")")
+
+// isRemote reports whether fetching location needs the network. A file://
+// URL and a path are on this machine; everything else — https, ssh, scp-style
+// host:path — is not.
+func isRemote(location string) bool {
+	return !strings.HasPrefix(location, "file://") && !filepath.IsAbs(location)
+}
+
+// loadOfflineLayer is loadLayer without a network: the cache is the registry,
+// however old, and a registry that is a directory is read as usual because
+// reading it never leaves the machine.
+func loadOfflineLayer(config *cnfg.Config, layer cnfg.RegistryLayer, registry Registry) ([]*Package, bool, error) {
+	if _, local := registry.(dirRegistry); local {
+		return nil, false, nil
+	}
+	cache, err := readLayerCache(config, layer)
+	if err != nil {
+		return nil, true, fmt.Errorf("there is no registry cache to use: %w", ErrOffline)
+	}
+	return cache.Packages, true, nil
+}
+
+// checkOffline fails an install that would download something, before any of
+// it is done: a clone from a remote, an archive source or a release asset.
+// A build that only needs the machine it runs on — a clone of a local path
+// or of a source mirror, an archive at a file:// URL — goes ahead. Steps that
+// fetch some other way, a cargo or go build pulling dependencies, are not
+// something clipack can see, and fail on their own.
+func (in *Installer) checkOffline(p *Package, method string) error {
+	if !in.Config.Offline {
+		return nil
+	}
+
+	var needs string
+	if method == MethodRelease {
+		if release, err := p.Install.Release.resolve(p.Version, hostArch); err == nil && isRemote(release.URL) {
+			needs = release.URL
+		}
+	} else if archive := p.Install.Source.Archive.For(method); archive.URL != "" {
+		if isRemote(archive.URL) {
+			needs = archive.URL
+		}
+	} else {
+		for _, step := range in.expandSteps(p, method) {
+			if url := stepRemote(step); url != "" && isRemote(url) {
+				needs = url
+				break
+			}
+		}
+	}
+
+	if needs == "" {
+		return nil
+	}
+	return fmt.Errorf("%s downloads %s to install: %w", p.Name, needs, ErrOffline)
+}
+
+// stepRemote is the repository a rewritten clone step reaches: the one it
+// clones, or for a shallow fetch the remote it adds. The steps quote it.
+func stepRemote(step string) string {
+	if url := cloneURLFromStep(step); url != "" {
+		return strings.Trim(url, "'")
+	}
+	if _, rest, ok := strings.Cut(step, "git remote add origin "); ok {
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			return strings.Trim(fields[0], "'")
+		}
+	}
+	return ""
+}
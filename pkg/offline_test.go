@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOfflineServesACacheOfAnyAge(t *testing.T) {
+	config := testConfig(t)
+	config.Registry.UpdateInterval = time.Nanosecond
+	if err := SaveToCache([]*Package{{Name: "cached-only"}}, config); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	config.Offline = true
+
+	// A refresh is what asks hardest for the network; offline it still gets
+	// the cache, with no error, because nothing failed.
+	packages, err := RefreshRegistry(config)
+	if err != nil {
+		t.Fatalf("RefreshRegistry() error = %v", err)
+	}
+	if len(packages) != 1 || packages[0].Name != "cached-only" {
+		t.Errorf("got %v, want the stale cache", packages)
+	}
+}
+
+func TestOfflineWithoutACache(t *testing.T) {
+	config := testConfig(t)
+	config.Offline = true
+
+	if _, err := LoadAllPackagesFromRegistry(config); !errors.Is(err, ErrOffline) {
+		t.Errorf("error = %v, want ErrOffline", err)
+	}
+}
+
+func TestOfflineStillReadsADirectoryRegistry(t *testing.T) {
+	config := testConfig(t)
+	config.Registry.URL = writeRegistryDir(t, registryFiles)
+	config.Offline = true
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err != nil {
+		t.Fatalf("LoadAllPackagesFromRegistry() error = %v", err)
+	}
+	if len(packages) != 2 {
+		t.Errorf("got %d packages, want the directory read as usual", len(packages))
+	}
+}
+
+func TestOfflineInstallRefusesDownloads(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name   string
+		p      *Package
+		method string
+	}{
+		{"remote clone", &Package{
+			Name: "demo", Version: "v1.0.0",
+			Install: Install{Steps: []string{"git clone https://example.com/demo.git ."}},
+		}, MethodVersion},
+		{"remote commit fetch", &Package{
+			Name: "demo", Commit: strings.Repeat("0a", 20),
+			Install: Install{Steps: []string{"git clone https://example.com/demo.git ."}},
+		}, MethodCommit},
+		{"archive source", archivePackage(Archive{URL: "https://example.com/demo.tar.gz", SHA256: sum}), MethodVersion},
+		{"release", &Package{
+			Name: "demo", Version: "v1.0.0",
+			Install: Install{Release: Release{
+				URL:      "https://example.com/demo-{arch}",
+				Binaries: []string{"demo"},
+				Assets:   map[string]ReleaseAsset{hostArch: {SHA256: sum}},
+			}},
+		}, MethodRelease},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			config.Offline = true
+			in := NewInstaller(config, nil)
+
+			err := in.Install(tt.p, tt.method)
+			if !errors.Is(err, ErrOffline) {
+				t.Fatalf("Install() error = %v, want ErrOffline", err)
+			}
+			if exists(filepath.Join(config.Paths.Build, "demo")) {
+				t.Error("the install got as far as the build directory")
+			}
+		})
+	}
+}
+
+func TestOfflineInstallBuildsFromThisMachine(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the shell syntax under test is POSIX")
+	}
+
+	config := testConfig(t)
+	config.Offline = true
+	in := NewInstaller(config, nil)
+
+	archive := serveArchive(t, "demo-1.0.0.tar.gz", tarGz(t, demoTree))
+	if err := in.Install(archivePackage(archive), MethodVersion); err != nil {
+		t.Fatalf("Install() of a file:// archive error = %v", err)
+	}
+	if !exists(filepath.Join(config.Paths.Bin, "demo")) {
+		t.Error("the binary was not installed")
+	}
+}
+
+func TestOfflineAdditionalConfigIsNotDownloaded(t *testing.T) {
+	config := testConfig(t)
+	config.Offline = true
+	in := NewInstaller(config, nil)
+
+	p := &Package{
+		Name: "demo",
+		Install: Install{
+			AdditionalConfig: []AdditionalConfig{
+				{Filename: "hook.sh", Content: "https://example.com/hook.sh"},
+				{Filename: "env.sh", Content: "export DEMO=1\n"},
+			},
+		},
+	}
+	paths := in.pathsFor(p.Name)
+	if err := os.MkdirAll(paths.Config, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := in.installAdditionalConfig(p, paths)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrOffline) {
+		t.Fatalf("installAdditionalConfig() errors = %v, want the download refused", errs)
+	}
+	if exists(filepath.Join(paths.Config, "hook.sh")) {
+		t.Error("hook.sh was written without its content")
+	}
+	if !exists(filepath.Join(paths.Config, "env.sh")) {
+		t.Error("inline content was refused too; it needs no network")
+	}
+}
@@ -0,0 +1,225 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"gopkg.in/yaml.v3"
+)
+
+// OverlayOrigin is the Origin of a package defined in the overlay directory.
+const OverlayOrigin = "local"
+
+// LoadOverlay reads the package definitions in an overlay directory, laid out
+// like a registry's packages/ tree: <category>/<name>.yaml. A file directly in
+// the directory has no category of its own and keeps the one of the entry it
+// replaces.
+//
+// A missing directory is not an error — it is the state of every machine that
+// never used one. A file that does not parse is skipped and reported, with the
+// rest still returned: one typo should not hide every other override.
+func LoadOverlay(dir string) ([]*Package, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	var packages []*Package
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && path != dir && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		name := entry.Name()
+		if !entry.Type().IsRegular() ||
+			(!strings.HasSuffix(name, ".yaml") && !strings.HasSuffix(name, ".yml")) {
+			return nil
+		}
+
+		p, err := loadOverlayFile(dir, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("overlay %s: %w", path, err))
+			return nil
+		}
+		packages = append(packages, p)
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("reading overlay %s: %w", dir, err))
+	}
+	return packages, errors.Join(errs...)
+}
+
+// loadOverlayFile parses one overlay file and fills in what its place in the
+// tree says: the category from the directory, and the name from the file when
+// the YAML leaves it out.
+func loadOverlayFile(root, path string) (*Package, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxRegistryFileSize {
+		return nil, fmt.Errorf("exceeds %d bytes", maxRegistryFileSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := LoadPackageFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(path)
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(strings.TrimSuffix(base, ".yaml"), ".yml")
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err == nil && rel != "." {
+		p.Category = filepath.ToSlash(rel)
+	}
+	p.Origin = OverlayOrigin
+	p.Overlay = path
+	return p, nil
+}
+
+// applyOverlay lays the overlay directory over a merged package set. An
+// override replaces the registry's entry of the same name where it stood, and
+// one the registry does not have is appended, the same rules a registry layer
+// follows: the overlay is the top layer, kept on disk instead of in a remote.
+//
+// It is applied after every load, cached or fetched, and never cached itself,
+// so an edit to an override shows the next time clipack starts rather than
+// after the registry cache expires. The input slice is left alone — it may be
+// the very one a layer cache was written from.
+func applyOverlay(packages []*Package, config *cnfg.Config) ([]*Package, error) {
+	overrides, err := LoadOverlay(config.Paths.Packages)
+	if len(overrides) == 0 {
+		return packages, err
+	}
+
+	merged := make([]*Package, len(packages), len(packages)+len(overrides))
+	copy(merged, packages)
+	position := make(map[string]int, len(merged))
+	for i, p := range merged {
+		position[p.Name] = i
+	}
+	for _, p := range overrides {
+		if i, ok := position[p.Name]; ok {
+			if p.Category == "" {
+				p.Category = merged[i].Category
+			}
+			merged[i] = p
+			continue
+		}
+		position[p.Name] = len(merged)
+		merged = append(merged, p)
+	}
+	return merged, err
+}
+
+// OverridePath is where a local override of p lives: the overlay directory,
+// under the same category the registry files it in.
+func OverridePath(config *cnfg.Config, p *Package) string {
+	return filepath.Join(config.Paths.Packages, filepath.FromSlash(p.Category), p.Name+".yaml")
+}
+
+// WriteOverride materialises p as a local override and returns its path, and
+// whether the file was created just now. An entry that already comes from the
+// overlay is left as it is — that file is the override — so editing twice picks
+// up where the first edit stopped.
+//
+// What is written is the definition alone. The fields a manifest adds on top —
+// the method it was built with, the links chosen by hand, where it came from —
+// describe one installation, and copying them into a definition would have
+// every later install inherit them.
+func WriteOverride(config *cnfg.Config, p *Package) (string, bool, error) {
+	if p.Overlay != "" {
+		return p.Overlay, false, nil
+	}
+	if config.Paths.Packages == "" {
+		return "", false, errors.New("no overlay directory configured (paths.packages)")
+	}
+
+	def := *p
+	def.InstallMethod = ""
+	def.Exposed = nil
+	def.Unexposed = nil
+	def.Origin = ""
+	def.ResolvedCommit = ""
+	def.Overlay = ""
+	data, err := yaml.Marshal(&def)
+	if err != nil {
+		return "", false, fmt.Errorf("encoding %s: %w", p.Name, err)
+	}
+
+	path := OverridePath(config, p)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", false, fmt.Errorf("creating overlay directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", false, fmt.Errorf("writing override: %w", err)
+	}
+	return path, true, nil
+}
+
+// RemoveOverride deletes every local override of the named package and returns
+// the files it removed. Every one, not the first: two files defining the same
+// name are both in effect as far as the user can tell, and dropping only one
+// would leave the reset looking like it did nothing.
+func RemoveOverride(config *cnfg.Config, name string) ([]string, error) {
+	overrides, _ := LoadOverlay(config.Paths.Packages)
+
+	var removed []string
+	for _, p := range overrides {
+		if p.Name != name {
+			continue
+		}
+		if err := os.Remove(p.Overlay); err != nil {
+			return removed, fmt.Errorf("removing override: %w", err)
+		}
+		removed = append(removed, p.Overlay)
+	}
+	if len(removed) == 0 {
+		return nil, fmt.Errorf("%s has no local override", name)
+	}
+	return removed, nil
+}
+
+// ValidateOverride parses an edited definition and checks it the way an
+// install would, so a mistake is reported while the file is still open rather
+// than halfway through a build: the YAML must parse and name a package, every
+// name in install.expose must be a binary the package installs, and every
+// resource must stay inside the directories it is relative to.
+func (in *Installer) ValidateOverride(data []byte) (*Package, error) {
+	p, err := LoadPackageFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		return nil, errors.New("name is empty")
+	}
+
+	var errs []error
+	if unknown := UnknownExpose(p, p.Install.Expose); len(unknown) > 0 {
+		errs = append(errs, fmt.Errorf("install.expose names %s, which the package does not install (it installs %s)",
+			strings.Join(unknown, ", "), strings.Join(p.BinaryNames(), ", ")))
+	}
+	paths := in.pathsFor(p.Name)
+	for _, res := range p.Install.Resources {
+		if _, _, err := in.resolveResource(res, paths); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return p, errors.Join(errs...)
+}
@@ -0,0 +1,237 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lvim-tech/clipack/cnfg"
+)
+
+// overlayConfig serves registryFiles from a directory and points the overlay
+// at files laid out under a directory of its own.
+func overlayConfig(t *testing.T, overlay map[string]string) (*cnfg.Config, string) {
+	t.Helper()
+
+	config := testConfig(t)
+	config.Registry.URL = "file://" + writeRegistryDir(t, registryFiles)
+	config.Paths.Packages = writeRegistryDir(t, overlay)
+	return config, config.Paths.Packages
+}
+
+func TestOverlayReplacesAndAddsPackages(t *testing.T) {
+	config, dir := overlayConfig(t, map[string]string{
+		"cli/bat.yaml":   "name: bat\nversion: v0.26.0-mine\n",
+		"dev/tool.yaml":  "version: v1.0.0\n",
+		"notes.txt":      "not a package",
+		".hidden/x.yaml": "name: hidden\n",
+	})
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err != nil {
+		t.Fatalf("LoadAllPackagesFromRegistry() error = %v", err)
+	}
+	if len(packages) != 3 {
+		t.Fatalf("got %d packages, want bat, yazi and tool", len(packages))
+	}
+
+	// Replaced where it stood, not moved to the end.
+	bat := packages[0]
+	if bat.Name != "bat" || bat.Version != "v0.26.0-mine" {
+		t.Errorf("packages[0] = %+v, want the local bat", bat)
+	}
+	if bat.Origin != OverlayOrigin || bat.Overlay != filepath.Join(dir, "cli", "bat.yaml") {
+		t.Errorf("bat origin = %q, overlay = %q, want it marked as local", bat.Origin, bat.Overlay)
+	}
+	if bat.Description != "" {
+		t.Error("the override was merged into the registry entry, want it to replace it")
+	}
+
+	// The name comes from the file when the YAML leaves it out, the category
+	// from the directory.
+	tool := FindByName(packages, "tool")
+	if tool == nil || tool.Category != "dev" {
+		t.Errorf("tool = %+v, want it added in dev", tool)
+	}
+	if FindByName(packages, "hidden") != nil {
+		t.Error("a file under a dot directory was read as an override")
+	}
+	if yazi := FindByName(packages, "yazi"); yazi == nil || yazi.Overlay != "" {
+		t.Errorf("yazi = %+v, want the registry's entry untouched", yazi)
+	}
+}
+
+func TestOverlayAtTheTopKeepsTheRegistryCategory(t *testing.T) {
+	config, _ := overlayConfig(t, map[string]string{"bat.yaml": "name: bat\nversion: v1\n"})
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bat := FindByName(packages, "bat"); bat.Category != "cli" {
+		t.Errorf("bat category = %q, want the registry's cli", bat.Category)
+	}
+}
+
+func TestOverlayIsNeverCached(t *testing.T) {
+	config, dir := overlayConfig(t, map[string]string{"cli/bat.yaml": "name: bat\nversion: v1\n"})
+
+	if _, err := LoadAllPackagesFromRegistry(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Editing the override shows on the next load, from the cache.
+	if err := os.WriteFile(filepath.Join(dir, "cli", "bat.yaml"), []byte("name: bat\nversion: v2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	packages, err := LoadFromCache(config)
+	if err != nil {
+		t.Fatalf("LoadFromCache() error = %v", err)
+	}
+	if bat := FindByName(packages, "bat"); bat.Version != "v2" {
+		t.Errorf("bat version = %q, want the edited override", bat.Version)
+	}
+
+	// And removing it brings the registry's entry back.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	packages, _ = LoadFromCache(config)
+	if bat := FindByName(packages, "bat"); bat.Version != "v0.25.0" || bat.Overlay != "" {
+		t.Errorf("bat = %+v, want the registry's entry once the override is gone", bat)
+	}
+}
+
+func TestOverlayOutlivesAnUnreachableRegistry(t *testing.T) {
+	config, _ := overlayConfig(t, map[string]string{"dev/tool.yaml": "name: tool\nversion: v1.0.0\n"})
+	config.Registry.URL = "file://" + filepath.Join(t.TempDir(), "absent")
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err == nil {
+		t.Error("error = nil, want the registry that could not be read reported")
+	}
+	if len(packages) != 1 || packages[0].Name != "tool" || packages[0].Origin != OverlayOrigin {
+		t.Errorf("packages = %v, want the local tool without the registry", packages)
+	}
+
+	// Nor does it need a registry configured at all.
+	config.Registry.URL = ""
+	packages, err = LoadAllPackagesFromRegistry(config)
+	if !errors.Is(err, cnfg.ErrNoRegistry) || FindByName(packages, "tool") == nil {
+		t.Errorf("LoadAllPackagesFromRegistry() = %v, %v; want tool and the missing registry reported", packages, err)
+	}
+}
+
+func TestOverlayReportsABrokenFileAndKeepsTheRest(t *testing.T) {
+	config, _ := overlayConfig(t, map[string]string{
+		"cli/bat.yaml":  "name: [unterminated\n",
+		"dev/tool.yaml": "name: tool\n",
+	})
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err == nil {
+		t.Error("error = nil, want the broken override reported")
+	}
+	if FindByName(packages, "tool") == nil {
+		t.Error("a broken override hid the others")
+	}
+	if bat := FindByName(packages, "bat"); bat == nil || bat.Overlay != "" {
+		t.Errorf("bat = %+v, want the registry's entry in place of the broken override", bat)
+	}
+}
+
+func TestOverlayMissingDirectory(t *testing.T) {
+	packages, err := LoadOverlay(filepath.Join(t.TempDir(), "absent"))
+	if err != nil || len(packages) != 0 {
+		t.Errorf("LoadOverlay() = %v, %v, want nothing and no error", packages, err)
+	}
+}
+
+func TestOverlayVersionDrivesHasUpdate(t *testing.T) {
+	config, _ := overlayConfig(t, map[string]string{"cli/bat.yaml": "name: bat\nversion: v0.26.0\n"})
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed := &Package{Name: "bat", Version: "v0.25.0", InstallMethod: MethodVersion}
+	if !HasUpdate(FindByName(packages, "bat"), installed) {
+		t.Error("HasUpdate() = false, want the override's newer version to count")
+	}
+}
+
+func TestWriteOverrideLeavesTheInstallationOut(t *testing.T) {
+	config := testConfig(t)
+	config.Paths.Packages = filepath.Join(t.TempDir(), "packages")
+
+	manifest := &Package{
+		Name: "bat", Version: "v1", Category: "cli", Origin: "team",
+		InstallMethod: MethodCommit, Exposed: []string{"bat"},
+	}
+	path, created, err := WriteOverride(config, manifest)
+	if err != nil {
+		t.Fatalf("WriteOverride() error = %v", err)
+	}
+	if !created || path != filepath.Join(config.Paths.Packages, "cli", "bat.yaml") {
+		t.Errorf("WriteOverride() = %s, %v, want a new file under cli/", path, created)
+	}
+
+	overrides, err := LoadOverlay(config.Paths.Packages)
+	if err != nil || len(overrides) != 1 {
+		t.Fatalf("LoadOverlay() = %v, %v, want the one override", overrides, err)
+	}
+	if p := overrides[0]; p.InstallMethod != "" || len(p.Exposed) != 0 || p.Version != "v1" {
+		t.Errorf("override = %+v, want the definition without the installation's fields", p)
+	}
+
+	// An entry that already is an override is the file to edit.
+	if again, created, _ := WriteOverride(config, overrides[0]); again != path || created {
+		t.Errorf("WriteOverride() on an override = %s, %v, want the same file untouched", again, created)
+	}
+}
+
+func TestRemoveOverride(t *testing.T) {
+	config := testConfig(t)
+	config.Paths.Packages = writeRegistryDir(t, map[string]string{
+		"cli/bat.yaml": "name: bat\n",
+		"bat.yaml":     "name: bat\n",
+		"dev/fd.yaml":  "name: fd\n",
+	})
+
+	removed, err := RemoveOverride(config, "bat")
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("RemoveOverride() = %v, %v, want both files defining bat removed", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(config.Paths.Packages, "dev", "fd.yaml")); err != nil {
+		t.Error("another package's override was removed")
+	}
+	if _, err := RemoveOverride(config, "bat"); err == nil {
+		t.Error("RemoveOverride() error = nil, want it to say there is nothing to remove")
+	}
+}
+
+func TestValidateOverride(t *testing.T) {
+	in := NewInstaller(testConfig(t), nil)
+
+	tests := []struct {
+		name string
+		yaml string
+		ok   bool
+	}{
+		{"valid", "name: bat\ninstall:\n  binaries: [out/bat]\n  expose: [bat]\n", true},
+		{"broken yaml", "name: [bat\n", false},
+		{"no name", "version: v1\n", false},
+		{"unknown expose", "name: bat\ninstall:\n  binaries: [out/bat]\n  expose: [batcat]\n", false},
+		{"escaping resource", "name: bat\ninstall:\n  resources:\n    - source: out\n      target: ../../etc\n", false},
+		{"shared resource", "name: bat\ninstall:\n  resources:\n    - source: out\n      target: lib\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := in.ValidateOverride([]byte(tt.yaml))
+			if (err == nil) != tt.ok {
+				t.Errorf("ValidateOverride() error = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
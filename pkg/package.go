@@ -8,12 +8,22 @@
 // The files are split as:
 //
 //	package.go   - the Package type and helpers over it
-//	registry.go  - fetching packages from the registry over HTTP
+//	registry.go  - loading and layering registries, and GitHub over HTTP
+//	source.go    - the Registry interface: GitHub, any git remote, a directory
+//	overlay.go   - local package definitions laid over the registries
+//	depends.go   - dependencies between packages and the order they imply
+//	archive.go   - release archives as a source, verified and unpacked
+//	release.go   - prebuilt binaries, the release install method
+//	verify.go    - checking the checked-out tree against the registry's pin
+//	lint.go      - strict checks over a registry's entries
+//	schema.go    - the JSON Schema generated from these types, and validation
 //	cache.go     - the local registry cache
 //	installer.go - building and installing a package
 package pkg
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -26,13 +36,32 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// Install method identifiers.
+// Install method identifiers. Release installs the prebuilt binaries of the
+// version rather than building it. Head builds the tip of a branch, whatever
+// the registry pins, and is updated when that branch moves upstream.
 const (
 	MethodVersion = "version"
 	MethodCommit  = "commit"
+	MethodRelease = "release"
+	MethodHead    = "head"
 )
 
-// Source is where the package sources come from: one URL, and nothing else.
+// Methods lists the install methods in the order the interfaces cycle them.
+var Methods = []string{MethodVersion, MethodCommit, MethodRelease, MethodHead}
+
+// CheckMethod refuses a method clipack does not know. Without it a typo
+// silently built the version, which is what every unknown name fell back to.
+func CheckMethod(method string) error {
+	for _, known := range Methods {
+		if method == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown install method %q: use %s", method, strings.Join(Methods, ", "))
+}
+
+// Source is where the package sources come from: a git URL, and for projects
+// that only publish release tarballs, an archive per ref.
 //
 // It carried two more fields, both parsed and never read, and both removed once
 // that was checked field by field against the rest of the schema — which came
@@ -45,13 +74,50 @@ const (
 //   - `type` said `git` in 81 entries and `script` in two. Nothing branched on
 //     it — the steps say what to do, and every entry's first step is a clone
 //     regardless of which word was here.
+//
+// Archive is not `type` coming back: it is read, and what it says replaces the
+// clone for the ref it names.
+//
+// Submodules and LFS are what a plain clone leaves out. An entry used to add
+// the steps for them by hand, after a clone step that expandSteps rewrites
+// and a checkout it adds, so the hand-written steps ran against whatever ref
+// they happened to land on; said here, they run after the checkout.
 type Source struct {
-	URL string `yaml:"url,omitempty"`
+	URL     string   `yaml:"url,omitempty"`
+	Archive Archives `yaml:"archive,omitempty"`
+	// Submodules checks out the submodules at the commits the source pins:
+	// true clones each in full, "shallow" only the commit it needs.
+	Submodules string `yaml:"submodules,omitempty"`
+	// LFS replaces the Git LFS pointers of the checkout with the files.
+	LFS bool `yaml:"lfs,omitempty"`
+	// Branch is the branch the head method follows; the remote's default
+	// branch when empty.
+	Branch string `yaml:"branch,omitempty"`
+}
+
+// The values install.source.submodules takes. A YAML true decodes into the
+// string field as "true"; false, or no value, leaves the submodules alone.
+const (
+	SubmodulesFull    = "true"
+	SubmodulesShallow = "shallow"
+	SubmodulesNone    = "false"
+)
+
+// checkSubmodules refuses a submodules value clipack does not know, which
+// would otherwise be read as no submodules at all.
+func checkSubmodules(value string) error {
+	switch value {
+	case "", SubmodulesNone, SubmodulesFull, SubmodulesShallow:
+		return nil
+	}
+	return fmt.Errorf("submodules is %q: use true, shallow or false", value)
 }
 
 // Install holds the installation steps and related data.
 type Install struct {
-	Source      Source            `yaml:"source,omitempty"`
+	Source Source `yaml:"source,omitempty"`
+	// Release is what the release method installs instead of running Steps.
+	Release     Release           `yaml:"release,omitempty"`
 	Environment map[string]string `yaml:"environment,omitempty"`
 	Steps       []string          `yaml:"steps,omitempty"`
 	Binaries    []string          `yaml:"binaries,omitempty"`
@@ -142,10 +208,15 @@ func (r MethodRequirements) Empty() bool {
 }
 
 // For returns what a build with the given method needs: the shared set plus
-// whatever that method adds.
+// whatever that method adds. A release is not built, so it needs none of it.
 func (r Requirements) For(method string) MethodRequirements {
+	if method == MethodRelease {
+		return MethodRequirements{}
+	}
 	extra := r.Version
-	if method == MethodCommit {
+	// A branch tip is unreleased code, which needs what the pinned commit
+	// does rather than what the last tag did.
+	if method == MethodCommit || method == MethodHead {
 		extra = r.Commit
 	}
 	return MethodRequirements{
@@ -244,16 +315,28 @@ type Script struct {
 
 // Package holds the package data.
 type Package struct {
-	Name        string    `yaml:"name"`
-	Version     string    `yaml:"version"`
-	Commit      string    `yaml:"commit"`
-	Description string    `yaml:"description"`
-	Maintainer  string    `yaml:"maintainer"`
-	UpdatedAt   time.Time `yaml:"updated_at"`
-	Tags        []string  `yaml:"tags"`
-	Category    string    `yaml:"category,omitempty"`
-	License     string    `yaml:"license"`
-	Homepage    string    `yaml:"homepage"`
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Commit  string `yaml:"commit"`
+	// VersionCommit is the commit the version's tag pointed at when the entry
+	// was written. Optional; when set, a version install that checks out
+	// anything else fails rather than building a tag that has moved.
+	VersionCommit string    `yaml:"version_commit,omitempty"`
+	Description   string    `yaml:"description"`
+	Maintainer    string    `yaml:"maintainer"`
+	UpdatedAt     time.Time `yaml:"updated_at"`
+	Tags          []string  `yaml:"tags"`
+	Category      string    `yaml:"category,omitempty"`
+	License       string    `yaml:"license"`
+	Homepage      string    `yaml:"homepage"`
+	// Depends names the packages this one needs at run time — a plugin and
+	// its host — and BuildDepends those it needs only to be built, whose
+	// binaries its steps call. Both are installed first; the second also puts
+	// clipack's bin directory on the steps' PATH, which is otherwise not on
+	// PATH at all. Names are registry entries, not distribution packages:
+	// those go in requirements.
+	Depends      []string `yaml:"depends,omitempty"`
+	BuildDepends []string `yaml:"build-depends,omitempty"`
 	// Requirements is what the machine needs before the build can run. It sits
 	// beside Install rather than inside it because it describes the machine, not
 	// the steps: nothing in it is fetched, built or removed by clipack.
@@ -273,16 +356,106 @@ type Package struct {
 	// without it the next rebuild would put the link straight back.
 	Exposed   []string `yaml:"exposed,omitempty"`
 	Unexposed []string `yaml:"unexposed,omitempty"`
+	// ResolvedCommit is the commit the installed binaries were built from, as
+	// HEAD said after the clone — checked against the registry's pin, and
+	// recorded because a tag names a commit only for as long as nobody moves
+	// it. Empty for a build that cloned nothing.
+	ResolvedCommit string `yaml:"resolved_commit,omitempty"`
+	// Origin names the registry layer the entry was taken from. It is stamped
+	// on at load, whatever the file said, and written into the manifest with
+	// the rest — so an installed package still says where it came from after
+	// the layer that provided it is gone.
+	Origin string `yaml:"origin,omitempty"`
+	// Overlay is the file in the overlay directory this definition was read
+	// from, empty for an entry that came from a registry. It belongs to this
+	// machine, so it is neither cached nor written into the manifest.
+	Overlay string `yaml:"-"`
+	// EntryHash is ContentHash() of the registry entry as it was installed. The
+	// manifest alone cannot answer whether the entry changed since: it has
+	// been through this version of the schema, the registry through the next.
+	// Empty for a manifest written before it was recorded.
+	EntryHash string `yaml:"entry_hash,omitempty"`
+	// CustomRef is the ref `install --ref` or `update --ref` built instead of
+	// the registry's pin: a release candidate, a branch, a bisect point. An
+	// install pinned to one is no longer following the registry, so the
+	// registry moving is not an update for it.
+	CustomRef string `yaml:"custom_ref,omitempty"`
+	// Upstream is the commit the branch a head install follows was last seen
+	// at, which CheckHeads fills in from `git ls-remote`. It is never written:
+	// it describes the remote at the time of the check, not the install.
+	Upstream string `yaml:"-"`
 }
 
 // Ref returns the identifier this package is pinned to for the given method.
+// A release is the version's binaries, so it is pinned to the version. A head
+// install is pinned to nothing but its branch, and an installed one names the
+// commit it was built from beside it.
 func (p *Package) Ref(method string) string {
-	if method == MethodCommit {
+	switch method {
+	case MethodCommit:
 		return p.Commit
+	case MethodHead:
+		return p.headRef(p.ResolvedCommit)
 	}
 	return p.Version
 }
 
+// HeadBranch is the branch the head method builds, HEAD for the remote's
+// default one.
+func (p *Package) HeadBranch() string {
+	if p.Install.Source.Branch != "" {
+		return p.Install.Source.Branch
+	}
+	return "HEAD"
+}
+
+func (p *Package) headRef(commit string) string {
+	if commit == "" {
+		return p.HeadBranch()
+	}
+	return p.HeadBranch() + "@" + shortSHA(commit)
+}
+
+// UpdateRef is the ref updating installed moves it to under method: the
+// registry's, or for a head install the tip its branch was last seen at.
+func UpdateRef(registry, installed *Package, method string) string {
+	if method == MethodHead {
+		return installed.headRef(installed.Upstream)
+	}
+	return registry.Ref(method)
+}
+
+// PinnedTo returns a copy of p that builds ref instead of the registry's pin,
+// and the method that builds it: ref is checked out as a commit when commit is
+// set, and cloned as a tag or branch, which git does not tell apart, when it
+// is not. Installer.IsCommit says which it is. The archives are the
+// registry's refs' and are dropped.
+func (p *Package) PinnedTo(ref string, commit bool) (*Package, string) {
+	pinned := *p
+	pinned.CustomRef = ref
+	pinned.Install.Source.Archive = Archives{}
+	if commit {
+		pinned.Commit = ref
+		return &pinned, MethodCommit
+	}
+	// The tag's commit the registry recorded is not this ref's.
+	pinned.Version, pinned.VersionCommit = ref, ""
+	return &pinned, MethodVersion
+}
+
+// isCommitLike reports whether ref could be an abbreviated or full commit.
+func isCommitLike(ref string) bool {
+	if len(ref) < 7 || len(ref) > 64 {
+		return false
+	}
+	for _, r := range ref {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
 // CloneURL returns the git URL for the package, preferring the declared
 // source over the URL embedded in the first "git clone" step.
 func (p *Package) CloneURL() string {
@@ -599,14 +772,89 @@ func InstalledMap(config *cnfg.Config) (map[string]*Package, error) {
 }
 
 // HasUpdate reports whether the registry package differs from the installed one
-// under the installed package's own method.
+// under the installed package's own method. A release compares versions, like
+// the version method it is the prebuilt form of. An install pinned to a custom
+// ref has none: the registry's ref is not what it was built from to begin with.
+//
+// A head install does not follow the registry at all. It has an update when
+// its branch moved upstream past the commit it was built from, which is only
+// known once CheckHeads has looked.
 func HasUpdate(registry, installed *Package) bool {
-	if registry == nil || installed == nil {
+	if registry == nil || installed == nil || installed.CustomRef != "" {
 		return false
 	}
 	method := installed.InstallMethod
 	if method == "" {
 		method = MethodVersion
 	}
+	if method == MethodHead {
+		return installed.Upstream != "" && !sameCommit(installed.Upstream, installed.ResolvedCommit)
+	}
 	return registry.Ref(method) != installed.Ref(method)
 }
+
+// ContentHash digests what of the entry decides how the package is built and
+// installed with method: everything but the fields that only describe it —
+// description, tags and the like — and the local state a manifest adds.
+//
+// The refs are left out, and so is what belongs to another method's ref: the
+// other ref's archive and requirements, and the release unless that is what
+// is installed. A ref moving is HasUpdate's to report, and the registry's
+// daily bump of the commit changes nothing about a version install.
+//
+// A release install builds nothing and takes its binaries and man pages from
+// the release, so for it the build is left out too: the source, the steps and
+// their environment, the build dependencies, and the paths in a build output
+// unpackRelease drops. What it still runs — the setup script, the post-install
+// scripts, the additional config — is hashed as for any other method.
+func (p *Package) ContentHash(method string) string {
+	entry := *p
+	entry.Description, entry.Maintainer, entry.License, entry.Homepage = "", "", "", ""
+	entry.UpdatedAt = time.Time{}
+	entry.Tags, entry.Category = nil, ""
+	entry.InstallMethod, entry.ResolvedCommit, entry.Origin, entry.Overlay = "", "", "", ""
+	entry.Exposed, entry.Unexposed = nil, nil
+	entry.EntryHash, entry.CustomRef = "", ""
+
+	entry.Version, entry.VersionCommit, entry.Commit = "", "", ""
+	entry.Install.Source.Archive = Archives{Version: p.Install.Source.Archive.For(method)}
+	entry.Requirements = Requirements{MethodRequirements: p.Requirements.For(method)}
+	if method != MethodRelease {
+		entry.Install.Release = Release{}
+	} else {
+		entry.BuildDepends = nil
+		entry.Install.Source = Source{}
+		entry.Install.Environment, entry.Install.Steps = nil, nil
+		entry.Install.Binaries, entry.Install.Man = nil, nil
+		entry.Install.Resources, entry.Install.Desktop, entry.Install.Configs = nil, nil, nil
+	}
+
+	// yaml.v3 writes map keys sorted, so the same entry always marshals to
+	// the same bytes.
+	data, err := yaml.Marshal(&entry)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// NeedsRebuild reports whether the registry entry changed since the package
+// was installed while the ref it is pinned to did not — a new build step, a
+// resource tree, a fixed post-install script. It is not an update: nothing
+// upstream moved, so it is reported apart from one, and a real update
+// rebuilds from the new entry anyway.
+//
+// A manifest from before the hash was recorded has nothing to compare, and
+// is not guessed at; nor is an install pinned to a custom ref, which was not
+// built from the entry as it stands either.
+func NeedsRebuild(registry, installed *Package) bool {
+	if registry == nil || installed == nil || installed.EntryHash == "" || installed.CustomRef != "" {
+		return false
+	}
+	method := installed.InstallMethod
+	if method == "" {
+		method = MethodVersion
+	}
+	return !HasUpdate(registry, installed) && registry.ContentHash(method) != installed.EntryHash
+}
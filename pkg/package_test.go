@@ -4,6 +4,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -221,6 +222,132 @@ func TestHasUpdate(t *testing.T) {
 	}
 }
 
+func TestNeedsRebuild(t *testing.T) {
+	installed := &Package{Name: "demo", Version: "v1.0.0", InstallMethod: MethodVersion,
+		Install: Install{Steps: []string{"make"}}}
+	installed.EntryHash = installed.ContentHash(MethodVersion)
+
+	same := &Package{Name: "demo", Version: "v1.0.0", Install: Install{Steps: []string{"make"}}}
+	if NeedsRebuild(same, installed) {
+		t.Error("NeedsRebuild() = true for the entry that was installed")
+	}
+
+	// What only describes the package is not a reason to build it again.
+	described := *same
+	described.Description, described.Tags, described.Origin = "Now with a description", []string{"cli"}, "extra"
+	if NeedsRebuild(&described, installed) {
+		t.Error("NeedsRebuild() = true for a changed description")
+	}
+
+	changed := &Package{Name: "demo", Version: "v1.0.0", Install: Install{Steps: []string{"make", "make docs"}}}
+	if !NeedsRebuild(changed, installed) {
+		t.Error("NeedsRebuild() = false for a changed step under the same version")
+	}
+
+	// A moved ref is an update, and only that.
+	bumped := &Package{Name: "demo", Version: "v1.1.0", Install: Install{Steps: []string{"make", "make docs"}}}
+	if NeedsRebuild(bumped, installed) {
+		t.Error("NeedsRebuild() = true for an update")
+	}
+
+	// Nor is the other method's ref moving, or what only it builds from:
+	// neither changes the build a version install has.
+	recommitted := *same
+	recommitted.Commit = "0123abcd"
+	recommitted.Requirements.Commit.Toolchain = []string{"zig >= 0.16"}
+	recommitted.Install.Source.Archive.Commit = Archive{URL: "https://example.com/demo-0123abcd.tar.gz"}
+	if HasUpdate(&recommitted, installed) || NeedsRebuild(&recommitted, installed) {
+		t.Error("a version install is offered a rebuild for a new commit")
+	}
+
+	atCommit := &Package{Name: "demo", Version: "v1.0.0", Commit: "0123abcd", InstallMethod: MethodCommit,
+		Install: Install{Steps: []string{"make"}}}
+	atCommit.EntryHash = atCommit.ContentHash(MethodCommit)
+	retagged := *atCommit
+	retagged.InstallMethod = ""
+	retagged.Version, retagged.VersionCommit = "v1.1.0", "4567cdef"
+	retagged.Requirements.Version.OpenSUSE = []string{"libfoo-devel"}
+	if HasUpdate(&retagged, atCommit) || NeedsRebuild(&retagged, atCommit) {
+		t.Error("a commit install is offered a rebuild for a new version")
+	}
+	retagged.Requirements.OpenSUSE = []string{"libbar-devel"}
+	if !NeedsRebuild(&retagged, atCommit) {
+		t.Error("NeedsRebuild() = false for a requirement both refs share")
+	}
+
+	// A release install runs none of the steps, so a changed step rebuilds
+	// a build from source and leaves the release alone. The setup script
+	// runs for either.
+	released := &Package{Name: "demo", Version: "v1.0.0", InstallMethod: MethodRelease,
+		Install: Install{Steps: []string{"make"}, Setup: "ln -sf a b"}}
+	released.EntryHash = released.ContentHash(MethodRelease)
+	restepped := *released
+	restepped.InstallMethod = ""
+	restepped.Install.Steps = []string{"make", "make docs"}
+	if restepped.ContentHash(MethodRelease) != released.EntryHash || NeedsRebuild(&restepped, released) {
+		t.Error("a release install is offered a rebuild for a changed build step")
+	}
+	if restepped.ContentHash(MethodVersion) == released.ContentHash(MethodVersion) {
+		t.Error("ContentHash(version) unchanged by a changed build step")
+	}
+	resetup := *released
+	resetup.InstallMethod = ""
+	resetup.Install.Setup = "ln -sf a c"
+	if !NeedsRebuild(&resetup, released) {
+		t.Error("NeedsRebuild() = false for a release install's changed setup")
+	}
+
+	legacy := *installed
+	legacy.EntryHash = ""
+	if NeedsRebuild(changed, &legacy) {
+		t.Error("NeedsRebuild() = true for a manifest with no hash to compare")
+	}
+}
+
+func TestPinnedTo(t *testing.T) {
+	registry := &Package{Name: "demo", Version: "v1.0.0", VersionCommit: "aaaaaaa", Commit: "bbbbbbb",
+		Install: Install{Source: Source{Archive: Archives{Version: Archive{URL: "https://example.com/demo.tar.gz"}}}}}
+
+	tests := []struct {
+		ref, method, version, commit string
+	}{
+		{"v1.1.0-rc1", MethodVersion, "v1.1.0-rc1", "bbbbbbb"},
+		{"main", MethodVersion, "main", "bbbbbbb"},
+		{"0123abc", MethodCommit, "v1.0.0", "0123abc"},
+		{strings.Repeat("ab", 20), MethodCommit, "v1.0.0", strings.Repeat("ab", 20)},
+		// Hex, but too short to be a commit anyone would write.
+		{"cafe", MethodVersion, "cafe", "bbbbbbb"},
+	}
+	// No clone URL to ask, so the shape of the ref decides.
+	in := NewInstaller(testConfig(t), nil)
+	for _, tt := range tests {
+		pinned, method := registry.PinnedTo(tt.ref, in.IsCommit(registry, tt.ref))
+		if method != tt.method || pinned.Version != tt.version || pinned.Commit != tt.commit {
+			t.Errorf("PinnedTo(%q) = %s, version %q, commit %q; want %s, %q, %q",
+				tt.ref, method, pinned.Version, pinned.Commit, tt.method, tt.version, tt.commit)
+		}
+		if pinned.CustomRef != tt.ref || pinned.Install.Source.Archive.Version.URL != "" {
+			t.Errorf("PinnedTo(%q) = %+v, want the ref recorded and the archive dropped", tt.ref, pinned)
+		}
+		if method == MethodVersion && pinned.VersionCommit != "" {
+			t.Errorf("PinnedTo(%q) kept the registry tag's commit", tt.ref)
+		}
+	}
+	if registry.Version != "v1.0.0" || registry.CustomRef != "" {
+		t.Error("PinnedTo() changed the registry entry")
+	}
+
+	// Pinned, the package has neither an update nor a rebuild to offer,
+	// whatever the registry does.
+	installed, _ := registry.PinnedTo("v1.1.0-rc1", false)
+	installed.InstallMethod = MethodVersion
+	installed.EntryHash = installed.ContentHash(MethodVersion)
+	bumped := &Package{Name: "demo", Version: "v1.2.0", Install: Install{Steps: []string{"make"}}}
+	if HasUpdate(bumped, installed) || NeedsRebuild(bumped, installed) {
+		t.Error("a custom pin is offered the registry's ref as an update")
+	}
+}
+
 func TestLoadPackageFromBytes(t *testing.T) {
 	// Mirrors the shape of a real registry file, including install.source,
 	// which the old Package type had no field for and silently dropped.
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/lvim-tech/clipack/cnfg"
+	"github.com/lvim-tech/clipack/utils"
 	"gopkg.in/yaml.v3"
 )
 
@@ -49,37 +50,40 @@ func (l *limitedReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-// Hosts the registry is read from. They are variables rather than constants so
+// Hosts a GitHub registry is read from, unless registry.tarball_url and
+// registry.raw_url name a mirror. They are variables rather than constants so
 // tests can point them at a local server; nothing else reassigns them.
 var (
 	rawBaseURL      = "https://raw.githubusercontent.com"
 	codeloadBaseURL = "https://codeload.github.com"
 )
 
+// tarballBase is where config reads GitHub tarballs from.
+func tarballBase(config *cnfg.Config) string {
+	if config.Registry.TarballURL != "" {
+		return strings.TrimRight(config.Registry.TarballURL, "/")
+	}
+	return codeloadBaseURL
+}
+
+// rawBase is where config reads single GitHub files from.
+func rawBase(config *cnfg.Config) string {
+	if config.Registry.RawURL != "" {
+		return strings.TrimRight(config.Registry.RawURL, "/")
+	}
+	return rawBaseURL
+}
+
 // IndexFile represents the index file structure.
 type IndexFile struct {
 	Packages []string `yaml:"packages"`
 }
 
-var (
-	httpClientOnce sync.Once
-	httpClient     *http.Client
-)
-
-// sharedHTTPClient returns a process-wide client so connections are reused
-// across the many requests a registry sync makes.
-func sharedHTTPClient() *http.Client {
-	httpClientOnce.Do(func() {
-		transport := http.DefaultTransport.(*http.Transport).Clone()
-		transport.MaxIdleConns = 32
-		transport.MaxIdleConnsPerHost = maxParallelFetches
-		transport.IdleConnTimeout = 60 * time.Second
-		httpClient = &http.Client{
-			Timeout:   60 * time.Second,
-			Transport: transport,
-		}
-	})
-	return httpClient
+// httpClient returns the client for config's network. It is shared for the
+// life of the process, so connections are reused across the many requests a
+// registry sync makes.
+func httpClient(config *cnfg.Config) (*http.Client, error) {
+	return utils.HTTPClient(config.Network())
 }
 
 // repoRef identifies a GitHub repository and branch.
@@ -89,42 +93,64 @@ type repoRef struct {
 	Branch string
 }
 
-// resolveRepo derives owner/repo/branch from the configured registry URLs.
-func resolveRepo(config *cnfg.Config) (repoRef, error) {
-	branch := config.Registry.Branch
+// resolveRepo derives owner/repo/branch from a registry layer's URL, which is
+// either the repository URL or the contents API form of it.
+func resolveRepo(layer cnfg.RegistryLayer) (repoRef, error) {
+	branch := layer.Branch
 	if branch == "" {
 		branch = "main"
 	}
 
-	for _, raw := range []string{config.Registry.URL, config.Registry.RegistryRepoURL} {
-		if raw == "" {
-			continue
-		}
-		u, err := url.Parse(raw)
-		if err != nil {
-			continue
-		}
+	if u, err := url.Parse(layer.URL); err == nil && layer.URL != "" {
 		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
 		// github.com/<owner>/<repo>[.git]
 		// api.github.com/repos/<owner>/<repo>/contents
 		if len(parts) >= 3 && parts[0] == "repos" {
 			parts = parts[1:]
 		}
-		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
-			continue
+		if len(parts) >= 2 && parts[0] != "" && parts[1] != "" {
+			return repoRef{
+				Owner:  parts[0],
+				Repo:   strings.TrimSuffix(parts[1], ".git"),
+				Branch: branch,
+			}, nil
 		}
-		return repoRef{
-			Owner:  parts[0],
-			Repo:   strings.TrimSuffix(parts[1], ".git"),
-			Branch: branch,
-		}, nil
 	}
 
 	return repoRef{}, errors.New("could not determine registry owner/repo from config (registry.url)")
 }
 
-// get issues a GET, sending the configured token when there is one.
-func get(target, token string) (*http.Response, error) {
+// Validators are what a server said identifies the version of a response:
+// sent back on the next request, they let it answer 304 instead of the body.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Empty reports whether there is nothing to make a request conditional on.
+func (v Validators) Empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+func validatorsOf(resp *http.Response) Validators {
+	return Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+}
+
+// ErrNotModified is a conditional fetch answered with 304: what the cache
+// holds is still current.
+var ErrNotModified = errors.New("not modified")
+
+// get issues a GET through config's network, sending token when there is one.
+func get(target string, config *cnfg.Config, token string) (*http.Response, error) {
+	return getIf(target, config, token, Validators{})
+}
+
+// getIf is get made conditional on v.
+func getIf(target string, config *cnfg.Config, token string, v Validators) (*http.Response, error) {
+	client, err := httpClient(config)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest(http.MethodGet, target, nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
@@ -133,7 +159,13 @@ func get(target, token string) (*http.Response, error) {
 	if token != "" {
 		req.Header.Set("Authorization", "Bearer "+token)
 	}
-	return sharedHTTPClient().Do(req)
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	return doWithRetry(client, req)
 }
 
 // authFailure reports whether a status code suggests the credentials are the
@@ -151,18 +183,56 @@ func authFailure(status int) bool {
 // expired token (in config.yaml or the environment) makes every public registry
 // request fail.
 func fetch(target string, config *cnfg.Config) (*http.Response, error) {
+	return fetchIf(target, config, Validators{})
+}
+
+// fetchIf is fetch made conditional on v.
+//
+// Without a token in the file or the environment, registry.token_command is
+// the token's source, and it is not asked for up front: the request goes out
+// anonymously, and only one refused for want of a token — or for the
+// anonymous rate limit — runs the command and is sent again with its token.
+// A public registry never runs it.
+func fetchIf(target string, config *cnfg.Config, v Validators) (*http.Response, error) {
 	token := config.RegistryToken()
+	if token == "" && config.HasTokenCommand() {
+		return fetchWithCommandToken(target, config, v)
+	}
 
-	resp, err := get(target, token)
+	resp, err := getIf(target, config, token, v)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode == http.StatusOK || token == "" || !authFailure(resp.StatusCode) {
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotModified ||
+		token == "" || !authFailure(resp.StatusCode) {
 		return resp, nil
 	}
 
 	resp.Body.Close()
-	return get(target, "")
+	return getIf(target, config, "", v)
+}
+
+// fetchWithCommandToken is fetchIf for a token that comes from
+// registry.token_command.
+func fetchWithCommandToken(target string, config *cnfg.Config, v Validators) (*http.Response, error) {
+	resp, err := getIf(target, config, "", v)
+
+	var limited *RateLimitError
+	refused := errors.As(err, &limited) ||
+		err == nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified &&
+			authFailure(resp.StatusCode)
+	if !refused {
+		return resp, err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	token, terr := config.CommandToken()
+	if terr != nil {
+		return nil, terr
+	}
+	return getIf(target, config, token, v)
 }
 
 // doGet fetches a URL and returns the whole body.
@@ -186,26 +256,44 @@ func doGet(target string, config *cnfg.Config) ([]byte, error) {
 // GitHub API calls per package file, which needed ~43 round trips for a
 // 21-package registry and burned through the 60/hour unauthenticated limit.
 func fetchTarball(ref repoRef, config *cnfg.Config) (map[string][]byte, error) {
+	snap, err := fetchTarballIf(ref, config, Validators{})
+	return snap.Files, err
+}
+
+// fetchTarballIf is fetchTarball made conditional on v. An unchanged registry
+// is ErrNotModified, and costs one request and no body.
+func fetchTarballIf(ref repoRef, config *cnfg.Config, v Validators) (snapshot, error) {
 	target := fmt.Sprintf("%s/%s/%s/tar.gz/refs/heads/%s",
-		codeloadBaseURL, ref.Owner, ref.Repo, ref.Branch)
+		tarballBase(config), ref.Owner, ref.Repo, ref.Branch)
 
-	resp, err := fetch(target, config)
+	resp, err := fetchIf(target, config, v)
 	if err != nil {
-		return nil, err
+		return snapshot{}, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && !v.Empty() {
+		return snapshot{Validators: v}, ErrNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("tarball fetch: status %d", resp.StatusCode)
+		return snapshot{}, fmt.Errorf("tarball fetch: status %d", resp.StatusCode)
 	}
+	files, commit, err := readTarball(resp.Body)
+	return snapshot{Files: files, Validators: validatorsOf(resp), Commit: commit}, err
+}
 
-	gz, err := gzip.NewReader(io.LimitReader(resp.Body, maxTarballBytes))
+// readTarball unpacks the registry archive GitHub serves, keeping the YAML,
+// and returns the commit it was made from. git archive records that in the
+// comment of the archive's global header; a tarball without one has none.
+func readTarball(body io.Reader) (map[string][]byte, string, error) {
+	gz, err := gzip.NewReader(io.LimitReader(body, maxTarballBytes))
 	if err != nil {
-		return nil, fmt.Errorf("gzip: %w", err)
+		return nil, "", fmt.Errorf("gzip: %w", err)
 	}
 	defer gz.Close()
 
 	files := make(map[string][]byte)
+	var commit string
 	tr := tar.NewReader(&limitedReader{r: gz, limit: maxInflatedBytes, what: "registry archive"})
 	for {
 		header, err := tr.Next()
@@ -213,7 +301,11 @@ func fetchTarball(ref repoRef, config *cnfg.Config) (map[string][]byte, error) {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("tar: %w", err)
+			return nil, "", fmt.Errorf("tar: %w", err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			commit = strings.TrimSpace(header.PAXRecords["comment"])
+			continue
 		}
 		if header.Typeflag != tar.TypeReg {
 			continue
@@ -223,29 +315,30 @@ func fetchTarball(ref repoRef, config *cnfg.Config) (map[string][]byte, error) {
 		if idx := strings.Index(name, "/"); idx >= 0 {
 			name = name[idx+1:]
 		}
-		if !strings.HasSuffix(name, ".yaml") && !strings.HasSuffix(name, ".yml") {
+		if !isRegistryFile(name) {
 			continue
 		}
 		data, err := io.ReadAll(io.LimitReader(tr, maxRegistryFileSize+1))
 		if err != nil {
-			return nil, fmt.Errorf("reading %s: %w", name, err)
+			return nil, "", fmt.Errorf("reading %s: %w", name, err)
 		}
 		if len(data) > maxRegistryFileSize {
-			return nil, fmt.Errorf("%s exceeds %d bytes", name, maxRegistryFileSize)
+			return nil, "", fmt.Errorf("%s exceeds %d bytes", name, maxRegistryFileSize)
 		}
 		files[name] = data
 	}
 
 	if len(files) == 0 {
-		return nil, errors.New("tarball contained no yaml files")
+		return nil, "", errors.New("tarball contained no yaml files")
 	}
-	return files, nil
+	return files, commit, nil
 }
 
-// rawURL builds a raw.githubusercontent.com URL for a repo-relative path.
-func rawURL(ref repoRef, filePath string) string {
+// rawURL builds a raw.githubusercontent.com URL, or one on the mirror at
+// base, for a repo-relative path.
+func rawURL(base string, ref repoRef, filePath string) string {
 	return fmt.Sprintf("%s/%s/%s/%s/%s",
-		rawBaseURL, ref.Owner, ref.Repo, ref.Branch, strings.TrimPrefix(filePath, "/"))
+		base, ref.Owner, ref.Repo, ref.Branch, strings.TrimPrefix(filePath, "/"))
 }
 
 // fetchRawFiles downloads the given paths concurrently. Unlike the previous
@@ -269,7 +362,7 @@ func fetchRawFiles(ref repoRef, paths []string, config *cnfg.Config) (map[string
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			data, err := doGet(rawURL(ref, p), config)
+			data, err := doGet(rawURL(rawBase(config), ref, p), config)
 			results[i] = result{path: p, data: data, err: err}
 		}(i, p)
 	}
@@ -278,6 +371,12 @@ func fetchRawFiles(ref repoRef, paths []string, config *cnfg.Config) (map[string
 	files := make(map[string][]byte, len(paths))
 	var failures []string
 	for _, r := range results {
+		// A rate limit fails every file after it for the same reason, and
+		// what to do about it is the more useful thing to report.
+		var limited *RateLimitError
+		if errors.As(r.err, &limited) {
+			return files, limited
+		}
 		if r.err != nil {
 			failures = append(failures, r.path)
 			continue
@@ -293,26 +392,44 @@ func fetchRawFiles(ref repoRef, paths []string, config *cnfg.Config) (map[string
 }
 
 // parsePackages turns a path -> contents map into packages, in index order.
+//
+// Every file is checked against the package schema on the way. A violation the
+// decoder can live with — an unknown key, most often a misspelt one — leaves
+// the entry loaded and is reported as a *SchemaError; one it cannot, or a file
+// that is missing or nameless, skips the entry and is reported with the reason.
 func parsePackages(index IndexFile, files map[string][]byte) ([]*Package, error) {
 	var pkgs []*Package
 	var skipped []string
+	var violations []SchemaViolation
+	schema := PackageSchema()
 
 	for _, pkgPath := range index.Packages {
-		data, ok := files[strings.TrimPrefix(pkgPath, "/")]
+		file := strings.TrimPrefix(pkgPath, "/")
+		data, ok := files[file]
 		if !ok {
-			skipped = append(skipped, pkgPath)
+			skipped = append(skipped, pkgPath+": not in the registry")
 			continue
 		}
 
-		var pkg Package
-		if err := yaml.Unmarshal(data, &pkg); err != nil {
-			skipped = append(skipped, pkgPath)
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", pkgPath, err))
 			continue
 		}
-		if pkg.Name == "" {
-			skipped = append(skipped, pkgPath)
+		found := ValidateSchema(schema, file, &doc)
+
+		var pkg Package
+		if err := doc.Decode(&pkg); err != nil || pkg.Name == "" {
+			reason := "name is empty"
+			if len(found) > 0 {
+				reason = strings.TrimPrefix(found[0].Error(), file+": ")
+			} else if err != nil {
+				reason = err.Error()
+			}
+			skipped = append(skipped, pkgPath+": "+reason)
 			continue
 		}
+		violations = append(violations, found...)
 
 		// packages/<category>/<name>.yaml
 		if parts := strings.Split(pkgPath, "/"); len(parts) >= 3 {
@@ -322,49 +439,58 @@ func parsePackages(index IndexFile, files map[string][]byte) ([]*Package, error)
 		pkgs = append(pkgs, &pkg)
 	}
 
+	var schemaErr error
+	if len(violations) > 0 {
+		schemaErr = &SchemaError{Violations: violations}
+	}
+
 	if len(pkgs) == 0 {
+		if len(skipped) > 0 {
+			return nil, fmt.Errorf("no valid packages found in registry, first: %s", skipped[0])
+		}
 		return nil, errors.New("no valid packages found in registry")
 	}
 	if len(skipped) > 0 {
-		return pkgs, fmt.Errorf("skipped %d unreadable package(s), first: %s", len(skipped), skipped[0])
+		return pkgs, errors.Join(
+			fmt.Errorf("skipped %d unreadable package(s), first: %s", len(skipped), skipped[0]), schemaErr)
 	}
-	return pkgs, nil
+	return pkgs, schemaErr
 }
 
-// FetchRegistry always talks to the network and returns the full package set.
+// FetchRegistry always reads every configured registry — over the network for
+// a remote one — and returns the merged package set. Where the files come from
+// is each Registry's business; turning them into packages is the same pipeline
+// for every source.
 func FetchRegistry(config *cnfg.Config) ([]*Package, error) {
-	ref, err := resolveRepo(config)
-	if err != nil {
-		return nil, err
-	}
+	return loadLayers(config, true)
+}
 
-	// Fast path: one request for the entire registry.
-	if files, err := fetchTarball(ref, config); err == nil {
-		var index IndexFile
-		if data, ok := files["index.yaml"]; ok {
-			if err := yaml.Unmarshal(data, &index); err == nil && len(index.Packages) > 0 {
-				if pkgs, perr := parsePackages(index, files); len(pkgs) > 0 {
-					return pkgs, perr
-				}
-			}
+// packagesFrom reads a registry's files and parses them.
+func packagesFrom(registry Registry) ([]*Package, error) {
+	files, err := registry.Files()
+	return packagesFromFiles(registry, files, err)
+}
+
+// packagesFromFiles parses the files read from a registry. A fetch that came
+// back partial still yields whatever parsed, with the fetch error taking
+// precedence over the parse error: the missing files are the cause, the
+// skipped entries only the symptom.
+func packagesFromFiles(registry Registry, files map[string][]byte, fetchErr error) ([]*Package, error) {
+	if len(files) == 0 {
+		if fetchErr == nil {
+			fetchErr = fmt.Errorf("registry %s is empty", registry)
 		}
+		return nil, fetchErr
 	}
 
-	// Fallback: index + one raw request per package, in parallel.
-	indexData, err := doGet(rawURL(ref, "index.yaml"), config)
+	index, err := parseIndex(files)
 	if err != nil {
-		return nil, fmt.Errorf("fetching index.yaml: %w", err)
-	}
-
-	var index IndexFile
-	if err := yaml.Unmarshal(indexData, &index); err != nil {
-		return nil, fmt.Errorf("parsing index.yaml: %w", err)
-	}
-	if len(index.Packages) == 0 {
-		return nil, errors.New("no packages listed in index.yaml")
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return nil, err
 	}
 
-	files, fetchErr := fetchRawFiles(ref, index.Packages, config)
 	pkgs, parseErr := parsePackages(index, files)
 	if len(pkgs) == 0 {
 		if fetchErr != nil {
@@ -378,34 +504,173 @@ func FetchRegistry(config *cnfg.Config) ([]*Package, error) {
 	return pkgs, parseErr
 }
 
-// LoadAllPackagesFromRegistry returns the cached package set when it is still
-// fresh, otherwise refreshes it from the network and caches the result.
+// LoadAllPackagesFromRegistry returns the merged package set, taking each
+// registry from its cache while that is fresh and refreshing it otherwise.
 // A partial fetch is returned to the caller but never written to the cache.
 func LoadAllPackagesFromRegistry(config *cnfg.Config) ([]*Package, error) {
-	if packages, err := LoadFromCache(config); err == nil && len(packages) > 0 {
-		return packages, nil
-	}
-	return RefreshRegistry(config)
+	return loadLayers(config, false)
 }
 
-// RefreshRegistry bypasses the cache, fetches, and stores the result.
+// RefreshRegistry bypasses the cache, fetches every registry, and stores what
+// came back complete.
 func RefreshRegistry(config *cnfg.Config) ([]*Package, error) {
-	packages, fetchErr := FetchRegistry(config)
+	return loadLayers(config, true)
+}
+
+// loadLayers loads every configured registry and merges them.
+//
+// Each layer is loaded, cached and allowed to fail on its own. One registry
+// being unreachable is a warning about that registry, not a reason to show
+// none: its packages are missing from the result and the error says so, and
+// every other layer is served as usual.
+//
+// The overlay is read from disk, so it is applied even when no registry could
+// be loaded, or none is configured: the packages only it defines are still
+// there, with the error saying what is missing.
+func loadLayers(config *cnfg.Config, refresh bool) ([]*Package, error) {
+	layers := config.RegistryLayers()
+
+	var sets [][]*Package
+	var errs []error
+	if len(layers) == 0 {
+		errs = append(errs, cnfg.ErrNoRegistry)
+	}
+	for _, layer := range layers {
+		pkgs, err := loadLayer(config, layer, refresh)
+		if len(pkgs) > 0 {
+			sets = append(sets, pkgs)
+		}
+		if err != nil {
+			// Named only when there is more than one to tell apart; for a
+			// single registry the prefix would say nothing the user did not
+			// already know.
+			if len(layers) > 1 {
+				err = fmt.Errorf("registry %s: %w", layer.Name, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	merged, err := applyOverlay(mergeLayers(sets), config)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(merged) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return merged, errors.Join(errs...)
+}
+
+// loadLayer returns one registry's packages, from its cache when that is fresh
+// and refresh is not forced. What is fetched is stamped with the layer it came
+// from, and cached only when complete — a partial one would be served as if it
+// were the whole registry until the interval expires.
+//
+// A registry that can answer "unchanged" is asked to, with the validators the
+// cached copy was fetched under; a 304 re-stamps the cache and costs nothing
+// more. And when the fetch fails outright, a cache past its interval is still
+// better than no packages at all: it is returned with an *OfflineError saying
+// how old it is. In offline mode there is no fetch: the cache is served
+// whatever its age.
+func loadLayer(config *cnfg.Config, layer cnfg.RegistryLayer, refresh bool) ([]*Package, error) {
+	if !refresh {
+		if packages, err := loadLayerCache(config, layer); err == nil && len(packages) > 0 {
+			return packages, nil
+		}
+	}
+
+	registry, err := NewRegistry(config, layer)
+	if err != nil {
+		return nil, err
+	}
+	if config.Offline {
+		if packages, handled, err := loadOfflineLayer(config, layer, registry); handled {
+			return packages, err
+		}
+	}
+
+	cache, _ := readLayerCache(config, layer)
+	var since Validators
+	if cache != nil {
+		since = cache.Validators
+	}
+
+	snap, fetchErr := snapshotOf(registry, since)
+	if errors.Is(fetchErr, ErrNotModified) {
+		if err := saveLayerCache(cache.Packages, cache.CacheHeader, config, layer); err != nil {
+			return cache.Packages, fmt.Errorf("registry unchanged but re-stamping the cache failed: %w", err)
+		}
+		return cache.Packages, nil
+	}
+
+	// Nothing of a registry that fails verification is parsed, let alone
+	// cached, and no stale cache stands in for it: a registry that is not
+	// what its signer wrote is not a network hiccup.
+	var signer string
+	if len(snap.Files) > 0 {
+		var err error
+		if signer, err = verifyLayer(config, snap.Files); err != nil {
+			return nil, fmt.Errorf("%s: %w", registry, err)
+		}
+	}
+
+	packages, fetchErr := packagesFromFiles(registry, snap.Files, fetchErr)
 	if len(packages) == 0 {
+		if cache != nil {
+			return cache.Packages, &OfflineError{Age: time.Since(cache.LastUpdated), Err: fetchErr}
+		}
 		return nil, fetchErr
 	}
+	for _, p := range packages {
+		p.Origin = layer.Name
+	}
 
-	// Only cache a complete fetch; a partial one would be served as if it
-	// were the whole registry until the interval expires.
-	if fetchErr == nil {
-		if err := SaveToCache(packages, config); err != nil {
+	// Schema violations alone leave every entry loaded, so the set is complete
+	// and worth caching; anything else means something is missing from it.
+	var schemaErr *SchemaError
+	if fetchErr == nil || (errors.As(fetchErr, &schemaErr) && error(schemaErr) == fetchErr) {
+		// The cache being replaced is what `registry diff` compares with, and
+		// is kept only when there is something to compare: a refresh that
+		// changed nothing would otherwise throw away the last one that did.
+		var keepErr error
+		if cache != nil && !DiffRegistries(cache.Packages, packages).Empty() {
+			keepErr = keepPreviousSnapshot(config, layer)
+		}
+		header := CacheHeader{Commit: snap.Commit, Validators: snap.Validators, SignedBy: signer}
+		if err := saveLayerCache(packages, header, config, layer); err != nil {
 			return packages, fmt.Errorf("packages loaded but caching failed: %w", err)
 		}
+		if keepErr != nil {
+			return packages, fmt.Errorf("packages loaded but keeping the previous snapshot failed: %w", keepErr)
+		}
 	}
-
 	return packages, fetchErr
 }
 
+// mergeLayers combines package sets, lowest priority first. A package defined
+// again by a later layer replaces the earlier definition where it stood, so
+// overriding an entry does not move it; a package only a later layer knows is
+// appended after everything before it.
+func mergeLayers(sets [][]*Package) []*Package {
+	if len(sets) == 1 {
+		return sets[0]
+	}
+
+	var merged []*Package
+	position := map[string]int{}
+	for _, set := range sets {
+		for _, p := range set {
+			if i, ok := position[p.Name]; ok {
+				merged[i] = p
+				continue
+			}
+			position[p.Name] = len(merged)
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
 // LoadPackageFromRegistry loads a single package by name.
 func LoadPackageFromRegistry(name string, config *cnfg.Config) (*Package, error) {
 	if packages, err := LoadFromCache(config); err == nil {
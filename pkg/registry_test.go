@@ -4,11 +4,16 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lvim-tech/clipack/cnfg"
 )
@@ -74,7 +79,14 @@ func TestResolveRepo(t *testing.T) {
 				Branch:          tt.branch,
 			}}
 
-			ref, err := resolveRepo(config)
+			// The layer is what RegistryLayers makes of the two fields, which
+			// is where the fallback to the API URL now happens.
+			var layer cnfg.RegistryLayer
+			if layers := config.RegistryLayers(); len(layers) > 0 {
+				layer = layers[0]
+			}
+
+			ref, err := resolveRepo(layer)
 			if tt.wantErr {
 				if err == nil {
 					t.Fatalf("resolveRepo() error = nil, want an error")
@@ -98,7 +110,7 @@ func TestRawURL(t *testing.T) {
 
 	// Leading slashes must not produce a doubled separator.
 	for _, path := range []string{"packages/cli/bat.yaml", "/packages/cli/bat.yaml"} {
-		if got := rawURL(ref, path); got != want {
+		if got := rawURL(rawBaseURL, ref, path); got != want {
 			t.Errorf("rawURL(%q) = %q, want %q", path, got, want)
 		}
 	}
@@ -166,6 +178,7 @@ func TestFetchDoesNotRetryWithoutAToken(t *testing.T) {
 }
 
 func TestDoGetReportsStatus(t *testing.T) {
+	noBackoff(t)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, "boom")
@@ -251,6 +264,15 @@ func tarball(t *testing.T, prefix string, files map[string]string) []byte {
 	gz := gzip.NewWriter(&buf)
 	tw := tar.NewWriter(gz)
 
+	// git archive puts the commit in a global header first.
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       "pax_global_header",
+		PAXRecords: map[string]string{"comment": registryCommit},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
 	// A directory entry, to check that non-regular entries are skipped.
 	if err := tw.WriteHeader(&tar.Header{
 		Name: prefix + "/packages/", Typeflag: tar.TypeDir, Mode: 0o755,
@@ -280,6 +302,9 @@ func tarball(t *testing.T, prefix string, files map[string]string) []byte {
 	return buf.Bytes()
 }
 
+// registryCommit is the commit every test tarball says it was made from.
+const registryCommit = "5a1d7f3c9e2b4a6d8f0c1e3b5a7d9f2c4e6b8a0d"
+
 // registryFiles is the smallest registry that exercises the index and both
 // package categories.
 var registryFiles = map[string]string{
@@ -295,6 +320,7 @@ var registryFiles = map[string]string{
 // codeloadStatus lets a test force the tarball path to fail.
 func serveRegistry(t *testing.T, codeloadStatus int) *httptest.Server {
 	t.Helper()
+	noBackoff(t)
 
 	archive := tarball(t, "registry-main", registryFiles)
 
@@ -394,6 +420,7 @@ func TestFetchRegistryFallsBackToRawFiles(t *testing.T) {
 }
 
 func TestFetchRegistryFailsWhenIndexIsUnreachable(t *testing.T) {
+	noBackoff(t)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}))
@@ -409,6 +436,7 @@ func TestFetchRegistryFailsWhenIndexIsUnreachable(t *testing.T) {
 }
 
 func TestRefreshRegistryDoesNotCachePartialFetches(t *testing.T) {
+	noBackoff(t)
 	// The index lists two packages but only one is served. The old code cached
 	// the partial result, so the missing package vanished for 24 hours.
 	partial := map[string]string{
@@ -485,6 +513,165 @@ func TestRefreshRegistryWritesTheCache(t *testing.T) {
 	if len(cached) != 2 {
 		t.Errorf("got %d cached packages, want 2", len(cached))
 	}
+
+	cache, err := readCache(GetCacheFilePath(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Commit != registryCommit {
+		t.Errorf("cached commit = %q, want the one the tarball was made from", cache.Commit)
+	}
+}
+
+func TestRefreshRegistryAsksWhetherTheTarballChanged(t *testing.T) {
+	archive := tarball(t, "registry-main", registryFiles)
+
+	var downloads, unchanged int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			unchanged++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	oldRaw, oldCodeload := rawBaseURL, codeloadBaseURL
+	rawBaseURL, codeloadBaseURL = server.URL, server.URL
+	defer func() { rawBaseURL, codeloadBaseURL = oldRaw, oldCodeload }()
+
+	config := testConfig(t)
+	if _, err := RefreshRegistry(config); err != nil {
+		t.Fatalf("first RefreshRegistry() error = %v", err)
+	}
+	before, err := readCache(GetCacheFilePath(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packages, err := RefreshRegistry(config)
+	if err != nil {
+		t.Fatalf("second RefreshRegistry() error = %v", err)
+	}
+	if downloads != 1 || unchanged != 1 {
+		t.Errorf("downloads = %d, not modified = %d; want the second refresh answered with 304", downloads, unchanged)
+	}
+	if len(packages) != 2 {
+		t.Errorf("got %d packages, want the 2 from the cache", len(packages))
+	}
+
+	// An unchanged registry still counts as checked: the interval starts over.
+	after, err := readCache(GetCacheFilePath(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.LastUpdated.After(before.LastUpdated) || after.Validators.ETag != `"v1"` {
+		t.Errorf("cache after a 304 = %v %+v, want it re-stamped with the ETag kept", after.LastUpdated, after.Validators)
+	}
+}
+
+func TestRefreshRegistryFallsBackToAStaleCache(t *testing.T) {
+	noBackoff(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	oldRaw, oldCodeload := rawBaseURL, codeloadBaseURL
+	rawBaseURL, codeloadBaseURL = server.URL, server.URL
+	defer func() { rawBaseURL, codeloadBaseURL = oldRaw, oldCodeload }()
+
+	config := testConfig(t)
+	config.Registry.UpdateInterval = time.Nanosecond
+	if err := SaveToCache([]*Package{{Name: "cached-only"}}, config); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if len(packages) != 1 || packages[0].Name != "cached-only" {
+		t.Fatalf("got %v, want the stale cache rather than nothing", packages)
+	}
+	offline := Offline(err)
+	if offline == nil {
+		t.Fatalf("error = %v, want it to say the cache was served offline", err)
+	}
+	if !strings.Contains(err.Error(), "offline, cache is") {
+		t.Errorf("error = %q, want the cache's age in it", err)
+	}
+}
+
+// mirrorCA writes the certificate a TLS test server presents to a PEM file:
+// the private CA of a network that re-signs its mirror.
+func mirrorCA(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFetchRegistryFromAMirrorWithAPrivateCA(t *testing.T) {
+	archive := tarball(t, "registry-main", registryFiles)
+	var paths []string
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write(archive)
+	}))
+	defer mirror.Close()
+
+	config := testConfig(t)
+	config.Registry.TarballURL = mirror.URL + "/codeload/"
+	// Without the CA the mirror's certificate is not trusted, and the raw
+	// fallback still points at GitHub: nothing can be fetched.
+	config.Registry.RawURL = mirror.URL + "/raw"
+	if _, err := FetchRegistry(config); err == nil {
+		t.Fatal("FetchRegistry() trusted the mirror without its CA")
+	}
+
+	config.Registry.CABundle = mirrorCA(t, mirror)
+	packages, err := FetchRegistry(config)
+	if err != nil {
+		t.Fatalf("FetchRegistry() error = %v", err)
+	}
+	if len(packages) != 2 {
+		t.Errorf("got %d packages, want 2", len(packages))
+	}
+	if want := "/codeload/example/registry/tar.gz/refs/heads/main"; paths[len(paths)-1] != want {
+		t.Errorf("mirror was asked for %s, want %s", paths[len(paths)-1], want)
+	}
+}
+
+func TestFetchRegistryFallsBackToTheRawMirror(t *testing.T) {
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutPrefix(r.URL.Path, "/raw/example/registry/main/")
+		content, found := registryFiles[name]
+		if !ok || !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	defer mirror.Close()
+
+	config := testConfig(t)
+	config.Registry.TarballURL = mirror.URL + "/codeload"
+	config.Registry.RawURL = mirror.URL + "/raw"
+	config.Registry.CABundle = mirrorCA(t, mirror)
+
+	packages, err := FetchRegistry(config)
+	if err != nil {
+		t.Fatalf("FetchRegistry() error = %v", err)
+	}
+	if len(packages) != 2 {
+		t.Errorf("got %d packages from the raw mirror, want 2", len(packages))
+	}
 }
 
 func TestLoadPackageFromRegistry(t *testing.T) {
@@ -548,3 +735,163 @@ func TestFetchRawFilesReportsFailures(t *testing.T) {
 		t.Errorf("error = %v, want it to say how many files failed", err)
 	}
 }
+
+// layeredConfig configures the given directories as registry layers, the
+// first as registry.url and the rest stacked over it by name.
+func layeredConfig(t *testing.T, base string, layers map[string]string, order ...string) *cnfg.Config {
+	t.Helper()
+
+	config := testConfig(t)
+	config.Registry.URL = base
+	for _, name := range order {
+		config.Registry.Layers = append(config.Registry.Layers,
+			cnfg.RegistryLayer{Name: name, URL: layers[name]})
+	}
+	return config
+}
+
+func TestLayersOverrideByNameAndRecordTheOrigin(t *testing.T) {
+	base := writeRegistryDir(t, registryFiles)
+	team := writeRegistryDir(t, map[string]string{
+		"index.yaml": "packages:\n  - packages/cli/bat.yaml\n  - packages/cli/tool.yaml\n",
+		// A corrected bat, and a package only the team has.
+		"packages/cli/bat.yaml":  "name: bat\nversion: v0.25.0-team\n",
+		"packages/cli/tool.yaml": "name: tool\nversion: v1.0.0\n",
+	})
+	config := layeredConfig(t, base, map[string]string{"team": team}, "team")
+
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err != nil {
+		t.Fatalf("LoadAllPackagesFromRegistry() error = %v", err)
+	}
+
+	var names []string
+	for _, p := range packages {
+		names = append(names, p.Name)
+	}
+	// The override keeps bat where the base registry put it; the team's own
+	// package comes after everything below it.
+	if strings.Join(names, ",") != "bat,yazi,tool" {
+		t.Fatalf("packages = %v, want bat,yazi,tool", names)
+	}
+	if packages[0].Version != "v0.25.0-team" || packages[0].Origin != "team" {
+		t.Errorf("bat = %s from %q, want the team's entry", packages[0].Version, packages[0].Origin)
+	}
+	if packages[1].Origin != cnfg.LayerName(base) {
+		t.Errorf("yazi origin = %q, want the base registry", packages[1].Origin)
+	}
+}
+
+func TestLayersAreCachedSeparately(t *testing.T) {
+	base := writeRegistryDir(t, registryFiles)
+	team := writeRegistryDir(t, map[string]string{
+		"index.yaml":             "packages:\n  - packages/cli/tool.yaml\n",
+		"packages/cli/tool.yaml": "name: tool\n",
+	})
+	config := layeredConfig(t, base, map[string]string{"team": team}, "team")
+
+	if _, err := RefreshRegistry(config); err != nil {
+		t.Fatalf("RefreshRegistry() error = %v", err)
+	}
+	if !exists(filepath.Join(config.Paths.Registry, "packages_cache.gob")) {
+		t.Error("the base registry was not cached under the original name")
+	}
+	if !exists(filepath.Join(config.Paths.Registry, "packages_cache-team.gob")) {
+		t.Error("the team layer was not cached in a file of its own")
+	}
+
+	// With both caches fresh, nothing is read from the directories again.
+	if err := os.RemoveAll(team); err != nil {
+		t.Fatal(err)
+	}
+	packages, err := LoadAllPackagesFromRegistry(config)
+	if err != nil {
+		t.Fatalf("LoadAllPackagesFromRegistry() error = %v", err)
+	}
+	if FindByName(packages, "tool") == nil {
+		t.Error("the team layer's package did not come from its cache")
+	}
+}
+
+func TestAFailingLayerDoesNotDiscardTheOthers(t *testing.T) {
+	base := writeRegistryDir(t, registryFiles)
+	config := layeredConfig(t, base,
+		map[string]string{"broken": filepath.Join(t.TempDir(), "missing")}, "broken")
+
+	packages, err := RefreshRegistry(config)
+	if len(packages) != 2 {
+		t.Fatalf("got %d packages, want the base registry's two", len(packages))
+	}
+	if err == nil || !strings.Contains(err.Error(), "registry broken") {
+		t.Errorf("error = %v, want the failing layer to be named", err)
+	}
+	if !exists(GetCacheFilePath(config)) {
+		t.Error("the layer that loaded was not cached because another failed")
+	}
+}
+
+func TestClearCacheRemovesEveryLayer(t *testing.T) {
+	base := writeRegistryDir(t, registryFiles)
+	team := writeRegistryDir(t, map[string]string{
+		"index.yaml":             "packages:\n  - packages/cli/tool.yaml\n",
+		"packages/cli/tool.yaml": "name: tool\n",
+	})
+	config := layeredConfig(t, base, map[string]string{"team": team}, "team")
+
+	if _, err := RefreshRegistry(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := ClearCache(config); err != nil {
+		t.Fatalf("ClearCache() error = %v", err)
+	}
+
+	left, _ := filepath.Glob(filepath.Join(config.Paths.Registry, "packages_cache*"))
+	if len(left) != 0 {
+		t.Errorf("cache files left behind: %v", left)
+	}
+}
+
+func TestFetchRunsTheTokenCommandOnlyWhenRefused(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command under test is POSIX shell")
+	}
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GITHUB_TOKEN", "")
+
+	runs := filepath.Join(t.TempDir(), "runs")
+	config := &cnfg.Config{Registry: cnfg.RegistryConfig{
+		TokenCommand: "echo run >> '" + runs + "'; echo private-token",
+	}}
+
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "public content")
+	}))
+	defer public.Close()
+	if _, err := doGet(public.URL, config); err != nil {
+		t.Fatalf("doGet() of a public registry error = %v", err)
+	}
+	if exists(runs) {
+		t.Fatal("the token command ran for a request that needed no token")
+	}
+
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer private-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "private content")
+	}))
+	defer private.Close()
+	for range 2 {
+		body, err := doGet(private.URL, config)
+		if err != nil {
+			t.Fatalf("doGet() of a private registry error = %v", err)
+		}
+		if string(body) != "private content" {
+			t.Errorf("body = %q, want the authenticated response", body)
+		}
+	}
+	if data, _ := os.ReadFile(runs); strings.Count(string(data), "run") != 1 {
+		t.Errorf("the token command ran %d times, want once", strings.Count(string(data), "run"))
+	}
+}
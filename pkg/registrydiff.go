@@ -0,0 +1,305 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"gopkg.in/yaml.v3"
+)
+
+// RegistryDiff is what changed between two snapshots of a registry: the
+// question a refresh leaves open, and the one to answer before `update --all`.
+type RegistryDiff struct {
+	Added   []*Package
+	Removed []*Package
+	// Renamed pairs an entry that went with one that came under another name
+	// and the same source.
+	Renamed []EntryChange
+	Changed []EntryChange
+}
+
+// EntryChange is one entry in both snapshots, or a rename, and the fields
+// that differ between them.
+type EntryChange struct {
+	From, To *Package
+	Fields   []FieldChange
+}
+
+// FieldChange is one field that differs, named by its path in the entry —
+// "version", "install.steps" — with both values as the registry file would
+// write them. A field one side does not set is empty on that side.
+type FieldChange struct {
+	Field    string
+	From, To string
+}
+
+// Empty reports whether nothing changed.
+func (d RegistryDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Renamed) == 0 && len(d.Changed) == 0
+}
+
+// Summary counts the changes in a line: "1 added, 2 changed (1 bumped)".
+func (d RegistryDiff) Summary() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	for _, part := range []struct {
+		n    int
+		verb string
+	}{
+		{len(d.Added), "added"},
+		{len(d.Removed), "removed"},
+		{len(d.Renamed), "renamed"},
+	} {
+		if part.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", part.n, part.verb))
+		}
+	}
+	if len(d.Changed) > 0 {
+		bumped := 0
+		for _, c := range d.Changed {
+			if c.Bumped() {
+				bumped++
+			}
+		}
+		changed := fmt.Sprintf("%d changed", len(d.Changed))
+		if bumped > 0 {
+			changed += fmt.Sprintf(" (%d bumped)", bumped)
+		}
+		parts = append(parts, changed)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Bumped reports whether the version or the commit moved, which is what makes
+// the change an update for whoever has the package installed.
+func (c EntryChange) Bumped() bool {
+	return c.From.Version != c.To.Version || c.From.Commit != c.To.Commit
+}
+
+// Multiline reports whether either value spans lines, which is when a diff
+// says more than the two values side by side.
+func (c FieldChange) Multiline() bool {
+	return strings.Contains(c.From, "\n") || strings.Contains(c.To, "\n")
+}
+
+// Diff renders the change as a unified diff, previous snapshot first.
+func (c FieldChange) Diff() string {
+	return unifiedDiff(splitLines(c.From), splitLines(c.To), "previous", "current")
+}
+
+// DiffRegistries compares two package sets, previous first. Every list in the
+// result is sorted by name, so the same two snapshots always read the same.
+func DiffRegistries(from, to []*Package) RegistryDiff {
+	before := make(map[string]*Package, len(from))
+	for _, p := range from {
+		before[p.Name] = p
+	}
+	after := make(map[string]*Package, len(to))
+	for _, p := range to {
+		after[p.Name] = p
+	}
+
+	var diff RegistryDiff
+	for _, p := range to {
+		old, ok := before[p.Name]
+		if !ok {
+			diff.Added = append(diff.Added, p)
+			continue
+		}
+		if fields := diffFields(old, p); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, EntryChange{From: old, To: p, Fields: fields})
+		}
+	}
+	for _, p := range from {
+		if _, ok := after[p.Name]; !ok {
+			diff.Removed = append(diff.Removed, p)
+		}
+	}
+
+	byName := func(list []*Package) {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	byName(diff.Added)
+	byName(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].To.Name < diff.Changed[j].To.Name })
+
+	// A rename is an entry that left and one that arrived, building the same
+	// source. Without a source to go by, the homepage will do; without
+	// either, they stay an addition and a removal.
+	var added []*Package
+	for _, p := range diff.Added {
+		i := renamedFrom(diff.Removed, p)
+		if i < 0 {
+			added = append(added, p)
+			continue
+		}
+		old := diff.Removed[i]
+		diff.Removed = append(diff.Removed[:i], diff.Removed[i+1:]...)
+		var fields []FieldChange
+		for _, f := range diffFields(old, p) {
+			if f.Field != "name" {
+				fields = append(fields, f)
+			}
+		}
+		diff.Renamed = append(diff.Renamed, EntryChange{From: old, To: p, Fields: fields})
+	}
+	diff.Added = added
+	return diff
+}
+
+// renamedFrom returns the index of the removed entry p is a rename of, or -1.
+func renamedFrom(removed []*Package, p *Package) int {
+	for _, key := range []func(*Package) string{
+		(*Package).CloneURL,
+		func(p *Package) string { return p.Homepage },
+	} {
+		want := key(p)
+		if want == "" {
+			continue
+		}
+		for i, old := range removed {
+			if key(old) == want {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// diffFields compares two entries field by field, in path order.
+func diffFields(from, to *Package) []FieldChange {
+	before, after := entryFields(from), entryFields(to)
+
+	paths := make([]string, 0, len(before)+len(after))
+	for path := range before {
+		paths = append(paths, path)
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var changes []FieldChange
+	for _, path := range paths {
+		if before[path] != after[path] {
+			changes = append(changes, FieldChange{Field: path, From: before[path], To: after[path]})
+		}
+	}
+	return changes
+}
+
+// entryFields flattens an entry into its fields as the registry file writes
+// them: a mapping is followed down to what it holds, and everything else — a
+// string, a list of steps, a list of resources — is one field, compared and
+// shown whole.
+func entryFields(p *Package) map[string]string {
+	fields := map[string]string{}
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return fields
+	}
+	var tree map[string]any
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return fields
+	}
+	flattenFields("", tree, fields)
+	return fields
+}
+
+func flattenFields(path string, value any, fields map[string]string) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case map[string]any:
+		if len(v) > 0 {
+			for key, inner := range v {
+				child := key
+				if path != "" {
+					child = path + "." + key
+				}
+				flattenFields(child, inner, fields)
+			}
+			return
+		}
+	case string:
+		fields[path] = v
+		return
+	}
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return
+	}
+	fields[path] = strings.TrimRight(string(data), "\n")
+}
+
+// previousCachePath is where one layer's cache goes when a refresh replaces it
+// with a registry that changed. Beside the cache, so ClearCache takes it
+// with everything else.
+func previousCachePath(config *cnfg.Config, layer cnfg.RegistryLayer) string {
+	return strings.TrimSuffix(cachePathFor(config, layer), ".gob") + ".previous.gob"
+}
+
+// keepPreviousSnapshot moves the cache a refresh is about to replace out of
+// its way. Only one snapshot is kept: the question is what the last refresh
+// changed, not the registry's history, which its repository already has.
+func keepPreviousSnapshot(config *cnfg.Config, layer cnfg.RegistryLayer) error {
+	return os.Rename(cachePathFor(config, layer), previousCachePath(config, layer))
+}
+
+// SnapshotDiff is what the last refresh that changed one registry layer
+// changed in it.
+type SnapshotDiff struct {
+	// Layer names the registry; From and To are the headers of the snapshot
+	// kept and the cache that replaced it.
+	Layer    string
+	From, To CacheHeader
+	// Previous is false when there is nothing to compare with: the registry
+	// has not changed since it was first fetched, or not since before
+	// snapshots were kept.
+	Previous bool
+	RegistryDiff
+}
+
+// DiffSnapshots compares each registry layer's cache with the snapshot the
+// refresh that wrote it replaced. Nothing is fetched: this is about the
+// refresh that already happened.
+func DiffSnapshots(config *cnfg.Config) ([]SnapshotDiff, error) {
+	layers := config.RegistryLayers()
+	if len(layers) == 0 {
+		return nil, cnfg.ErrNoRegistry
+	}
+
+	var diffs []SnapshotDiff
+	for _, layer := range layers {
+		current, err := readCache(cachePathFor(config, layer))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s has not been fetched yet: run clipack list -f", layer.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		d := SnapshotDiff{Layer: layer.Name, To: current.CacheHeader}
+
+		// A snapshot of a registry that has since been replaced in the
+		// configuration compares two different registries.
+		previous, err := readCache(previousCachePath(config, layer))
+		if err == nil && previous.from(layer) {
+			d.Previous = true
+			d.From = previous.CacheHeader
+			d.RegistryDiff = DiffRegistries(previous.Packages, current.Packages)
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffRegistries(t *testing.T) {
+	from := []*Package{
+		{Name: "bat", Version: "v0.25.0", Commit: "aaaa", Install: Install{Steps: []string{"cargo build"}}},
+		{Name: "fzf", Version: "v0.62.0", Description: "A fuzzy finder"},
+		{Name: "exa", Version: "v0.10.1", Install: Install{Source: Source{URL: "https://example.com/exa.git"}}},
+		{Name: "gone", Version: "v1"},
+	}
+	to := []*Package{
+		{Name: "bat", Version: "v0.26.0", Commit: "cccc", Install: Install{Steps: []string{"cargo build", "make man"}}},
+		{Name: "fzf", Version: "v0.62.0", Description: "A command-line fuzzy finder"},
+		{Name: "eza", Version: "v0.18.0", Install: Install{Source: Source{URL: "https://example.com/exa.git"}}},
+		{Name: "new", Version: "v1"},
+	}
+
+	diff := DiffRegistries(from, to)
+
+	if len(diff.Added) != 1 || diff.Added[0].Name != "new" {
+		t.Errorf("Added = %v, want new", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Name != "gone" {
+		t.Errorf("Removed = %v, want gone", diff.Removed)
+	}
+	if len(diff.Renamed) != 1 || diff.Renamed[0].From.Name != "exa" || diff.Renamed[0].To.Name != "eza" {
+		t.Fatalf("Renamed = %v, want exa → eza, matched by the source", diff.Renamed)
+	}
+	if f := diff.Renamed[0].Fields; len(f) != 1 || f[0].Field != "version" {
+		t.Errorf("rename fields = %v, want the version and not the name", f)
+	}
+
+	if len(diff.Changed) != 2 {
+		t.Fatalf("Changed = %d entries, want bat and fzf", len(diff.Changed))
+	}
+	bat, fzf := diff.Changed[0], diff.Changed[1]
+	if !bat.Bumped() || fzf.Bumped() {
+		t.Errorf("Bumped() = %v, %v; want only bat's version counted", bat.Bumped(), fzf.Bumped())
+	}
+	var fields []string
+	for _, f := range bat.Fields {
+		fields = append(fields, f.Field)
+	}
+	if got := strings.Join(fields, " "); got != "commit install.steps version" {
+		t.Errorf("bat fields = %q, want commit, install.steps and version", got)
+	}
+	if steps := bat.Fields[1]; !steps.Multiline() || !strings.Contains(steps.Diff(), "+- make man") {
+		t.Errorf("install.steps diff = %q, want the added step", steps.Diff())
+	}
+	if f := fzf.Fields; len(f) != 1 || f[0].Field != "description" || f[0].To != "A command-line fuzzy finder" {
+		t.Errorf("fzf fields = %v, want the description", f)
+	}
+
+	if got, want := diff.Summary(), "1 added, 1 removed, 1 renamed, 2 changed (1 bumped)"; got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+
+	if !DiffRegistries(to, to).Empty() {
+		t.Error("a registry differs from itself")
+	}
+}
+
+func TestRefreshKeepsThePreviousSnapshot(t *testing.T) {
+	dir := writeRegistryDir(t, registryFiles)
+	config := testConfig(t)
+	config.Registry.URL = dir
+
+	if _, err := RefreshRegistry(config); err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := DiffSnapshots(config)
+	if err != nil {
+		t.Fatalf("DiffSnapshots() error = %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Previous {
+		t.Fatalf("DiffSnapshots() = %+v, want no previous snapshot after the first fetch", diffs)
+	}
+
+	bat := filepath.Join(dir, "packages", "cli", "bat.yaml")
+	changed := strings.Replace(registryFiles["packages/cli/bat.yaml"], "v0.25.0", "v0.26.0", 1)
+	if err := os.WriteFile(bat, []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RefreshRegistry(config); err != nil {
+		t.Fatal(err)
+	}
+	// A refresh that changes nothing keeps the snapshot from the one that did.
+	if _, err := RefreshRegistry(config); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err = DiffSnapshots(config)
+	if err != nil {
+		t.Fatalf("DiffSnapshots() error = %v", err)
+	}
+	d := diffs[0]
+	if !d.Previous || len(d.Changed) != 1 || d.Changed[0].To.Name != "bat" {
+		t.Fatalf("DiffSnapshots() = %+v, want bat changed", d)
+	}
+	if f := d.Changed[0].Fields; len(f) != 1 || f[0].From != "v0.25.0" || f[0].To != "v0.26.0" {
+		t.Errorf("fields = %v, want the version bump", f)
+	}
+	if len(d.Added)+len(d.Removed)+len(d.Renamed) != 0 {
+		t.Errorf("diff = %+v, want only the change", d.RegistryDiff)
+	}
+
+	// The cache is still the cache: the snapshot sits beside it.
+	packages, err := LoadFromCache(config)
+	if err != nil || FindByName(packages, "bat").Version != "v0.26.0" {
+		t.Errorf("LoadFromCache() = %v, %v; want the refreshed registry", packages, err)
+	}
+}
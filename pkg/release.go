@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Release is a package's prebuilt binaries, installed by the release method
+// instead of building anything.
+//
+// Building a rust or zig project costs minutes and a toolchain on every
+// machine; most of them already publish the result. An entry declares the
+// asset URL once as a template and then one checksum per architecture, since
+// those differ for every file while the naming scheme does not:
+//
+//	release:
+//	  url: https://github.com/sharkdp/bat/releases/download/{version}/bat-{version}-{arch}-unknown-linux-musl.tar.gz
+//	  binaries: [bat-{version}-{arch}-unknown-linux-musl/bat]
+//	  assets:
+//	    x86_64: {sha256: …}
+//	    aarch64: {sha256: …}
+//
+// {version} is the package's version and {arch} the asset's key. Binaries and
+// man pages are paths inside the archive as `tar tf` prints them — unlike an
+// archive source, nothing is stripped, because these paths are copied from a
+// listing rather than written against a clone. A URL that is not an archive is
+// the binary itself, saved under the one name in binaries.
+type Release struct {
+	URL      string                  `yaml:"url,omitempty"`
+	Binaries []string                `yaml:"binaries,omitempty"`
+	Man      []string                `yaml:"man,omitempty"`
+	Assets   map[string]ReleaseAsset `yaml:"assets,omitempty"`
+}
+
+// ReleaseAsset is the download for one architecture. The key it sits under is
+// the machine name as uname -m prints it, which is what clipack looks up.
+type ReleaseAsset struct {
+	// Arch is what {arch} expands to, for a project that spells it otherwise
+	// — amd64, x64. Empty means the key.
+	Arch string `yaml:"arch,omitempty"`
+	// URL replaces the template for an asset named differently from the rest.
+	URL    string `yaml:"url,omitempty"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// Empty reports whether the package publishes no prebuilt binaries.
+func (r Release) Empty() bool {
+	return len(r.Assets) == 0
+}
+
+// Arches lists the architectures the release has an asset for, sorted.
+func (r Release) Arches() []string {
+	arches := make([]string, 0, len(r.Assets))
+	for arch := range r.Assets {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// goArches maps Go's architecture names to the machine names release assets
+// are published under.
+var goArches = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"386":     "i686",
+	"arm":     "armv7",
+	"riscv64": "riscv64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// hostArch is the asset key this machine installs.
+var hostArch = machineArch(runtime.GOARCH)
+
+// HostArch returns the name this machine's release assets are listed under.
+func HostArch() string {
+	return hostArch
+}
+
+func machineArch(goarch string) string {
+	if arch, ok := goArches[goarch]; ok {
+		return arch
+	}
+	return goarch
+}
+
+// resolvedRelease is a release for one architecture with its templates
+// expanded: the download, and what to take out of it.
+type resolvedRelease struct {
+	Archive
+	Binaries []string
+	Man      []string
+}
+
+// resolve expands the release for arch.
+func (r Release) resolve(version, arch string) (resolvedRelease, error) {
+	asset, ok := r.Assets[arch]
+	if !ok {
+		if r.Empty() {
+			return resolvedRelease{}, errors.New("no prebuilt release")
+		}
+		return resolvedRelease{}, fmt.Errorf("no prebuilt release for %s (there are %s)", arch, strings.Join(r.Arches(), ", "))
+	}
+
+	spelled := asset.Arch
+	if spelled == "" {
+		spelled = arch
+	}
+	expand := strings.NewReplacer("{version}", version, "{arch}", spelled).Replace
+
+	out := resolvedRelease{Archive: Archive{URL: r.URL, SHA256: asset.SHA256}}
+	if asset.URL != "" {
+		out.URL = asset.URL
+	}
+	out.URL = expand(out.URL)
+	for _, bin := range r.Binaries {
+		out.Binaries = append(out.Binaries, expand(bin))
+	}
+	for _, page := range r.Man {
+		out.Man = append(out.Man, expand(page))
+	}
+
+	if out.URL == "" {
+		return out, fmt.Errorf("the %s asset has no url, and there is no template", arch)
+	}
+	if len(out.Binaries) == 0 {
+		return out, errors.New("release names no binaries")
+	}
+	if _, err := archiveFormat(out.URL); err != nil && len(out.Binaries) != 1 {
+		return out, fmt.Errorf("%s is not an archive, so it is one binary, but binaries names %d", out.URL, len(out.Binaries))
+	}
+	return out, checkDownload(out.Archive)
+}
+
+// Offers reports whether the registry entry can be installed with method: it
+// has the ref the method pins, for a release, an asset for this machine, and
+// for head, a repository to follow.
+func (p *Package) Offers(method string) bool {
+	switch method {
+	case MethodCommit:
+		return p.Commit != ""
+	case MethodHead:
+		return p.CloneURL() != ""
+	case MethodRelease:
+		_, ok := p.Install.Release.Assets[hostArch]
+		return ok && p.Version != ""
+	}
+	return p.Version != ""
+}
+
+// unpackRelease downloads the release asset for this machine into buildDir
+// and narrows p's install section to what a release provides: the binaries
+// and man pages it names. Resources, desktop entries and configs name paths
+// in a build output, which a release does not have.
+func (in *Installer) unpackRelease(p *Package, buildDir string) error {
+	if p.Version == "" {
+		return fmt.Errorf("%s has no version, so there is no release to install", p.Name)
+	}
+	release, err := p.Install.Release.resolve(p.Version, hostArch)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Name, err)
+	}
+
+	temp, err := in.downloadVerified(p.Name, release.Archive, buildDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		temp.Close()
+		os.Remove(temp.Name())
+	}()
+
+	if format, err := archiveFormat(release.URL); err == nil {
+		if err := unpackArchive(temp, format, buildDir, false); err != nil {
+			return fmt.Errorf("unpacking %s: %w", release.URL, err)
+		}
+	} else {
+		target, err := under(buildDir, release.Binaries[0])
+		if err != nil {
+			return fmt.Errorf("release binary: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := writeArchiveFile(target, temp, 0o755); err != nil {
+			return err
+		}
+	}
+
+	p.Install.Binaries = release.Binaries
+	p.Install.Man = release.Man
+	p.Install.Resources = nil
+	p.Install.Desktop = nil
+	p.Install.Configs = nil
+	return nil
+}
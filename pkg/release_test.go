@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// releasePackage publishes a release for this machine at the given archive,
+// and steps that would fail if they ran.
+func releasePackage(archive Archive, binaries ...string) *Package {
+	return &Package{
+		Name:    "demo",
+		Version: "v1.0.0",
+		Install: Install{
+			Steps:     []string{"false"},
+			Binaries:  []string{"target/release/demo"},
+			Resources: []Resource{{Source: "lib", Target: "lib/demo"}},
+			Release: Release{
+				URL:      archive.URL,
+				Binaries: binaries,
+				Man:      []string{"demo-{version}/demo.1"},
+				Assets:   map[string]ReleaseAsset{hostArch: {SHA256: archive.SHA256}},
+			},
+		},
+	}
+}
+
+func TestInstallFromARelease(t *testing.T) {
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	archive := serveArchive(t, "demo.tar.gz", tarGz(t, []archiveEntry{
+		{Name: "demo-v1.0.0/demo", Content: "#!/bin/sh\necho prebuilt\n"},
+		{Name: "demo-v1.0.0/demo.1", Content: ".TH DEMO 1\n"},
+	}))
+	p := releasePackage(archive, "demo-{version}/demo")
+	if err := in.Install(p, MethodRelease); err != nil {
+		t.Fatalf("Install() error = %v, want the steps skipped", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.Paths.Bin, "demo"))
+	if err != nil || !strings.Contains(string(data), "prebuilt") {
+		t.Errorf("bin/demo = %q, %v; want the binary from the release", data, err)
+	}
+	if !exists(filepath.Join(config.Paths.Man, "man1", "demo.1")) {
+		t.Error("the release's man page was not installed")
+	}
+
+	manifest, err := in.readManifest(in.pathsFor("demo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.InstallMethod != MethodRelease || len(manifest.Install.Resources) != 0 {
+		t.Errorf("manifest = %s, %v; want a release with no resources", manifest.InstallMethod, manifest.Install.Resources)
+	}
+	if !manifest.IsIntact(config) {
+		t.Errorf("MissingArtifacts() = %v, want the release's binary counted", manifest.MissingArtifacts(config))
+	}
+	// The manifest's install section is what the archive held; the hash is of
+	// the registry's, which is what the next refresh is compared with.
+	if NeedsRebuild(releasePackage(archive, "demo-{version}/demo"), manifest) {
+		t.Error("NeedsRebuild() = true straight after a release install")
+	}
+}
+
+func TestInstallFromABareBinaryRelease(t *testing.T) {
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	data := []byte("#!/bin/sh\necho bare\n")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo-linux-"+hostArch), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	archive := Archive{URL: "file://" + dir + "/demo-linux-{arch}", SHA256: hex.EncodeToString(sum[:])}
+
+	if err := in.Install(releasePackage(archive, "demo"), MethodRelease); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(config.Paths.Bin, "demo"))
+	if err != nil || info.Mode().Perm()&0o100 == 0 {
+		t.Errorf("bin/demo = %v, %v; want the download installed executable", info, err)
+	}
+}
+
+func TestInstallRefusesAReleaseThatDoesNotMatchItsChecksum(t *testing.T) {
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	archive := serveArchive(t, "demo.tar.gz", tarGz(t, demoTree))
+	archive.SHA256 = strings.Repeat("0", 64)
+	err := in.Install(releasePackage(archive, "demo"), MethodRelease)
+	if err == nil || !strings.Contains(err.Error(), "does not match its sha256") {
+		t.Fatalf("Install() error = %v, want a checksum mismatch", err)
+	}
+	if exists(filepath.Join(config.Paths.Configs, "demo", "package.yaml")) {
+		t.Error("an unverified release was recorded as installed")
+	}
+}
+
+func TestInstallRefusesAnUnknownMethod(t *testing.T) {
+	in := NewInstaller(testConfig(t), nil)
+	err := in.Install(&Package{Name: "demo", Version: "v1"}, "relase")
+	if err == nil || !strings.Contains(err.Error(), "unknown install method") {
+		t.Errorf("Install() error = %v, want the typo refused", err)
+	}
+}
+
+func TestReleaseResolve(t *testing.T) {
+	release := Release{
+		URL:      "https://example.com/{version}/tool-{version}-{arch}.tar.gz",
+		Binaries: []string{"tool-{version}-{arch}/tool"},
+		Assets: map[string]ReleaseAsset{
+			"x86_64":  {Arch: "amd64", SHA256: strings.Repeat("a", 64)},
+			"aarch64": {URL: "https://example.com/tool-arm.zip", SHA256: strings.Repeat("b", 64)},
+		},
+	}
+
+	got, err := release.resolve("v2", "x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.URL != "https://example.com/v2/tool-v2-amd64.tar.gz" || got.Binaries[0] != "tool-v2-amd64/tool" {
+		t.Errorf("resolve(x86_64) = %+v, want {arch} spelled amd64", got)
+	}
+	if got, _ := release.resolve("v2", "aarch64"); got.URL != "https://example.com/tool-arm.zip" {
+		t.Errorf("resolve(aarch64).URL = %s, want the asset's own URL", got.URL)
+	}
+	if _, err := release.resolve("v2", "riscv64"); err == nil || !strings.Contains(err.Error(), "aarch64, x86_64") {
+		t.Errorf("resolve(riscv64) error = %v, want the available arches listed", err)
+	}
+}
+
+func TestOffers(t *testing.T) {
+	p := &Package{Version: "v1", Install: Install{Release: Release{Assets: map[string]ReleaseAsset{"elsewhere": {}}}}}
+	if !p.Offers(MethodVersion) || p.Offers(MethodCommit) || p.Offers(MethodRelease) {
+		t.Error("Offers() = want version only: no commit, and no asset for this machine")
+	}
+	p.Install.Release.Assets[hostArch] = ReleaseAsset{}
+	if !p.Offers(MethodRelease) {
+		t.Error("Offers(release) = false with an asset for this machine")
+	}
+}
+
+func TestHasUpdateComparesAReleaseByVersion(t *testing.T) {
+	installed := &Package{Name: "demo", Version: "v1", Commit: "abc", InstallMethod: MethodRelease}
+	if HasUpdate(&Package{Name: "demo", Version: "v1", Commit: "def"}, installed) {
+		t.Error("HasUpdate() = true for a new commit, want a release to follow the version")
+	}
+	if !HasUpdate(&Package{Name: "demo", Version: "v2", Commit: "abc"}, installed) {
+		t.Error("HasUpdate() = false for a new version")
+	}
+}
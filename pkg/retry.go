@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/lvim-tech/clipack/cnfg"
+)
+
+// maxAttempts bounds how often one request is sent. A registry read is a
+// handful of requests, and a server that failed four times in a row is down
+// rather than unlucky.
+const maxAttempts = 4
+
+var (
+	// retryBase is the first backoff; each retry doubles it, with jitter so
+	// the parallel raw fetches do not come back in step.
+	retryBase = 500 * time.Millisecond
+	// maxRetryWait is the longest a server's Retry-After or rate limit reset
+	// is waited out. Past it the request fails and says when to try again.
+	maxRetryWait = 30 * time.Second
+	// sleep is replaced in tests, which would otherwise sit out the backoff.
+	sleep = time.Sleep
+)
+
+// RateLimitError is a request the server refused for the rate limit rather
+// than for anything wrong with it. GitHub allows an anonymous client 60 API
+// requests an hour, and a few registry refreshes behind one NAT use that up.
+type RateLimitError struct {
+	// Until is when the limit lifts; zero when the server did not say.
+	Until time.Time
+	// Authenticated is set when the request carried a token.
+	Authenticated bool
+}
+
+func (e *RateLimitError) Error() string {
+	return cnfg.RateLimitHelp(e.Until, e.Authenticated)
+}
+
+// doWithRetry sends req, again after a backoff when it failed in a way the
+// next attempt may not: a connection reset or cut short, a 5xx, a 429. A rate
+// limit that lifts within maxRetryWait is waited out; a later one is a
+// RateLimitError. Whatever the last attempt got is returned as it is.
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	authenticated := req.Header.Get("Authorization") != ""
+
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if !transient(err) || attempt == maxAttempts {
+				return nil, err
+			}
+			wait = backoff(attempt)
+
+		case rateLimited(resp):
+			until, known := rateLimitReset(resp.Header, time.Now())
+			wait = time.Until(until)
+			if !known {
+				wait = backoff(attempt)
+			}
+			if wait > maxRetryWait || attempt == maxAttempts {
+				resp.Body.Close()
+				return nil, &RateLimitError{Until: until, Authenticated: authenticated}
+			}
+
+		case resp.StatusCode >= 500:
+			if attempt == maxAttempts {
+				return resp, nil
+			}
+			wait = backoff(attempt)
+			if until, ok := retryAfter(resp.Header, time.Now()); ok && time.Until(until) <= maxRetryWait {
+				wait = time.Until(until)
+			}
+
+		default:
+			return resp, nil
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		sleep(max(wait, 0))
+	}
+}
+
+// transient reports whether a request error is the connection failing under
+// it, which a new connection may not. A refused connection or a name that
+// does not resolve is the network being absent, and retrying only delays the
+// fallback to the cache.
+func transient(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff is the wait before attempt+1: retryBase doubled per attempt, with
+// up to half of it again added at random.
+func backoff(attempt int) time.Duration {
+	d := retryBase << (attempt - 1)
+	return d + rand.N(d/2+1)
+}
+
+// rateLimited reports whether resp is a refusal for the rate limit. GitHub
+// answers a spent limit with 403 and X-RateLimit-Remaining: 0, which is not
+// to be confused with a 403 for a token without access; other servers use 429.
+func rateLimited(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0"
+}
+
+// rateLimitReset is when a rate limit lifts, from Retry-After or, failing
+// that, X-RateLimit-Reset. known is false when neither header says.
+func rateLimitReset(h http.Header, now time.Time) (until time.Time, known bool) {
+	if until, ok := retryAfter(h, now); ok {
+		return until, true
+	}
+	if secs, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
+
+// retryAfter reads Retry-After, which is either a number of seconds or an
+// HTTP date.
+func retryAfter(h http.Header, now time.Time) (time.Time, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return now.Add(time.Duration(secs) * time.Second), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return at, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lvim-tech/clipack/cnfg"
+)
+
+// noBackoff makes retries immediate for the rest of the test, returning the
+// waits they would have slept.
+func noBackoff(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	old := sleep
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { sleep = old })
+	return &waits
+}
+
+// flaky answers the first failures requests with status, then 200.
+func flaky(t *testing.T, failures int, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(requests.Add(1)) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGetRetriesTransientFailures(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			waits := noBackoff(t)
+			server, requests := flaky(t, 2, status, nil)
+
+			body, err := doGet(server.URL, &cnfg.Config{})
+			if err != nil {
+				t.Fatalf("doGet() error = %v", err)
+			}
+			if string(body) != "ok" || requests.Load() != 3 {
+				t.Errorf("got %q after %d requests, want ok after 3", body, requests.Load())
+			}
+			if len(*waits) != 2 || (*waits)[1] <= (*waits)[0] {
+				t.Errorf("waits = %v, want two, growing", *waits)
+			}
+		})
+	}
+}
+
+func TestGetRetriesAreBounded(t *testing.T) {
+	noBackoff(t)
+	server, requests := flaky(t, 100, http.StatusInternalServerError, nil)
+
+	if _, err := doGet(server.URL, &cnfg.Config{}); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("doGet() error = %v, want the last status", err)
+	}
+	if requests.Load() != maxAttempts {
+		t.Errorf("%d requests, want %d", requests.Load(), maxAttempts)
+	}
+}
+
+func TestGetDoesNotRetryAClientError(t *testing.T) {
+	noBackoff(t)
+	server, requests := flaky(t, 100, http.StatusNotFound, nil)
+
+	if _, err := doGet(server.URL, &cnfg.Config{}); err == nil {
+		t.Fatal("doGet() error = nil")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d requests, want a 404 asked once", requests.Load())
+	}
+}
+
+func TestGetRetriesAConnectionCutShort(t *testing.T) {
+	waits := noBackoff(t)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	body, err := doGet(server.URL, &cnfg.Config{})
+	if err != nil {
+		t.Fatalf("doGet() error = %v", err)
+	}
+	if string(body) != "ok" || len(*waits) == 0 {
+		t.Errorf("got %q after %d retries, want ok after one", body, len(*waits))
+	}
+}
+
+func TestGetWaitsOutAShortRetryAfter(t *testing.T) {
+	waits := noBackoff(t)
+	server, _ := flaky(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"7"}})
+
+	if _, err := doGet(server.URL, &cnfg.Config{}); err != nil {
+		t.Fatalf("doGet() error = %v", err)
+	}
+	if len(*waits) != 1 || (*waits)[0] < 6*time.Second || (*waits)[0] > 7*time.Second {
+		t.Errorf("waits = %v, want the 7s the server asked for", *waits)
+	}
+}
+
+func TestGetReportsARateLimit(t *testing.T) {
+	noBackoff(t)
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	server, requests := flaky(t, 100, http.StatusForbidden, http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(reset.Unix(), 10)},
+	})
+	config := testConfig(t)
+	config.Registry.TarballURL = server.URL
+	config.Registry.RawURL = server.URL
+
+	_, err := FetchRegistry(config)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) {
+		t.Fatalf("FetchRegistry() error = %v, want a RateLimitError", err)
+	}
+	if !limited.Until.Equal(reset) || limited.Authenticated {
+		t.Errorf("got %+v, want anonymous until %v", limited, reset)
+	}
+	if want := "rate limited until " + reset.Format("15:04") + ", set GH_TOKEN"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %q, want it to say %q", err, want)
+	}
+	// An hour is not waited out, and the raw fallback would only spend more
+	// of the same limit.
+	if requests.Load() != 1 {
+		t.Errorf("%d requests, want 1", requests.Load())
+	}
+}
+
+func TestForbiddenWithoutALimitIsNotARateLimit(t *testing.T) {
+	noBackoff(t)
+	server, requests := flaky(t, 100, http.StatusForbidden, http.Header{"X-Ratelimit-Remaining": {"42"}})
+
+	_, err := doGet(server.URL, &cnfg.Config{})
+	var limited *RateLimitError
+	if err == nil || errors.As(err, &limited) {
+		t.Errorf("doGet() error = %v, want a plain 403", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d requests, want 1", requests.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 5, 1, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"120", now.Add(2 * time.Minute), true},
+		{"Fri, 01 May 2026 14:32:00 GMT", now.Add(32 * time.Minute), true},
+		{"", time.Time{}, false},
+		{"soon", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(http.Header{"Retry-After": {tt.value}}, now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
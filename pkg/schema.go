@@ -0,0 +1,284 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaID is where the published schema lives, for the
+// "# yaml-language-server: $schema=" line at the top of a registry file.
+const SchemaID = "https://raw.githubusercontent.com/lvim-tech/clipack/main/schema/package.schema.json"
+
+// Schema is the subset of JSON Schema the package format needs. It is generated
+// from the Go types rather than written by hand, so the struct tags stay the
+// only definition of the format and the schema cannot drift from them.
+type Schema struct {
+	SchemaURI   string             `json:"$schema,omitempty"`
+	ID          string             `json:"$id,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties is false for a struct, whose keys are all known,
+	// and the value schema for a map.
+	AdditionalProperties any     `json:"additionalProperties,omitempty"`
+	Items                *Schema `json:"items,omitempty"`
+}
+
+// manifestOnly are the Package fields clipack writes into an installation's
+// manifest and a registry entry has no business setting. They are left out of
+// the schema so a registry file that sets one is told so.
+var manifestOnly = map[string]bool{
+	"install_method":  true,
+	"exposed":         true,
+	"unexposed":       true,
+	"origin":          true,
+	"resolved_commit": true,
+	"entry_hash":      true,
+	"custom_ref":      true,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// PackageSchema returns the JSON Schema of a registry package file.
+func PackageSchema() *Schema {
+	s := schemaFor(reflect.TypeOf(Package{}))
+	for key := range manifestOnly {
+		delete(s.Properties, key)
+	}
+	s.SchemaURI = "https://json-schema.org/draft/2020-12/schema"
+	s.ID = SchemaID
+	s.Title = "clipack package"
+	s.Description = "A package file in a clipack registry: packages/<category>/<name>.yaml."
+	s.Required = []string{"name"}
+	return s
+}
+
+// PackageSchemaJSON is PackageSchema as the indented JSON that is published
+// and that `clipack schema` prints.
+func PackageSchemaJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(PackageSchema()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// schemaFor maps a Go type onto a schema, reading field names from the yaml
+// tags the decoder uses.
+func schemaFor(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem())}
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "-" {
+				continue
+			}
+			if strings.Contains(opts, "inline") {
+				for key, prop := range schemaFor(field.Type).Properties {
+					s.Properties[key] = prop
+				}
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			s.Properties[name] = schemaFor(field.Type)
+		}
+		return s
+	}
+	return &Schema{}
+}
+
+// SchemaViolation is one place a package file does not match the schema.
+type SchemaViolation struct {
+	File string
+	// Path is the field, e.g. install.binaries[2]; empty for the document.
+	Path    string
+	Line    int
+	Message string
+}
+
+func (v SchemaViolation) Error() string {
+	where := v.File
+	if v.Path != "" {
+		where += ": " + v.Path
+	}
+	if v.Line > 0 {
+		where += fmt.Sprintf(" (line %d)", v.Line)
+	}
+	return where + ": " + v.Message
+}
+
+// SchemaError reports package files that were loaded but do not match the
+// schema — a misspelt key, a field clipack no longer reads. The entries are
+// still served, so unlike a skipped file this does not make a fetch partial.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	msg := fmt.Sprintf("%d schema violation(s), first: %s", len(e.Violations), e.Violations[0].Error())
+	if len(e.Violations) > 1 {
+		msg += " (clipack registry lint lists them all)"
+	}
+	return msg
+}
+
+// ValidateSchema checks a parsed YAML document against a schema and returns
+// every violation, with the field path and line it was found at.
+func ValidateSchema(schema *Schema, file string, doc *yaml.Node) []SchemaViolation {
+	var out []SchemaViolation
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		doc = doc.Content[0]
+	}
+	validateNode(schema, file, "", doc, &out)
+	return out
+}
+
+func validateNode(schema *Schema, file, path string, node *yaml.Node, out *[]SchemaViolation) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	fail := func(format string, args ...any) {
+		*out = append(*out, SchemaViolation{File: file, Path: path, Line: node.Line,
+			Message: fmt.Sprintf(format, args...)})
+	}
+
+	// An explicit null decodes to the zero value of any field, so it is never
+	// a violation.
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		if node.Kind != yaml.MappingNode {
+			fail("expected a mapping, got %s", describeNode(node))
+			return
+		}
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			seen[key.Value] = true
+			child := joinPath(path, key.Value)
+			if prop, ok := schema.Properties[key.Value]; ok {
+				validateNode(prop, file, child, value, out)
+				continue
+			}
+			switch extra := schema.AdditionalProperties.(type) {
+			case *Schema:
+				validateNode(extra, file, child, value, out)
+			default:
+				*out = append(*out, SchemaViolation{File: file, Path: child, Line: key.Line,
+					Message: "unknown field" + suggestField(key.Value, schema.Properties)})
+			}
+		}
+		for _, name := range schema.Required {
+			if !seen[name] {
+				fail("%s is required", name)
+			}
+		}
+	case "array":
+		if node.Kind != yaml.SequenceNode {
+			fail("expected a list, got %s", describeNode(node))
+			return
+		}
+		for i, item := range node.Content {
+			validateNode(schema.Items, file, fmt.Sprintf("%s[%d]", path, i), item, out)
+		}
+	case "string":
+		// The decoder turns any scalar into a string — "version: 1.0" is
+		// the string "1.0" — so only a mapping or a list is wrong here.
+		if node.Kind != yaml.ScalarNode {
+			fail("expected a string, got %s", describeNode(node))
+		}
+	case "boolean":
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			fail("expected true or false, got %s", describeNode(node))
+		}
+	case "integer":
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+			fail("expected a whole number, got %s", describeNode(node))
+		}
+	case "number":
+		if node.Kind != yaml.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") {
+			fail("expected a number, got %s", describeNode(node))
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// describeNode names what a node is, for "expected X, got Y".
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("%q", node.Value)
+	}
+}
+
+// suggestField names the known field an unknown one was probably meant to be:
+// one that starts the same way or contains it, as "binary" does "binaries".
+func suggestField(key string, known map[string]*Schema) string {
+	var names []string
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.HasPrefix(name, key) || strings.HasPrefix(key, name) ||
+			(len(key) > 3 && strings.HasPrefix(name, key[:len(key)-1])) {
+			return fmt.Sprintf(" (did you mean %s?)", name)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestPublishedSchemaIsCurrent keeps schema/package.schema.json in step with
+// the Go types. A field added to Package without regenerating the file fails
+// here rather than in a contributor's editor.
+func TestPublishedSchemaIsCurrent(t *testing.T) {
+	published, err := os.ReadFile("../schema/package.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := PackageSchemaJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(published, generated) {
+		t.Error("schema/package.schema.json is out of date; run: go run . schema > schema/package.schema.json")
+	}
+}
+
+func TestPackageSchemaFollowsTheYAMLTags(t *testing.T) {
+	s := PackageSchema()
+
+	install := s.Properties["install"]
+	if install == nil || install.Properties["additional-config"] == nil {
+		t.Fatal("install.additional-config is missing; the yaml tag, not the Go name, is the key")
+	}
+	if install.Properties["environment"].AdditionalProperties.(*Schema).Type != "string" {
+		t.Error("install.environment should be a map of strings")
+	}
+	// The shared requirements are inlined beside the per-method ones.
+	req := s.Properties["requirements"]
+	for _, key := range []string{"opensuse", "toolchain", "version", "commit"} {
+		if req.Properties[key] == nil {
+			t.Errorf("requirements.%s is missing", key)
+		}
+	}
+	for key := range manifestOnly {
+		if s.Properties[key] != nil {
+			t.Errorf("%s is manifest state and should not be in the registry schema", key)
+		}
+	}
+	if s.Properties["overlay"] != nil {
+		t.Error(`a field tagged yaml:"-" made it into the schema`)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		path string
+		line int
+		want string
+	}{
+		{"unknown field", "name: bat\ninstall:\n  binary: [bat]\n", "install.binary", 3, "did you mean binaries"},
+		{"string for a list", "name: bat\ninstall:\n  binaries: bat\n", "install.binaries", 3, "expected a list"},
+		{"list for a string", "name: [bat]\n", "name", 1, "expected a string"},
+		{"nested list item", "name: bat\ninstall:\n  resources:\n    - source: a\n      targte: b\n",
+			"install.resources[0].targte", 5, "unknown field"},
+		{"manifest field", "name: bat\ninstall_method: commit\n", "install_method", 2, "unknown field"},
+		{"missing name", "version: v1\n", "", 1, "name is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.yaml), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got := ValidateSchema(PackageSchema(), "bat.yaml", &doc)
+			if len(got) != 1 {
+				t.Fatalf("ValidateSchema() = %v, want one violation", got)
+			}
+			v := got[0]
+			if v.Path != tt.path || v.Line != tt.line || !strings.Contains(v.Message, tt.want) {
+				t.Errorf("violation = %+v, want %q at %s line %d", v, tt.want, tt.path, tt.line)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaAcceptsTheRegistryFormat(t *testing.T) {
+	entry := `name: kitty
+version: "0.42"
+commit: abc
+updated_at: 2025-02-24T13:45:00Z
+tags: [terminal]
+requirements:
+  opensuse: [gcc]
+  commit:
+    toolchain: ["go >= 1.24"]
+install:
+  source:
+    url: https://github.com/kovidgoyal/kitty.git
+  environment:
+    CGO_ENABLED: "0"
+  steps: [make]
+  binaries: [kitty/launcher/kitty]
+  resources:
+    - source: lib
+      target: lib/kitty
+  desktop:
+    - source: kitty.desktop
+      env:
+        KITTY_CONFIG_DIRECTORY: ${base}/configs/kitty
+  additional-config:
+    - filename: config.sh
+      content: ~
+post-install:
+  scripts:
+    - filename: k.sh
+      content: echo
+`
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(entry), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got := ValidateSchema(PackageSchema(), "kitty.yaml", &doc); len(got) != 0 {
+		t.Errorf("ValidateSchema() = %v, want a valid entry to pass", got)
+	}
+}
+
+func TestParsePackagesReportsSchemaViolationsAndKeepsTheEntry(t *testing.T) {
+	index := IndexFile{Packages: []string{"packages/cli/bat.yaml"}}
+	files := map[string][]byte{
+		"packages/cli/bat.yaml": []byte("name: bat\ninstall:\n  binary: [bat]\n"),
+	}
+
+	packages, err := parsePackages(index, files)
+	if len(packages) != 1 {
+		t.Fatalf("got %d packages, want the entry loaded despite the unknown key", len(packages))
+	}
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("error = %v, want a *SchemaError", err)
+	}
+	if !strings.Contains(err.Error(), "packages/cli/bat.yaml: install.binary (line 3)") {
+		t.Errorf("error = %v, want the file, field and line", err)
+	}
+}
+
+func TestParsePackagesSaysWhyAnEntryWasSkipped(t *testing.T) {
+	index := IndexFile{Packages: []string{"packages/cli/good.yaml", "packages/cli/bad.yaml"}}
+	files := map[string][]byte{
+		"packages/cli/good.yaml": []byte("name: good\n"),
+		"packages/cli/bad.yaml":  []byte("name: bad\ninstall:\n  binaries: {a: b}\n"),
+	}
+
+	_, err := parsePackages(index, files)
+	if err == nil || !strings.Contains(err.Error(), "packages/cli/bad.yaml: install.binaries") {
+		t.Errorf("error = %v, want the skipped file and the field that broke it", err)
+	}
+}
+
+func TestSchemaViolationsDoNotKeepALayerOutOfTheCache(t *testing.T) {
+	files := map[string]string{
+		"index.yaml":            "packages:\n  - packages/cli/bat.yaml\n",
+		"packages/cli/bat.yaml": "name: bat\nversion: v1\ninstall:\n  source:\n    type: git\n",
+	}
+	config := testConfig(t)
+	config.Registry.URL = "file://" + writeRegistryDir(t, files)
+
+	if _, err := FetchRegistry(config); err == nil {
+		t.Fatal("FetchRegistry() error = nil, want the unknown source.type reported")
+	}
+	if _, err := LoadFromCache(config); err != nil {
+		t.Errorf("LoadFromCache() error = %v, want the complete set cached", err)
+	}
+}
@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScriptChange is one of the fields of an entry that clipack runs as shell —
+// install.steps, install.setup, a post-install script — that an update would
+// run differently from the installed manifest. A version bump is what the
+// update says it is; a changed step is code that was not there when the
+// package was installed, and it runs with the user's privileges.
+type ScriptChange struct {
+	// Field names what changed: "install.steps", "install.setup", or
+	// "post-install.scripts[<filename>]".
+	Field string
+	// Diff is the change as a unified diff, installed side first.
+	Diff string
+}
+
+// ScriptChanges compares the shell an update to registry by method would run
+// with what the installed manifest recorded. Nothing else about the entry
+// counts: a changed description or binary list is not code. A release runs
+// none of the steps, so for one they are not compared.
+func ScriptChanges(registry, installed *Package, method string) []ScriptChange {
+	if registry == nil || installed == nil {
+		return nil
+	}
+
+	var changes []ScriptChange
+	add := func(field string, before, after []string) {
+		if diff := unifiedDiff(before, after, "installed", "registry"); diff != "" {
+			changes = append(changes, ScriptChange{Field: field, Diff: diff})
+		}
+	}
+
+	if method != MethodRelease {
+		add("install.steps", stepLines(installed.Install.Steps), stepLines(registry.Install.Steps))
+	}
+	add("install.setup", splitLines(installed.Install.Setup), splitLines(registry.Install.Setup))
+
+	// Scripts are matched by file name, in the registry's order, followed by
+	// the ones the registry dropped.
+	old := map[string]string{}
+	for _, s := range installed.PostInstall.Scripts {
+		old[s.Filename] = s.Content
+	}
+	seen := map[string]bool{}
+	for _, s := range registry.PostInstall.Scripts {
+		seen[s.Filename] = true
+		add("post-install.scripts["+s.Filename+"]", splitLines(old[s.Filename]), splitLines(s.Content))
+	}
+	for _, s := range installed.PostInstall.Scripts {
+		if !seen[s.Filename] {
+			add("post-install.scripts["+s.Filename+"]", splitLines(s.Content), nil)
+		}
+	}
+	return changes
+}
+
+// stepLines flattens steps into lines; a step written as a block scalar
+// spans several.
+func stepLines(steps []string) []string {
+	var lines []string
+	for _, step := range steps {
+		lines = append(lines, splitLines(step)...)
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffContext is how many unchanged lines a hunk keeps around a change.
+const diffContext = 3
+
+// unifiedDiff renders the change from a to b in the unified format, or ""
+// when there is none. Scripts are short, so a plain longest-common-subsequence
+// table is fast enough and keeps the output the same from run to run.
+func unifiedDiff(a, b []string, fromName, toName string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		op   byte // ' ', '-' or '+'
+		text string
+		// ai and bi are the line's position in a and b, counted from 0: for
+		// a '+' line ai is where it would go in a, and the other way round.
+		ai, bi int
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', a[i], i, j})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	for start := 0; start < len(lines); {
+		// Find the next change, and the hunk around it: every change within
+		// twice the context of the one before joins the same hunk.
+		first := start
+		for first < len(lines) && lines[first].op == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for k := first; k < len(lines) && k-last <= 2*diffContext; k++ {
+			if lines[k].op != ' ' {
+				last = k
+			}
+		}
+		from := max(first-diffContext, start)
+		to := min(last+diffContext+1, len(lines))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		var aCount, bCount int
+		for _, l := range lines[from:to] {
+			if l.op != '+' {
+				aCount++
+			}
+			if l.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(lines[from].ai, aCount), hunkRange(lines[from].bi, bCount))
+		for _, l := range lines[from:to] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// hunkRange is one side of a hunk header: the first line, counted from 1,
+// and the count. An empty side names the line before it, as diff does.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
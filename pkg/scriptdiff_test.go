@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Split("a b c d e f g h i j k l", " ")
+	b := strings.Split("a B c d e f g h i j k l m", " ")
+
+	// What diff -u --label installed --label registry prints for the same.
+	want := `--- installed
++++ registry
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+`
+	if got := unifiedDiff(a, b, "installed", "registry"); got != want {
+		t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff(a, a, "installed", "registry"); got != "" {
+		t.Errorf("unifiedDiff() of equal sides = %q, want nothing", got)
+	}
+	if got := unifiedDiff(nil, []string{"x"}, "installed", "registry"); !strings.Contains(got, "@@ -0,0 +1 @@\n+x\n") {
+		t.Errorf("unifiedDiff() from nothing =\n%s", got)
+	}
+}
+
+func TestScriptChanges(t *testing.T) {
+	installed := &Package{
+		Name:    "demo",
+		Version: "v1.0.0",
+		Install: Install{
+			Steps: []string{"make", "make install"},
+			Setup: "echo ready\n",
+		},
+		PostInstall: PostInstall{Scripts: []Script{
+			{Filename: "hook.sh", Content: "echo hook\n"},
+			{Filename: "gone.sh", Content: "echo gone\n"},
+		}},
+	}
+	registry := &Package{
+		Name:        "demo",
+		Version:     "v1.1.0",
+		Description: "descriptions are not code",
+		Install: Install{
+			Steps: []string{"make", "curl https://example.com/x | sh", "make install"},
+			Setup: "echo ready\n",
+		},
+		PostInstall: PostInstall{Scripts: []Script{{Filename: "hook.sh", Content: "echo hook\n"}}},
+	}
+
+	changes := ScriptChanges(registry, installed, MethodVersion)
+	if len(changes) != 2 {
+		t.Fatalf("ScriptChanges() = %+v, want the steps and the dropped script", changes)
+	}
+	if changes[0].Field != "install.steps" || !strings.Contains(changes[0].Diff, "\n+curl https://example.com/x | sh\n") {
+		t.Errorf("changes[0] = %+v, want the added step", changes[0])
+	}
+	if changes[1].Field != "post-install.scripts[gone.sh]" || !strings.Contains(changes[1].Diff, "\n-echo gone\n") {
+		t.Errorf("changes[1] = %+v, want the dropped script", changes[1])
+	}
+
+	if changes := ScriptChanges(installed, installed, MethodVersion); len(changes) != 0 {
+		t.Errorf("ScriptChanges() of the same entry = %+v, want none", changes)
+	}
+
+	// A release runs the scripts but none of the steps.
+	changes = ScriptChanges(registry, installed, MethodRelease)
+	if len(changes) != 1 || changes[0].Field != "post-install.scripts[gone.sh]" {
+		t.Errorf("ScriptChanges(release) = %+v, want only the dropped script", changes)
+	}
+}
@@ -0,0 +1,422 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ssh"
+)
+
+// A signed registry carries, beside its YAML, a digest list and a detached
+// signature over it. The list names index.yaml and every file the index
+// lists, so the one signature covers them all, and a file changed after
+// signing no longer matches its line.
+const (
+	// DigestsFile is the digest list, in sha256sum's format.
+	DigestsFile = "registry.sha256"
+	// sshSignatureFile is what ssh-keygen -Y sign writes beside the list.
+	sshSignatureFile = DigestsFile + ".sig"
+	// minisignFile is what minisign -S writes beside the list.
+	minisignFile = DigestsFile + ".minisig"
+	// SignatureNamespace is the ssh-keygen -Y namespace a registry is signed
+	// under, so a signature made for anything else does not verify here.
+	SignatureNamespace = "clipack-registry"
+)
+
+// ErrUnsigned is a registry that had to be signed, by a key in
+// registry.trusted_keys, and was not.
+var ErrUnsigned = errors.New("registry is not signed by a key in registry.trusted_keys")
+
+// isRegistryFile reports whether a file belongs to what is read of a
+// registry: its YAML, and the files that sign it.
+func isRegistryFile(name string) bool {
+	switch name {
+	case DigestsFile, sshSignatureFile, minisignFile:
+		return true
+	}
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")
+}
+
+// trustedKey is one entry of registry.trusted_keys: an OpenSSH public key or
+// a minisign one.
+type trustedKey struct {
+	ssh      ssh.PublicKey
+	minisign *minisignKey
+}
+
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// String is the key's fingerprint, which is what a cache records it was
+// verified with.
+func (k trustedKey) String() string {
+	if k.ssh != nil {
+		return ssh.FingerprintSHA256(k.ssh)
+	}
+	return fmt.Sprintf("minisign %016X", binary.LittleEndian.Uint64(k.minisign.id[:]))
+}
+
+// trustedKeys parses registry.trusted_keys. Each entry is a key as it
+// appears in a .pub file, or the path of one.
+func trustedKeys(config *cnfg.Config) ([]trustedKey, error) {
+	var keys []trustedKey
+	for _, entry := range config.Registry.TrustedKeys {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, err := parseTrustedKey(entry)
+		if err != nil {
+			data, readErr := os.ReadFile(cnfg.ExpandPath(entry))
+			if readErr != nil {
+				return nil, fmt.Errorf("registry.trusted_keys: %q is neither a public key nor a readable file", entry)
+			}
+			if key, err = parseTrustedKey(string(data)); err != nil {
+				return nil, fmt.Errorf("registry.trusted_keys: %s: %w", entry, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseTrustedKey reads a public key from the text of a .pub file: an
+// authorized_keys line for ssh, or minisign's two lines, of which the comment
+// is optional.
+func parseTrustedKey(text string) (trustedKey, error) {
+	if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text)); err == nil {
+		return trustedKey{ssh: key}, nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != 42 || string(raw[:2]) != "Ed" {
+			break
+		}
+		key := &minisignKey{key: ed25519.PublicKey(raw[10:])}
+		copy(key.id[:], raw[2:10])
+		return trustedKey{minisign: key}, nil
+	}
+	return trustedKey{}, errors.New("not an OpenSSH or minisign public key")
+}
+
+// verifyLayer checks a registry's files against registry.trusted_keys and
+// returns the key that signed them. With no keys configured nothing is
+// checked, and the signer is empty.
+func verifyLayer(config *cnfg.Config, files map[string][]byte) (string, error) {
+	keys, err := trustedKeys(config)
+	if err != nil || len(keys) == 0 {
+		return "", err
+	}
+	return verifyRegistry(files, keys)
+}
+
+// verifyRegistry checks that the digest list is signed by one of keys and
+// that index.yaml and every file it lists match their line in it. A listed
+// file that was not fetched is not checked here: it is reported as missing,
+// and loads as nothing.
+func verifyRegistry(files map[string][]byte, keys []trustedKey) (string, error) {
+	list, ok := files[DigestsFile]
+	if !ok {
+		return "", fmt.Errorf("%w: it has no %s", ErrUnsigned, DigestsFile)
+	}
+
+	var signer string
+	var err error
+	switch {
+	case files[sshSignatureFile] != nil:
+		signer, err = verifySSHSignature(list, files[sshSignatureFile], keys)
+	case files[minisignFile] != nil:
+		signer, err = verifyMinisign(list, files[minisignFile], keys)
+	default:
+		return "", fmt.Errorf("%w: %s has no signature beside it", ErrUnsigned, DigestsFile)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsigned, err)
+	}
+
+	digests, err := parseDigests(list)
+	if err != nil {
+		return "", err
+	}
+	index, err := parseIndex(files)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range append([]string{"index.yaml"}, index.Packages...) {
+		path = strings.TrimPrefix(path, "/")
+		data, ok := files[path]
+		if !ok {
+			continue
+		}
+		want, ok := digests[path]
+		if !ok {
+			return "", fmt.Errorf("%s is not in the signed %s", path, DigestsFile)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+			return "", fmt.Errorf("%s does not match its digest in the signed %s", path, DigestsFile)
+		}
+	}
+	return signer, nil
+}
+
+// parseDigests reads a digest list: "<sha256>  <path>" per line.
+func parseDigests(data []byte) (map[string]string, error) {
+	digests := map[string]string{}
+	for n, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s line %d: want \"<sha256>  <path>\"", DigestsFile, n+1)
+		}
+		digests[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return digests, nil
+}
+
+// Digests renders the digest list of a registry's files: index.yaml and every
+// file the index lists, sorted by path.
+func Digests(files map[string][]byte) ([]byte, error) {
+	index, err := parseIndex(files)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{"index.yaml"}
+	for _, entry := range index.Packages {
+		path := strings.TrimPrefix(entry, "/")
+		if _, ok := files[path]; !ok {
+			return nil, fmt.Errorf("%s is listed but does not exist", path)
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b bytes.Buffer
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		sum := sha256.Sum256(files[path])
+		fmt.Fprintf(&b, "%s  %s\n", hex.EncodeToString(sum[:]), path)
+	}
+	return b.Bytes(), nil
+}
+
+// verifySSHSignature checks an ssh-keygen -Y signature — the SSHSIG format —
+// over message.
+func verifySSHSignature(message, armored []byte, keys []trustedKey) (string, error) {
+	text := strings.TrimSpace(string(armored))
+	const begin, end = "-----BEGIN SSH SIGNATURE-----", "-----END SSH SIGNATURE-----"
+	if !strings.HasPrefix(text, begin) || !strings.HasSuffix(text, end) {
+		return "", fmt.Errorf("%s is not an SSH signature", sshSignatureFile)
+	}
+	body := strings.Join(strings.Fields(text[len(begin):len(text)-len(end)]), "")
+	blob, err := base64.StdEncoding.DecodeString(body)
+	if err != nil || !bytes.HasPrefix(blob, []byte("SSHSIG")) {
+		return "", fmt.Errorf("%s is not an SSH signature", sshSignatureFile)
+	}
+
+	var sig struct {
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature []byte
+	}
+	if err := ssh.Unmarshal(blob[6:], &sig); err != nil {
+		return "", fmt.Errorf("%s: %w", sshSignatureFile, err)
+	}
+	if sig.Namespace != SignatureNamespace {
+		return "", fmt.Errorf("%s is for %q, not %q", sshSignatureFile, sig.Namespace, SignatureNamespace)
+	}
+
+	signer, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", sshSignatureFile, err)
+	}
+	var trusted *trustedKey
+	for i, k := range keys {
+		if k.ssh != nil && bytes.Equal(k.ssh.Marshal(), signer.Marshal()) {
+			trusted = &keys[i]
+			break
+		}
+	}
+	if trusted == nil {
+		return "", fmt.Errorf("signed with %s, which is not trusted", ssh.FingerprintSHA256(signer))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("%s uses hash %q", sshSignatureFile, sig.HashAlg)
+	}
+	h.Write(message)
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlg, h.Sum(nil)})...)
+
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return "", fmt.Errorf("%s: %w", sshSignatureFile, err)
+	}
+	if err := signer.Verify(signed, &signature); err != nil {
+		return "", fmt.Errorf("%s does not verify: %w", sshSignatureFile, err)
+	}
+	return trusted.String(), nil
+}
+
+// verifyMinisign checks a minisign signature over message: the signature
+// itself, and the global one over it and the trusted comment.
+func verifyMinisign(message, file []byte, keys []trustedKey) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(string(file), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") ||
+		!strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", fmt.Errorf("%s is not a minisign signature", minisignFile)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 74 {
+		return "", fmt.Errorf("%s is not a minisign signature", minisignFile)
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", fmt.Errorf("%s is not a minisign signature", minisignFile)
+	}
+	algorithm, sig := string(raw[:2]), raw[10:]
+
+	var trusted *trustedKey
+	for i, k := range keys {
+		if k.minisign != nil && bytes.Equal(k.minisign.id[:], raw[2:10]) {
+			trusted = &keys[i]
+			break
+		}
+	}
+	if trusted == nil {
+		return "", fmt.Errorf("signed with minisign key %016X, which is not trusted", binary.LittleEndian.Uint64(raw[2:10]))
+	}
+	key := trusted.minisign.key
+
+	// "ED" signs a BLAKE2b digest of the file, which is what minisign does by
+	// default; "Ed" signs the file itself.
+	switch algorithm {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	default:
+		return "", fmt.Errorf("%s uses algorithm %q", minisignFile, algorithm)
+	}
+	if !ed25519.Verify(key, message, sig) {
+		return "", fmt.Errorf("%s does not verify", minisignFile)
+	}
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(key, append(append([]byte{}, sig...), comment...), global) {
+		return "", fmt.Errorf("the trusted comment in %s does not verify", minisignFile)
+	}
+	return trusted.String(), nil
+}
+
+// SignedRegistry is what SignRegistry wrote.
+type SignedRegistry struct {
+	// Files is how many files the digest list covers.
+	Files int
+	// Signature is the path of the signature file.
+	Signature string
+}
+
+// SignRegistry writes the digest list of the registry in dir and signs it
+// with the private key at keyPath: a minisign secret key through minisign,
+// anything else through ssh-keygen -Y sign. Both are run rather than
+// reimplemented, so a key's passphrase is asked for — on the terminal — by
+// the tool that made it.
+// The signature of the other kind is removed, so a stale one cannot be the
+// one that is checked.
+func SignRegistry(dir, keyPath string) (SignedRegistry, error) {
+	files, err := dirRegistry{root: dir}.Files()
+	if err != nil {
+		return SignedRegistry{}, err
+	}
+	list, err := Digests(files)
+	if err != nil {
+		return SignedRegistry{}, err
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return SignedRegistry{}, fmt.Errorf("reading the signing key: %w", err)
+	}
+
+	listPath := filepath.Join(dir, DigestsFile)
+	if err := os.WriteFile(listPath, list, 0o644); err != nil {
+		return SignedRegistry{}, err
+	}
+
+	var cmd *exec.Cmd
+	var signature, stale string
+	if strings.HasPrefix(string(key), "untrusted comment:") {
+		signature, stale = listPath+".minisig", listPath+".sig"
+		cmd = exec.Command("minisign", "-S", "-s", keyPath, "-m", listPath, "-x", signature)
+	} else {
+		signature, stale = listPath+".sig", listPath+".minisig"
+		// ssh-keygen refuses to overwrite an earlier signature.
+		os.Remove(signature)
+		cmd = exec.Command("ssh-keygen", "-Y", "sign", "-q", "-f", keyPath, "-n", SignatureNamespace, listPath)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return SignedRegistry{}, fmt.Errorf("%s: %s", cmd.Args[0], msg)
+		}
+		return SignedRegistry{}, fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+		return SignedRegistry{}, err
+	}
+	return SignedRegistry{Files: bytes.Count(list, []byte("\n")), Signature: signature}, nil
+}
+
+// signedByTrusted reports whether signer — a fingerprint a cache recorded —
+// is one of registry.trusted_keys.
+func signedByTrusted(config *cnfg.Config, signer string) bool {
+	keys, err := trustedKeys(config)
+	if err != nil {
+		return false
+	}
+	for _, k := range keys {
+		if k.String() == signer {
+			return true
+		}
+	}
+	return false
+}
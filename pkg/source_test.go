@@ -0,0 +1,253 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lvim-tech/clipack/cnfg"
+)
+
+// writeRegistryDir lays registryFiles out on disk, the way a checkout of the
+// registry looks, and returns its root.
+func writeRegistryDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// git runs git in dir with an identity set, so commits work on a machine that
+// has none configured.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	full := append([]string{"-c", "user.name=clipack", "-c", "user.email=clipack@example.com",
+		"-c", "init.defaultBranch=main", "-c", "commit.gpgsign=false"}, args...)
+	cmd := exec.Command("git", full...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// requireGit skips a test on a machine without git rather than failing it.
+func requireGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+}
+
+// gitRegistryRepo turns a registry directory into a git repository with one
+// commit on main.
+func gitRegistryRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	requireGit(t)
+
+	root := writeRegistryDir(t, files)
+	git(t, root, "init", "--quiet")
+	git(t, root, "add", "-A")
+	git(t, root, "commit", "--quiet", "-m", "registry")
+	return root
+}
+
+func TestNewRegistryPicksTheImplementation(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/lvim-tech/clipack-registry.git", "pkg.githubRegistry"},
+		{"https://api.github.com/repos/lvim-tech/clipack-registry/contents", "pkg.githubRegistry"},
+		{"file:///srv/registry", "pkg.dirRegistry"},
+		{"/srv/registry", "pkg.dirRegistry"},
+		{"~/src/registry", "pkg.dirRegistry"},
+		{"https://git.example.com/team/registry.git", "pkg.gitRegistry"},
+		{"git@git.example.com:team/registry.git", "pkg.gitRegistry"},
+		{"ssh://git.example.com/team/registry.git", "pkg.gitRegistry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			config := testConfig(t)
+			config.Registry.URL = tt.url
+
+			registry, err := NewRegistry(config, config.RegistryLayers()[0])
+			if err != nil {
+				t.Fatalf("NewRegistry() error = %v", err)
+			}
+			if got := fmt.Sprintf("%T", registry); got != tt.want {
+				t.Errorf("NewRegistry(%q) = %s, want %s", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRegistryFileURLResolvesToThePath(t *testing.T) {
+	config := testConfig(t)
+	config.Registry.URL = "file:///srv/registry"
+
+	registry, err := NewRegistry(config, config.RegistryLayers()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.String() != "/srv/registry" {
+		t.Errorf("registry = %s, want /srv/registry", registry)
+	}
+}
+
+func TestNewRegistryWithoutURL(t *testing.T) {
+	if _, err := NewRegistry(testConfig(t), cnfg.RegistryLayer{}); err != cnfg.ErrNoRegistry {
+		t.Errorf("NewRegistry() error = %v, want ErrNoRegistry", err)
+	}
+}
+
+func TestGitRegistryCheckoutsAreKeyedByURLAndBranch(t *testing.T) {
+	config := testConfig(t)
+	config.Registry.URL = "https://git.example.com/team/registry.git"
+
+	first, _ := NewRegistry(config, config.RegistryLayers()[0])
+	config.Registry.Branch = "develop"
+	second, _ := NewRegistry(config, config.RegistryLayers()[0])
+
+	if first.(gitRegistry).dir == second.(gitRegistry).dir {
+		t.Error("two branches of one remote share a checkout")
+	}
+	if !strings.HasPrefix(first.(gitRegistry).dir, config.Paths.Registry) {
+		t.Errorf("checkout %s is outside the registry directory", first.(gitRegistry).dir)
+	}
+}
+
+func TestFetchRegistryFromDirectory(t *testing.T) {
+	root := writeRegistryDir(t, registryFiles)
+	config := testConfig(t)
+	config.Registry.URL = "file://" + root
+
+	packages, err := FetchRegistry(config)
+	if err != nil {
+		t.Fatalf("FetchRegistry() error = %v", err)
+	}
+	if len(packages) != 2 {
+		t.Fatalf("got %d packages, want 2", len(packages))
+	}
+	if packages[0].Name != "bat" || packages[0].Category != "cli" {
+		t.Errorf("packages[0] = %+v, want bat in cli", packages[0])
+	}
+}
+
+func TestDirRegistrySkipsDotDirectories(t *testing.T) {
+	files := map[string]string{
+		"index.yaml":             registryFiles["index.yaml"],
+		"packages/cli/bat.yaml":  registryFiles["packages/cli/bat.yaml"],
+		".github/workflows.yaml": "name: not a package\n",
+	}
+	got, err := dirRegistry{root: writeRegistryDir(t, files)}.Files()
+	if err != nil {
+		t.Fatalf("Files() error = %v", err)
+	}
+	if _, ok := got[".github/workflows.yaml"]; ok {
+		t.Error("a file under a dot directory was read as part of the registry")
+	}
+	if _, ok := got["packages/cli/bat.yaml"]; !ok {
+		t.Error("packages/cli/bat.yaml was not read")
+	}
+}
+
+func TestDirRegistryWithoutIndex(t *testing.T) {
+	root := writeRegistryDir(t, map[string]string{"packages/cli/bat.yaml": "name: bat\n"})
+	if _, err := (dirRegistry{root: root}).Files(); err == nil {
+		t.Error("Files() error = nil, want a missing index.yaml to be reported")
+	}
+}
+
+func TestDirRegistryMissing(t *testing.T) {
+	if _, err := (dirRegistry{root: filepath.Join(t.TempDir(), "absent")}).Files(); err == nil {
+		t.Error("Files() error = nil, want an error for a directory that does not exist")
+	}
+}
+
+func TestGitRegistryClonesAndFollowsTheBranch(t *testing.T) {
+	remote := gitRegistryRepo(t, registryFiles)
+	config := testConfig(t)
+
+	registry := gitRegistry{url: remote, branch: "main",
+		dir: filepath.Join(config.Paths.Registry, "git", "test")}
+
+	packages, err := packagesFrom(registry)
+	if err != nil {
+		t.Fatalf("first fetch error = %v", err)
+	}
+	if len(packages) != 2 {
+		t.Fatalf("got %d packages, want 2", len(packages))
+	}
+
+	// A new commit upstream is picked up by the next fetch, through the
+	// existing checkout rather than a fresh clone.
+	if err := os.WriteFile(filepath.Join(remote, "packages/cli/bat.yaml"),
+		[]byte("name: bat\nversion: v0.26.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, remote, "commit", "--quiet", "-am", "bump bat")
+
+	packages, err = packagesFrom(registry)
+	if err != nil {
+		t.Fatalf("second fetch error = %v", err)
+	}
+	if p := FindByName(packages, "bat"); p == nil || p.Version != "v0.26.0" {
+		t.Errorf("bat = %+v, want the bumped version", p)
+	}
+
+	snap, err := registry.Snapshot(Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := git(t, remote, "rev-parse", "HEAD"); snap.Commit != want {
+		t.Errorf("snapshot commit = %q, want the remote's tip %q", snap.Commit, want)
+	}
+}
+
+func TestGitRegistryRecoversFromACorruptCheckout(t *testing.T) {
+	remote := gitRegistryRepo(t, registryFiles)
+	dir := filepath.Join(t.TempDir(), "checkout")
+
+	// Something that looks like a checkout but is not one.
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := gitRegistry{url: remote, branch: "main", dir: dir}.Files()
+	if err != nil {
+		t.Fatalf("Files() error = %v, want the checkout to be cloned again", err)
+	}
+	if _, ok := files["index.yaml"]; !ok {
+		t.Error("index.yaml missing after recovery")
+	}
+}
+
+func TestGitRegistryReportsAnUnreachableRemote(t *testing.T) {
+	requireGit(t)
+	registry := gitRegistry{url: filepath.Join(t.TempDir(), "nowhere"), branch: "main",
+		dir: filepath.Join(t.TempDir(), "checkout")}
+
+	_, err := registry.Files()
+	if err == nil {
+		t.Fatal("Files() error = nil, want the failed clone to be reported")
+	}
+	if !strings.Contains(err.Error(), "cloning registry") {
+		t.Errorf("error = %v, want it to say the clone failed", err)
+	}
+}
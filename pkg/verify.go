@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommitMismatchError is a build whose checked-out tree is not the commit
+// the registry named. The clone steps ask git for a ref; nothing made sure
+// that was what the build ran against, and two things break the link without
+// failing a step: a tag moved upstream after the entry was written, and an
+// entry's own step that clones or checks out again.
+type CommitMismatchError struct {
+	Name   string
+	Method string
+	// Ref is what was asked for: the tag, or the pinned commit.
+	Ref string
+	// Want is the commit the tree had to be at; Got is HEAD.
+	Want string
+	Got  string
+	// AfterBuild is set when HEAD was right after the clone and had moved by
+	// the end of the steps.
+	AfterBuild bool
+}
+
+func (e *CommitMismatchError) Error() string {
+	when := "after the clone"
+	if e.AfterBuild {
+		when = "after the build steps"
+	}
+	return fmt.Sprintf("%s: the build tree is at %s %s, but %s %s is %s",
+		e.Name, shortSHA(e.Got), when, e.Method, e.Ref, shortSHA(e.Want))
+}
+
+// hint says which of the two causes it most likely is and what to do.
+func (e *CommitMismatchError) hint() string {
+	if e.Method == MethodVersion && !e.AfterBuild {
+		return fmt.Sprintf("the tag %s points somewhere else than when the registry entry was written — it was moved upstream\n"+
+			"report it against the registry entry; until it is updated, `-m commit` builds the pinned commit", e.Ref)
+	}
+	return "a step in the entry changed what is checked out\n" +
+		"look for a second `git clone` or `git checkout` in its install.steps"
+}
+
+// isCloneStep reports whether a step is one of the steps that fetch the tree:
+// a clone, or the checkout that follows one.
+func isCloneStep(step string) bool {
+	return cloneURLFromStep(step) != "" || strings.HasPrefix(step, "git checkout ")
+}
+
+// pinnedCommit is the commit a clone for method must end up at, and the ref
+// it was asked for under: the pinned commit, or the tag's commit when the
+// registry records one. An empty commit means the registry names none, as it
+// never does for a branch tip.
+func pinnedCommit(p *Package, method string) (ref, commit string) {
+	switch method {
+	case MethodCommit:
+		return p.Commit, p.Commit
+	case MethodHead:
+		return p.HeadBranch(), ""
+	}
+	return p.Version, p.VersionCommit
+}
+
+// verifyCheckout resolves HEAD in buildDir and compares it with what the
+// registry pinned. For a version install without a recorded tag commit, the
+// tag as the clone fetched it stands in, which still catches a later step
+// that moved HEAD away from it. It returns the resolved commit.
+func (in *Installer) verifyCheckout(p *Package, method, buildDir string, afterBuild bool) (string, error) {
+	head, err := gitRevParse(buildDir, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("%s: resolving HEAD in the build directory: %w", p.Name, err)
+	}
+
+	ref, want := pinnedCommit(p, method)
+	if want == "" && method == MethodVersion && ref != "" {
+		// A branch named as the version has no tag to compare against, and
+		// that is not a mismatch.
+		want, _ = gitRevParse(buildDir, "refs/tags/"+ref+"^{commit}")
+	}
+	if want != "" && !sameCommit(head, want) {
+		return head, &CommitMismatchError{Name: p.Name, Method: method, Ref: ref, Want: want, Got: head, AfterBuild: afterBuild}
+	}
+	return head, nil
+}
+
+// sameCommit compares a full SHA with one that may be abbreviated, as a
+// registry entry is free to write it.
+func sameCommit(full, pinned string) bool {
+	pinned = strings.ToLower(strings.TrimSpace(pinned))
+	return pinned != "" && strings.HasPrefix(strings.ToLower(full), pinned)
+}
+
+func gitRevParse(dir, rev string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", fmt.Errorf("%s does not resolve", rev)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
@@ -0,0 +1,242 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// taggedRepo is an upstream with two commits, v1 tagged on the first. It
+// returns the repository and both commits.
+func taggedRepo(t *testing.T) (string, string, string) {
+	t.Helper()
+	requireGit(t)
+
+	root := t.TempDir()
+	git(t, root, "init", "--quiet")
+	if err := os.WriteFile(filepath.Join(root, "tool"), []byte("#!/bin/sh\necho one\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "add", "-A")
+	git(t, root, "commit", "--quiet", "-m", "one")
+	first := git(t, root, "rev-parse", "HEAD")
+	git(t, root, "tag", "v1")
+
+	if err := os.WriteFile(filepath.Join(root, "tool"), []byte("#!/bin/sh\necho two\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, root, "commit", "--quiet", "-am", "two")
+	second := git(t, root, "rev-parse", "HEAD")
+	return root, first, second
+}
+
+func clonedPackage(repo string) *Package {
+	return &Package{
+		Name:    "tool",
+		Version: "v1",
+		Install: Install{
+			Source:   Source{URL: "file://" + repo},
+			Steps:    []string{"git clone " + "file://" + repo + " .", "mkdir -p out && cp tool out/tool"},
+			Binaries: []string{"out/tool"},
+		},
+	}
+}
+
+func TestInstallRecordsTheCommitItBuilt(t *testing.T) {
+	repo, first, second := taggedRepo(t)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	p := clonedPackage(repo)
+	p.VersionCommit = first
+	if err := in.Install(p, MethodVersion); err != nil {
+		t.Fatalf("Install(version) error = %v", err)
+	}
+	manifest, err := in.readManifest(in.pathsFor("tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ResolvedCommit != first {
+		t.Errorf("resolved_commit = %q, want the tag's commit %s", manifest.ResolvedCommit, first)
+	}
+
+	p = clonedPackage(repo)
+	p.Commit = second[:10]
+	if err := in.Update(p, MethodCommit); err != nil {
+		t.Fatalf("Update(commit) error = %v, want an abbreviated pin accepted", err)
+	}
+	if manifest, _ := in.readManifest(in.pathsFor("tool")); manifest.ResolvedCommit != second {
+		t.Errorf("resolved_commit = %q, want the full commit %s", manifest.ResolvedCommit, second)
+	}
+}
+
+func TestInstallAtACustomRef(t *testing.T) {
+	repo, first, second := taggedRepo(t)
+	// Tags that read as commits, and are built as the tags they are.
+	git(t, repo, "tag", "20240101", first)
+	git(t, repo, "tag", "deadbeef", second)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	for _, tt := range []struct {
+		ref, want, method string
+	}{
+		{"main", second, MethodVersion},
+		{first[:10], first, MethodCommit},
+		{"20240101", first, MethodVersion},
+		{"deadbeef", second, MethodVersion},
+	} {
+		p := clonedPackage(repo)
+		pinned, method := p.PinnedTo(tt.ref, in.IsCommit(p, tt.ref))
+		if method != tt.method {
+			t.Errorf("%s pinned by %s, want %s", tt.ref, method, tt.method)
+		}
+		if err := in.Update(pinned, method); err != nil {
+			t.Fatalf("install at %s error = %v", tt.ref, err)
+		}
+		manifest, err := in.readManifest(in.pathsFor("tool"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.CustomRef != tt.ref || manifest.ResolvedCommit != tt.want {
+			t.Errorf("manifest custom_ref %q, resolved_commit %q; want %q at %s",
+				manifest.CustomRef, manifest.ResolvedCommit, tt.ref, tt.want)
+		}
+	}
+}
+
+func TestInstallRefusesATagThatMoved(t *testing.T) {
+	repo, first, second := taggedRepo(t)
+	// Re-tagged upstream after the registry entry recorded where it was.
+	git(t, repo, "tag", "-f", "v1", second)
+
+	config := testConfig(t)
+	rec := &recorder{}
+	in := NewInstaller(config, rec.report)
+
+	p := clonedPackage(repo)
+	p.VersionCommit = first
+	err := in.Install(p, MethodVersion)
+	var mismatch *CommitMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Got != second || mismatch.AfterBuild {
+		t.Fatalf("Install() error = %v, want the moved tag caught after the clone", err)
+	}
+	if hints := rec.texts(EventHint); len(hints) != 1 || !strings.Contains(hints[0], "moved upstream") {
+		t.Errorf("hints = %v, want the tag named as moved", hints)
+	}
+	if exists(filepath.Join(config.Paths.Bin, "tool")) {
+		t.Error("the tree at the moved tag was installed")
+	}
+}
+
+func TestCommitInstallFetchesOnlyThatCommit(t *testing.T) {
+	repo, first, _ := taggedRepo(t)
+	in := NewInstaller(testConfig(t), nil)
+
+	p := clonedPackage(repo)
+	p.Commit = first
+	build := t.TempDir()
+	if _, err := in.runSteps(p, MethodCommit, build); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+	if got := git(t, build, "rev-parse", "--is-shallow-repository"); got != "true" {
+		t.Errorf("is-shallow-repository = %s, want the one commit fetched", got)
+	}
+	if got := git(t, build, "rev-list", "--all", "--count"); got != "1" {
+		t.Errorf("%s commits in the build directory, want 1", got)
+	}
+}
+
+func TestCommitInstallFallsBackToAFullClone(t *testing.T) {
+	repo, _, second := taggedRepo(t)
+	// second is no longer a branch tip, and a protocol v0 server refuses a
+	// commit it does not advertise.
+	if err := os.WriteFile(filepath.Join(repo, "tool"), []byte("#!/bin/sh\necho three\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo, "commit", "--quiet", "-am", "three")
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.version")
+	t.Setenv("GIT_CONFIG_VALUE_0", "0")
+
+	in := NewInstaller(testConfig(t), nil)
+	p := clonedPackage(repo)
+	p.Commit = second
+	build := t.TempDir()
+	if _, err := in.runSteps(p, MethodCommit, build); err != nil {
+		t.Fatalf("runSteps() error = %v, want the full clone to take over", err)
+	}
+	if head, _ := gitRevParse(build, "HEAD"); head != second {
+		t.Errorf("HEAD = %s, want %s", head, second)
+	}
+	if got := git(t, build, "rev-parse", "--is-shallow-repository"); got != "false" {
+		t.Errorf("is-shallow-repository = %s, want the full clone", got)
+	}
+}
+
+func TestInstallChecksOutSubmodules(t *testing.T) {
+	lib, _, _ := taggedRepo(t)
+	repo, _, _ := taggedRepo(t)
+	// A file:// submodule is refused unless allowed outright.
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+	git(t, repo, "submodule", "--quiet", "add", "file://"+lib, "lib")
+	git(t, repo, "commit", "--quiet", "-m", "lib")
+	git(t, repo, "tag", "v2")
+	commit := git(t, repo, "rev-parse", "HEAD")
+
+	for _, tt := range []struct {
+		method, submodules string
+	}{
+		{MethodVersion, SubmodulesShallow},
+		{MethodCommit, SubmodulesFull},
+	} {
+		t.Run(tt.method, func(t *testing.T) {
+			in := NewInstaller(testConfig(t), nil)
+			p := clonedPackage(repo)
+			p.Version, p.Commit = "v2", commit
+			p.Install.Source.Submodules = tt.submodules
+			build := t.TempDir()
+			if _, err := in.runSteps(p, tt.method, build); err != nil {
+				t.Fatalf("runSteps() error = %v", err)
+			}
+			if !exists(filepath.Join(build, "lib", "tool")) {
+				t.Error("the submodule was not checked out")
+			}
+		})
+	}
+}
+
+func TestInstallRefusesAStepThatChecksOutSomethingElse(t *testing.T) {
+	repo, first, second := taggedRepo(t)
+	config := testConfig(t)
+	in := NewInstaller(config, nil)
+
+	p := clonedPackage(repo)
+	p.Commit = first
+	// Fetched first: a commit-pinned build has only the commit it pinned.
+	p.Install.Steps = append(p.Install.Steps, "git fetch --quiet origin "+second+" && git -c advice.detachedHead=false checkout --quiet FETCH_HEAD")
+	err := in.Install(p, MethodCommit)
+	var mismatch *CommitMismatchError
+	if !errors.As(err, &mismatch) || !mismatch.AfterBuild {
+		t.Fatalf("Install() error = %v, want the second checkout caught after the steps", err)
+	}
+}
+
+func TestInstallComparesAVersionWithItsLocalTag(t *testing.T) {
+	repo, _, second := taggedRepo(t)
+	in := NewInstaller(testConfig(t), nil)
+
+	// No version_commit in the entry, so the tag the clone fetched is what
+	// HEAD is held to — and a step that moves off it is still caught.
+	p := clonedPackage(repo)
+	p.Install.Steps = append(p.Install.Steps, "git fetch --quiet origin "+second+" && git checkout --quiet FETCH_HEAD")
+	err := in.Install(p, MethodVersion)
+	var mismatch *CommitMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Install() error = %v, want the checkout away from v1 caught", err)
+	}
+}
@@ -661,3 +661,81 @@ func TestBatchContinuesPastAFailureAndKeepsTheFailedInstall(t *testing.T) {
 		t.Errorf("beta manifest = %v, want v2.0.0", final["beta"])
 	}
 }
+
+// dependencyModel is the sample registry with one more package, ueberzugpp,
+// that bat needs and that is not installed; yazi needs the installed fzf.
+func dependencyModel(t *testing.T) Model {
+	t.Helper()
+
+	m := New(testConfig(t))
+	m = applyMsg(t, m, tea.WindowSizeMsg{Width: 120, Height: 40})
+
+	packages, installed := samplePackages()
+	packages[0].Depends = []string{"ueberzugpp"}
+	packages[2].Depends = []string{"fzf"}
+	installed["yazi"].Depends = []string{"fzf"}
+	packages = append(packages, &pkg.Package{Name: "ueberzugpp", Version: "v2.9.0", Category: "cli"})
+
+	return applyMsg(t, m, registryLoadedMsg{packages: packages, installed: installed})
+}
+
+func TestInstallTakesInMissingDependencies(t *testing.T) {
+	m := dependencyModel(t)
+	m = selectPackage(t, m, "bat")
+	m = applyMsg(t, m, keyMsg("i"))
+
+	if m.screen != screenConfirm {
+		t.Fatalf("screen = %v, want the confirmation (status: %q)", m.screen, m.status)
+	}
+	var order []string
+	for _, entry := range m.pendingBatch {
+		order = append(order, entry.pkg.Name)
+	}
+	if strings.Join(order, " ") != "ueberzugpp bat" {
+		t.Errorf("pendingBatch = %v, want ueberzugpp before bat", order)
+	}
+	if !strings.Contains(m.View(), "1 added as dependencies: ueberzugpp") {
+		t.Error("the confirmation does not say why ueberzugpp is in it")
+	}
+}
+
+func TestInstallLeavesAnInstalledDependencyAlone(t *testing.T) {
+	m := dependencyModel(t)
+	installed := map[string]*pkg.Package{"fzf": m.installed["fzf"]}
+	m = applyMsg(t, m, registryLoadedMsg{packages: m.packages, installed: installed})
+	m = selectPackage(t, m, "yazi")
+	m = applyMsg(t, m, keyMsg("i"))
+
+	if m.screen != screenConfirm || len(m.pendingBatch) != 0 || m.pendingItem.pkg.Name != "yazi" {
+		t.Errorf("screen = %v, batch = %d — want a plain install of yazi", m.screen, len(m.pendingBatch))
+	}
+}
+
+func TestRemoveRefusesAPackageStillNeeded(t *testing.T) {
+	m := dependencyModel(t)
+	m = selectPackage(t, m, "fzf")
+	m = applyMsg(t, m, keyMsg("x"))
+
+	if m.screen == screenConfirm {
+		t.Fatal("removing fzf was offered although yazi needs it")
+	}
+	if !strings.Contains(m.status, "needed by yazi") {
+		t.Errorf("status = %q, want it to name yazi", m.status)
+	}
+}
+
+func TestBatchRemoveTakesDependentsFirst(t *testing.T) {
+	m := dependencyModel(t)
+	m.tab = tabInstalled
+	m.applyTab()
+	m = applyMsg(t, m, keyMsg("a"))
+	m = applyMsg(t, m, keyMsg("x"))
+
+	var order []string
+	for _, entry := range m.pendingBatch {
+		order = append(order, entry.pkg.Name)
+	}
+	if strings.Join(order, " ") != "yazi fzf" {
+		t.Errorf("pendingBatch = %v, want yazi removed before fzf", order)
+	}
+}
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"errors"
 	"sort"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -23,6 +24,12 @@ type registryLoadedMsg struct {
 	refreshed bool
 }
 
+// registryDiffMsg carries what the last refresh changed in each registry.
+type registryDiffMsg struct {
+	diffs []pkg.SnapshotDiff
+	err   error
+}
+
 // opEventsMsg carries a batch of progress events. Events are batched because a
 // compile can emit thousands of lines: delivering them one Bubble Tea round
 // trip at a time would re-render the whole log for every single line.
@@ -62,10 +69,9 @@ func loadRegistryCmd(config *cnfg.Config, refresh bool) tea.Cmd {
 			err      error
 		)
 
+		// A refresh leaves the cache in place, so a registry that cannot be
+		// reached falls back to it rather than to nothing.
 		if refresh {
-			if cerr := pkg.ClearCache(config); cerr != nil {
-				return registryLoadedMsg{err: cerr, refreshed: true}
-			}
 			packages, err = pkg.RefreshRegistry(config)
 		} else {
 			packages, err = pkg.LoadAllPackagesFromRegistry(config)
@@ -74,6 +80,12 @@ func loadRegistryCmd(config *cnfg.Config, refresh bool) tea.Cmd {
 		// A partial result is still usable: show it and surface the problem as
 		// a warning rather than throwing the whole list away.
 		if len(packages) == 0 {
+			// A rate limit is answered with what to do about it, as the CLI
+			// does, rather than with the request that hit it.
+			var limited *pkg.RateLimitError
+			if errors.As(err, &limited) {
+				err = errors.New(cnfg.RateLimitHelp(limited.Until, limited.Authenticated))
+			}
 			return registryLoadedMsg{err: err, refreshed: refresh}
 		}
 
@@ -81,6 +93,12 @@ func loadRegistryCmd(config *cnfg.Config, refresh bool) tea.Cmd {
 		if ierr != nil {
 			return registryLoadedMsg{packages: packages, err: ierr, refreshed: refresh}
 		}
+		// Where the followed branches are is asked here, off the UI thread,
+		// and again on r however recently it was. A remote that cannot be
+		// reached only costs its own packages their update badge.
+		if herr := pkg.CheckHeads(config, installed, refresh); herr != nil {
+			err = errors.Join(err, herr)
+		}
 
 		sort.SliceStable(packages, func(i, j int) bool {
 			if packages[i].Category != packages[j].Category {
@@ -98,6 +116,15 @@ func loadRegistryCmd(config *cnfg.Config, refresh bool) tea.Cmd {
 	}
 }
 
+// loadRegistryDiffCmd compares the cached registry with the snapshot the last
+// refresh replaced. It reads two cache files and fetches nothing.
+func loadRegistryDiffCmd(config *cnfg.Config) tea.Cmd {
+	return func() tea.Msg {
+		diffs, err := pkg.DiffSnapshots(config)
+		return registryDiffMsg{diffs: diffs, err: err}
+	}
+}
+
 // opStream carries progress events from a running operation to the UI.
 type opStream struct {
 	events chan pkg.Event
@@ -44,6 +44,8 @@ func renderDetail(entry packageItem, method string, width int, s Styles) string
 
 	row("Description", p.Description)
 	row("Category", p.Category)
+	row("Registry", p.Origin)
+	row("Local edit", p.Overlay)
 	row("Maintainer", p.Maintainer)
 	row("License", p.License)
 	row("Homepage", p.Homepage)
@@ -54,6 +56,9 @@ func renderDetail(entry packageItem, method string, width int, s Styles) string
 	if len(p.Tags) > 0 {
 		row("Tags", strings.Join(p.Tags, ", "))
 	}
+	row("Depends on", strings.Join(p.Depends, ", "))
+	row("Builds with", strings.Join(p.BuildDepends, ", "))
+	row("Prebuilt for", strings.Join(p.Install.Release.Arches(), ", "))
 	// Which ref this package is pinned to locally. It is per package: the
 	// header's method is only the default a fresh install starts from.
 	//
@@ -62,6 +67,7 @@ func renderDetail(entry packageItem, method string, width int, s Styles) string
 	// confirmation appears.
 	if entry.installed != nil {
 		row("Install method", installedRefMethod(entry))
+		row("Built from", shortCommit(entry.installed.ResolvedCommit))
 	} else if method != "" {
 		row("Installs from", fmt.Sprintf("%s: %s", method, p.Ref(method)))
 	}
@@ -177,13 +183,29 @@ func renderStatus(entry packageItem, s Styles) string {
 	line := s.OK.Render("Installed") +
 		s.Muted.Render(fmt.Sprintf("  %s: %s", method, installedRef)) + "\n"
 
+	// Built from a ref the user chose, so whatever the registry says now is
+	// not an update to it. Going back is the command line's: the pin was made
+	// there too.
+	if ref := entry.installed.CustomRef; ref != "" {
+		registry := entry.pkg.Version
+		if registry == "" {
+			registry = shortCommit(entry.pkg.Commit)
+		}
+		return line + s.Warn.Render("Pinned to custom ref") +
+			s.Muted.Render(fmt.Sprintf("  %s, the registry is at %s — `clipack update %s` returns to it",
+				ref, registry, entry.pkg.Name)) + "\n"
+	}
+
 	if entry.hasUpdate() {
-		available := entry.pkg.Ref(method)
+		available := pkg.UpdateRef(entry.pkg, entry.installed, method)
 		if method == pkg.MethodCommit {
 			available = shortCommit(available)
 		}
 		line += s.BadgeUpdate.Render("Update available") +
 			s.Muted.Render("  → "+available) + "\n"
+	} else if entry.needsRebuild() {
+		line += s.Warn.Render("Rebuild recommended") +
+			s.Muted.Render("  the registry entry changed, the ref did not — u to rebuild") + "\n"
 	}
 
 	return line
@@ -92,6 +92,15 @@ func TestRenderDetailOmitsEmptyFields(t *testing.T) {
 	}
 }
 
+func TestRenderDetailNamesTheRegistry(t *testing.T) {
+	entry := packageItem{pkg: &pkg.Package{Name: "tool", Origin: "team"}}
+
+	out := renderDetail(entry, pkg.MethodVersion, 60, DefaultStyles())
+	if !strings.Contains(out, "Registry") || !strings.Contains(out, "team") {
+		t.Errorf("renderDetail() does not say which registry the entry came from:\n%s", out)
+	}
+}
+
 func TestRenderDetailWrapsToWidth(t *testing.T) {
 	entry := packageItem{pkg: &pkg.Package{
 		Name:        "verbose",
@@ -327,3 +336,21 @@ func TestRenderDetailOmitsExposedForAPackageWithout(t *testing.T) {
 		t.Error("renderDetail() shows an Exposed section for a package that exposes nothing")
 	}
 }
+
+func TestRenderDetailShowsALocalEdit(t *testing.T) {
+	entry := packageItem{pkg: &pkg.Package{Name: "bat", Origin: pkg.OverlayOrigin, Overlay: "/cfg/packages/cli/bat.yaml"}}
+
+	out := renderDetail(entry, pkg.MethodVersion, 80, DefaultStyles())
+	if !strings.Contains(out, "Local edit") || !strings.Contains(out, "bat.yaml") {
+		t.Errorf("renderDetail() does not say the entry is edited locally:\n%s", out)
+	}
+}
+
+func TestRenderDetailShowsDependencies(t *testing.T) {
+	entry := packageItem{pkg: &pkg.Package{Name: "yazi", Depends: []string{"ueberzugpp"}, BuildDepends: []string{"cmake"}}}
+
+	out := renderDetail(entry, pkg.MethodVersion, 80, DefaultStyles())
+	if !strings.Contains(out, "ueberzugpp") || !strings.Contains(out, "cmake") {
+		t.Errorf("renderDetail() does not list the dependencies:\n%s", out)
+	}
+}
@@ -0,0 +1,131 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/lvim-tech/clipack/pkg"
+)
+
+// handleRegistryDiff opens the diff screen on what the last refresh changed.
+// An error — nothing fetched yet — stays on the browse screen as a status.
+func (m Model) handleRegistryDiff(msg registryDiffMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.status = "Registry diff: " + msg.err.Error()
+		return m, nil
+	}
+	m.diffView.SetContent(m.renderSnapshotDiffs(msg.diffs))
+	m.diffView.GotoTop()
+	m.screen = screenDiff
+	return m, nil
+}
+
+// updateDiff scrolls the diff; esc, q and d go back to the list.
+func (m Model) updateDiff(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if keyMsg, ok := msg.(tea.KeyMsg); ok {
+		switch keyMsg.String() {
+		case "esc", "q", "d":
+			m.screen = screenBrowse
+			return m, nil
+		}
+	}
+	var cmd tea.Cmd
+	m.diffView, cmd = m.diffView.Update(msg)
+	return m, cmd
+}
+
+func (m Model) viewDiff() string {
+	body := m.styles.Pane.
+		Width(m.diffView.Width + 2).
+		Height(m.diffView.Height).
+		Render(m.diffView.View())
+
+	return m.stickyFrame(m.diffHeader(), body, m.diffFooter())
+}
+
+func (m Model) diffHeader() string {
+	return lipgloss.JoinVertical(lipgloss.Left,
+		m.clipStyle().Render(lipgloss.JoinHorizontal(lipgloss.Center,
+			m.styles.Title.Render(" clipack "), "  ", "What the last registry refresh changed")),
+		"",
+	)
+}
+
+func (m Model) diffFooter() string {
+	return m.clipStyle().Render(m.hint("↑/↓ scroll", "esc back to list"))
+}
+
+// renderSnapshotDiffs lays out every registry's changes the way `clipack
+// registry diff` prints them, with the markers coloured: + added, - removed,
+// > renamed, ~ changed.
+func (m Model) renderSnapshotDiffs(diffs []pkg.SnapshotDiff) string {
+	s := m.styles
+	var lines []string
+	for i, d := range diffs {
+		if len(diffs) > 1 {
+			if i > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, s.DetailKey.Render(d.Layer))
+		}
+		if !d.Previous {
+			lines = append(lines, s.Muted.Render("No previous snapshot: the registry has not changed since it was first fetched."))
+			continue
+		}
+		lines = append(lines, s.Muted.Render(fmt.Sprintf("Since the snapshot fetched %s: %s",
+			d.From.LastUpdated.Format("2006-01-02 15:04"), d.Summary())))
+		if d.Empty() {
+			continue
+		}
+		lines = append(lines, "")
+
+		name := lipgloss.NewStyle().Width(18)
+		for _, p := range d.Added {
+			lines = append(lines, s.OK.Render("+ ")+name.Render(p.Name)+s.Muted.Render(p.Version))
+		}
+		for _, p := range d.Removed {
+			lines = append(lines, s.Err.Render("- ")+name.Render(p.Name)+s.Muted.Render(p.Version))
+		}
+		for _, c := range d.Renamed {
+			lines = append(lines, s.Warn.Render("> ")+name.Render(c.To.Name)+s.Muted.Render("renamed from "+c.From.Name))
+			lines = append(lines, m.fieldChangeLines(c.Fields)...)
+		}
+		for _, c := range d.Changed {
+			ref := ""
+			if c.Bumped() {
+				ref = c.From.Version + " → " + c.To.Version
+				if c.From.Version == c.To.Version {
+					ref = "commit " + shortCommit(c.From.Commit) + " → " + shortCommit(c.To.Commit)
+				}
+			}
+			lines = append(lines, s.BadgeUpdate.Render("~ ")+name.Render(c.To.Name)+ref)
+			lines = append(lines, m.fieldChangeLines(c.Fields)...)
+		}
+	}
+
+	// Wrapped to the pane, like the build log: a long description would
+	// otherwise run under the border.
+	width := max(m.diffView.Width, 10)
+	return lipgloss.NewStyle().Width(width).Render(strings.Join(lines, "\n"))
+}
+
+// fieldChangeLines renders the fields of one change, a multi-line value as a
+// coloured diff.
+func (m Model) fieldChangeLines(fields []pkg.FieldChange) []string {
+	s := m.styles
+	var lines []string
+	for _, f := range fields {
+		if !f.Multiline() {
+			lines = append(lines, "    "+s.DetailKey.Render(f.Field)+" "+
+				s.Err.Render(fmt.Sprintf("%q", f.From))+s.Muted.Render(" → ")+s.OK.Render(fmt.Sprintf("%q", f.To)))
+			continue
+		}
+		lines = append(lines, "    "+s.DetailKey.Render(f.Field))
+		for _, line := range strings.Split(strings.TrimRight(f.Diff(), "\n"), "\n") {
+			lines = append(lines, "      "+m.diffLine(line))
+		}
+	}
+	return lines
+}
@@ -7,6 +7,7 @@
 //	browse  - package list on the left, package details on the right
 //	confirm - a modal asking to confirm install / update / remove
 //	run     - a streaming log of a running operation
+//	diff    - what the last registry refresh changed
 //
 // All package operations are delegated to pkg.Installer, which reports progress
 // as pkg.Event values. Those events are pushed onto a channel and pulled into
@@ -45,6 +46,9 @@ type keyMap struct {
 	Reinstall key.Binding
 	Remove    key.Binding
 	Refresh   key.Binding
+	// Diff shows what the last refresh changed, which is what to read after
+	// r and before updating everything it offers.
+	Diff key.Binding
 	// Method acts on the selected package, MethodGlobal on the default a fresh
 	// install starts from. They used to be one key whose meaning changed with
 	// the tab, which left no way to choose a method for a single package that
@@ -145,6 +149,10 @@ func defaultKeys() keyMap {
 			key.WithKeys("r"),
 			key.WithHelp("r", "refresh"),
 		),
+		Diff: key.NewBinding(
+			key.WithKeys("d"),
+			key.WithHelp("d", "registry diff"),
+		),
 		Method: key.NewBinding(
 			key.WithKeys("m"),
 			key.WithHelp("m", "version/commit"),
@@ -199,7 +207,7 @@ func (k keyMap) ShortHelp() []key.Binding {
 		k.Check, k.CheckAll,
 		k.Install, k.Update, k.Reinstall, k.Remove,
 		k.Visual, k.Yank,
-		k.Filter, k.Category, k.Refresh, k.Method, k.MethodGlobal,
+		k.Filter, k.Category, k.Refresh, k.Diff, k.Method, k.MethodGlobal,
 		k.Path,
 		k.Help, k.Quit,
 	}
@@ -209,7 +217,7 @@ func (k keyMap) ShortHelp() []key.Binding {
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down, k.FocusLeft, k.FocusRight},
-		{k.Tab, k.ShiftTab, k.Filter, k.Refresh},
+		{k.Tab, k.ShiftTab, k.Filter, k.Refresh, k.Diff},
 		{k.Category, k.CategoryBack},
 		{k.Check, k.CheckAll, k.Method, k.MethodGlobal, k.Path},
 		{k.Install, k.Update, k.Reinstall, k.Remove},
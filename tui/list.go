@@ -33,6 +33,12 @@ func (i packageItem) hasUpdate() bool {
 	return pkg.HasUpdate(i.pkg, i.installed)
 }
 
+// needsRebuild reports whether the registry entry of an installed package
+// changed while its ref did not.
+func (i packageItem) needsRebuild() bool {
+	return pkg.NeedsRebuild(i.pkg, i.installed)
+}
+
 // requirements is what this package needs for the method it will be built with:
 // the one it is installed under when it is on disk, so an update shows what its
 // own rebuild needs rather than what the other ref would.
@@ -146,12 +152,25 @@ func renderEntry(entry packageItem, width int, st entryState, s Styles) []string
 		badge = " " + s.Err.Render(s.Icons.Error+" broken")
 	case entry.hasUpdate():
 		badge = " " + s.BadgeUpdate.Render(s.Icons.Update)
+	case entry.needsRebuild():
+		// Spelled out rather than an icon: it must not read as the update
+		// badge, which it shares the Updates tab with.
+		badge = " " + s.Warn.Render("rebuild")
+	case entry.installed != nil && entry.installed.CustomRef != "":
+		badge = " " + s.BadgeInstalled.Render(s.Icons.Installed) + s.Muted.Render(" pinned")
 	case entry.installed != nil:
 		badge = " " + s.BadgeInstalled.Render(s.Icons.Installed)
 	}
 
+	// An entry from the overlay directory is not the registry's, which is
+	// worth seeing in the list before it surprises anyone at install time.
+	local := ""
+	if entry.pkg.Overlay != "" {
+		local = s.Muted.Render(" " + pkg.OverlayOrigin)
+	}
+
 	title := marker + box + nameStyle.Render(entry.pkg.Name) +
-		s.Muted.Render(" "+entry.pkg.Version) + badge
+		s.Muted.Render(" "+entry.pkg.Version) + local + badge
 
 	// MaxWidth clips without wrapping and is ANSI-aware, so a long name or an
 	// unusually wide badge cannot silently add a line the caller did not count.
@@ -413,7 +432,9 @@ func buildItems(packages []*pkg.Package, installed map[string]*pkg.Package, brok
 				continue
 			}
 		case tabUpdates:
-			if !entry.hasUpdate() {
+			// A recommended rebuild is listed here too: it is the same
+			// action, and the badge tells the two apart.
+			if !entry.hasUpdate() && !entry.needsRebuild() {
 				continue
 			}
 		}
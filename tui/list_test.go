@@ -237,6 +237,19 @@ func TestRenderEntryHeightFollowsTheDescription(t *testing.T) {
 	}
 }
 
+func TestRenderEntryMarksALocalOverride(t *testing.T) {
+	s := DefaultStyles()
+	entry := packageItem{pkg: &pkg.Package{Name: "bat", Version: "v1", Overlay: "/home/u/bat.yaml"}}
+
+	if title := renderEntry(entry, 60, entryState{}, s)[0]; !strings.Contains(title, pkg.OverlayOrigin) {
+		t.Errorf("title %q does not say the entry is local", title)
+	}
+	entry.pkg.Overlay = ""
+	if title := renderEntry(entry, 60, entryState{}, s)[0]; strings.Contains(title, pkg.OverlayOrigin) {
+		t.Errorf("title %q marks a registry entry as local", title)
+	}
+}
+
 func TestRenderEntryNeverExceedsTheWidth(t *testing.T) {
 	s := DefaultStyles()
 	entry := packageItem{pkg: &pkg.Package{
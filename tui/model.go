@@ -3,6 +3,7 @@ package tui
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -26,6 +27,7 @@ const (
 	screenBrowse
 	screenConfirm
 	screenRun
+	screenDiff
 )
 
 // focusArea identifies which pane of the browse screen has the keyboard.
@@ -107,12 +109,32 @@ func (a action) past() string {
 	}
 }
 
-// otherMethod is the install method a package is not currently pinned to.
-func otherMethod(method string) string {
-	if method == pkg.MethodCommit {
-		return pkg.MethodVersion
+// nextMethod is the install method after method that p offers, in the order
+// of pkg.Methods: what m switches a package to. A package without a release
+// for this machine or a repository to follow keeps toggling between version
+// and commit, as it did before there were more. With p nil every method
+// counts, which is how M cycles the default. method itself comes back when
+// there is nothing else.
+func nextMethod(p *pkg.Package, method string) string {
+	i := slices.Index(pkg.Methods, method)
+	for step := 1; step < len(pkg.Methods); step++ {
+		next := pkg.Methods[(i+step+len(pkg.Methods))%len(pkg.Methods)]
+		if p == nil || p.Offers(next) {
+			return next
+		}
+	}
+	return method
+}
+
+// noOtherMethod says why m has nothing to switch p to.
+func noOtherMethod(p *pkg.Package, method string) string {
+	var others []string
+	for _, other := range pkg.Methods {
+		if other != method {
+			others = append(others, other)
+		}
 	}
-	return pkg.MethodCommit
+	return fmt.Sprintf("%s has no %s in the registry", p.Name, strings.Join(others, " or "))
 }
 
 // Model is the root Bubble Tea model for clipack.
@@ -128,8 +150,10 @@ type Model struct {
 	list    list.Model
 	detail  viewport.Model
 	logView viewport.Model
-	spinner spinner.Model
-	input   textinput.Model
+	// diffView holds the registry diff while its screen is open.
+	diffView viewport.Model
+	spinner  spinner.Model
+	input    textinput.Model
 
 	// Screen state.
 	screen screen
@@ -144,7 +168,10 @@ type Model struct {
 	// than while drawing: the list re-renders on every keystroke, and this
 	// costs a stat per binary.
 	broken map[string]bool
-	method string
+	// offline is set while the list comes from a cache the last refresh could
+	// not replace, so the header can say how old it is.
+	offline *pkg.OfflineError
+	method  string
 
 	// checked holds the packages picked for a batch operation, keyed by name so
 	// the marks survive scrolling and re-sorting. lastFilter is what the filter
@@ -172,6 +199,9 @@ type Model struct {
 	pendingItem    packageItem
 	pendingBatch   []packageItem
 	pendingSkipped []string
+	// pendingAdded names the packages an install took into the batch because
+	// something in it depends on them.
+	pendingAdded []string
 	// pendingMethod is the method a switch will repin to. Empty for every other
 	// action, which use the global toggle or the manifest.
 	pendingMethod string
@@ -290,6 +320,7 @@ func NewWithStyles(config *cnfg.Config, styles Styles, themeErr error) Model {
 		list:          newPackageList(styles),
 		detail:        viewport.New(0, 0),
 		logView:       viewport.New(0, 0),
+		diffView:      viewport.New(0, 0),
 		spinner:       sp,
 		input:         in,
 		method:        pkg.MethodVersion,
@@ -359,6 +390,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case registryLoadedMsg:
 		return relayoutIfNeeded(m.handleRegistryLoaded(msg))
 
+	case registryDiffMsg:
+		return relayoutIfNeeded(m.handleRegistryDiff(msg))
+
 	case opEventsMsg:
 		return relayoutIfNeeded(m.handleOpEvents(msg))
 
@@ -382,6 +416,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return relayoutIfNeeded(m.updateConfirm(msg))
 	case screenRun:
 		return relayoutIfNeeded(m.updateRun(msg))
+	case screenDiff:
+		return m.updateDiff(msg)
 	}
 
 	return m, nil
@@ -488,6 +524,13 @@ func (m *Model) layout() {
 
 	m.logView.Width = available - paneFrameWidth
 	m.logView.Height = logHeight
+
+	diffHeight := m.height -
+		lipgloss.Height(m.diffHeader()) -
+		lipgloss.Height(m.diffFooter()) -
+		paneFrameHeight
+	m.diffView.Width = available - paneFrameWidth
+	m.diffView.Height = max(diffHeight, 4)
 }
 
 // ---------------------------------------------------------------------------
@@ -581,6 +624,11 @@ func (m Model) handleShellPath(msg shellPathMsg) (tea.Model, tea.Cmd) {
 // Registry loading
 // ---------------------------------------------------------------------------
 
+// isOffline reports whether offline mode is on for this run.
+func (m Model) isOffline() bool {
+	return m.config != nil && m.config.Offline
+}
+
 // handleRegistryLoaded installs a fetched package set into the browse screen.
 func (m Model) handleRegistryLoaded(msg registryLoadedMsg) (tea.Model, tea.Cmd) {
 	if len(msg.packages) == 0 {
@@ -589,14 +637,26 @@ func (m Model) handleRegistryLoaded(msg registryLoadedMsg) (tea.Model, tea.Cmd)
 		return m, nil
 	}
 
+	// What the refresh changed is worth a line of its own: until it was said,
+	// the only way to find out was to compare the list with memory.
+	changed := ""
+	if msg.refreshed && len(m.packages) > 0 {
+		if diff := pkg.DiffRegistries(m.packages, msg.packages); !diff.Empty() {
+			changed = diff.Summary()
+		}
+	}
+
 	m.packages = msg.packages
 	m.installed = msg.installed
 	m.refreshBroken()
+	m.offline = pkg.Offline(msg.warn)
 	m.err = nil
 
 	switch {
 	case msg.warn != nil:
 		m.status = "Loaded with warnings: " + msg.warn.Error()
+	case msg.refreshed && changed != "":
+		m.status = fmt.Sprintf("Registry refreshed — %s, d to review", changed)
 	case msg.refreshed:
 		m.status = fmt.Sprintf("Registry refreshed — %d packages", len(msg.packages))
 	default:
@@ -868,7 +928,7 @@ func (m Model) contextualKeys() keyMap {
 
 	// A package offers install, or update and remove — never both sets.
 	keys.Install.SetEnabled(ok && !installed)
-	keys.Update.SetEnabled(installed && entry.hasUpdate())
+	keys.Update.SetEnabled(installed && (entry.hasUpdate() || entry.needsRebuild()))
 	keys.Remove.SetEnabled(installed)
 	// Offered for anything installed, including what is already current: a
 	// registry entry can change without its version moving, and that is the case
@@ -907,11 +967,11 @@ func (m Model) contextualKeys() keyMap {
 	keys.Method.SetEnabled(ok)
 	switch {
 	case installed:
-		keys.Method.SetHelp("m", "switch to "+otherMethod(installedMethod(entry, m.method)))
+		keys.Method.SetHelp("m", "switch to "+nextMethod(entry.pkg, installedMethod(entry, m.method)))
 	case ok:
-		keys.Method.SetHelp("m", "install as "+otherMethod(m.methodOf(entry.pkg.Name)))
+		keys.Method.SetHelp("m", "install as "+nextMethod(entry.pkg, m.methodOf(entry.pkg.Name)))
 	}
-	keys.MethodGlobal.SetHelp("M", "global: "+otherMethod(m.method))
+	keys.MethodGlobal.SetHelp("M", "global: "+nextMethod(nil, m.method))
 
 	// The label names the active category, so the collapsed help doubles as
 	// the indicator of where you are in the cycle.
@@ -926,6 +986,9 @@ func (m Model) contextualKeys() keyMap {
 	keys.Yank.SetEnabled(inDetail)
 	keys.Filter.SetEnabled(!inDetail)
 
+	// Offline there is nothing to refresh from.
+	keys.Refresh.SetEnabled(!m.isOffline())
+
 	// Offered only while there is something to fix, and named after the shell
 	// it will write to: "p add to zsh" says which of your shells this is about,
 	// which matters precisely because the answer differs per shell.
@@ -1091,7 +1154,7 @@ func (m Model) updateBrowse(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// existing per-package choice is left alone: it was made
 			// deliberately, and having it follow the default would make the two
 			// keys the same key again.
-			m.method = otherMethod(m.method)
+			m.method = nextMethod(nil, m.method)
 			m.status = "Global install method: " + m.method
 			return m, nil
 
@@ -1113,10 +1176,17 @@ func (m Model) updateBrowse(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 
 		case key.Matches(keyMsg, m.keys.Refresh):
+			if m.isOffline() {
+				m.status = "Offline: the registry cannot be refreshed"
+				return m, nil
+			}
 			m.screen = screenLoading
 			m.status = "Refreshing registry…"
 			return m, tea.Batch(m.spinner.Tick, loadRegistryCmd(m.config, true))
 
+		case key.Matches(keyMsg, m.keys.Diff):
+			return m, loadRegistryDiffCmd(m.config)
+
 		case key.Matches(keyMsg, m.keys.Install):
 			return m.requestAction(actionInstall)
 
@@ -1352,6 +1422,23 @@ func (m Model) requestAction(a action) (tea.Model, tea.Cmd) {
 			m.status = entry.pkg.Name + " is installed — u to update, R to rebuild, x to remove"
 			return m, nil
 		}
+		// A package with dependencies still to install is a batch of its
+		// own, so the confirmation names everything that will be built.
+		if len(entry.pkg.Dependencies()) > 0 {
+			batch, added, _, err := m.orderBatch(a, []packageItem{entry})
+			if err != nil {
+				m.status = err.Error()
+				return m, nil
+			}
+			if len(added) > 0 {
+				m.pending = a
+				m.pendingBatch = batch
+				m.pendingSkipped = nil
+				m.pendingAdded = added
+				m.screen = screenConfirm
+				return m, nil
+			}
+		}
 	case actionReinstall:
 		if entry.installed == nil {
 			m.status = entry.pkg.Name + " is not installed — i to install"
@@ -1362,7 +1449,7 @@ func (m Model) requestAction(a action) (tea.Model, tea.Cmd) {
 			m.status = entry.pkg.Name + " is not installed — i to install"
 			return m, nil
 		}
-		if !entry.hasUpdate() {
+		if !entry.hasUpdate() && !entry.needsRebuild() {
 			m.status = entry.pkg.Name + " is already up to date"
 			return m, nil
 		}
@@ -1371,12 +1458,18 @@ func (m Model) requestAction(a action) (tea.Model, tea.Cmd) {
 			m.status = entry.pkg.Name + " is not installed"
 			return m, nil
 		}
+		if users, _ := pkg.Dependents(m.installed, entry.pkg.Name); len(users) > 0 {
+			m.status = fmt.Sprintf("%s is needed by %s — mark them to remove together",
+				entry.pkg.Name, strings.Join(users, ", "))
+			return m, nil
+		}
 	}
 
 	m.pending = a
 	m.pendingItem = entry
 	m.pendingBatch = nil
 	m.pendingSkipped = nil
+	m.pendingAdded = nil
 	m.screen = screenConfirm
 	return m, nil
 }
@@ -1395,9 +1488,10 @@ func (m Model) requestSwitchMethod() (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
-	target := otherMethod(installedMethod(entry, m.method))
-	if entry.pkg.Ref(target) == "" {
-		m.status = fmt.Sprintf("%s has no %s in the registry", entry.pkg.Name, target)
+	current := installedMethod(entry, m.method)
+	target := nextMethod(entry.pkg, current)
+	if target == current {
+		m.status = noOtherMethod(entry.pkg, current)
 		return m, nil
 	}
 
@@ -1406,6 +1500,7 @@ func (m Model) requestSwitchMethod() (tea.Model, tea.Cmd) {
 	m.pendingMethod = target
 	m.pendingBatch = nil
 	m.pendingSkipped = nil
+	m.pendingAdded = nil
 	m.screen = screenConfirm
 	return m, nil
 }
@@ -1428,6 +1523,13 @@ func (m Model) requestBatch(a action) (tea.Model, tea.Cmd) {
 		skipped = append(skipped, entry.pkg.Name)
 	}
 
+	batch, added, held, err := m.orderBatch(a, batch)
+	if err != nil {
+		m.status = err.Error()
+		return m, nil
+	}
+	skipped = append(skipped, held...)
+
 	if len(batch) == 0 {
 		m.status = fmt.Sprintf("Nothing to %s among the marked packages",
 			strings.ToLower(a.label()))
@@ -1437,17 +1539,89 @@ func (m Model) requestBatch(a action) (tea.Model, tea.Cmd) {
 	m.pending = a
 	m.pendingBatch = batch
 	m.pendingSkipped = skipped
+	m.pendingAdded = added
 	m.screen = screenConfirm
 	return m, nil
 }
 
+// orderBatch puts a batch in the order its dependencies call for: what a
+// package needs comes before it, except for a removal, which runs the other
+// way round.
+//
+// An install also takes in the dependencies that are not installed yet, whose
+// names come back as added. A removal holds back the packages something
+// installed outside the batch still needs, described for the skipped list.
+func (m Model) orderBatch(a action, batch []packageItem) (ordered []packageItem, added, held []string, err error) {
+	items := make(map[string]packageItem, len(batch))
+	var set []*pkg.Package
+	for _, entry := range batch {
+		items[entry.pkg.Name] = entry
+		set = append(set, entry.pkg)
+	}
+
+	var sorted []*pkg.Package
+	switch a {
+	case actionInstall:
+		names := make([]string, 0, len(set))
+		for _, p := range set {
+			names = append(names, p.Name)
+		}
+		sorted, err = pkg.ResolveOrder(m.packages, names)
+	case actionRemove:
+		for _, entry := range batch {
+			if users, _ := pkg.Dependents(m.installed, entry.pkg.Name); !allIn(users, items) {
+				held = append(held, fmt.Sprintf("%s (needed by %s)", entry.pkg.Name, strings.Join(users, ", ")))
+				delete(items, entry.pkg.Name)
+			}
+		}
+		set = set[:0]
+		for _, entry := range batch {
+			if _, ok := items[entry.pkg.Name]; ok {
+				set = append(set, entry.pkg)
+			}
+		}
+		sorted, err = pkg.SortByDependencies(set)
+		slices.Reverse(sorted)
+	default:
+		sorted, err = pkg.SortByDependencies(set)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, p := range sorted {
+		entry, ok := items[p.Name]
+		if !ok {
+			// Pulled in by the install. One that is installed and whole is
+			// already what the batch needs.
+			if m.installed[p.Name] != nil && !m.broken[p.Name] {
+				continue
+			}
+			entry = packageItem{pkg: p, installed: m.installed[p.Name], broken: m.broken[p.Name]}
+			added = append(added, p.Name)
+		}
+		ordered = append(ordered, entry)
+	}
+	return ordered, added, held, nil
+}
+
+// allIn reports whether every name is a key of items.
+func allIn(names []string, items map[string]packageItem) bool {
+	for _, name := range names {
+		if _, ok := items[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // eligible reports whether an action can run on a package.
 func eligible(entry packageItem, a action) bool {
 	switch a {
 	case actionInstall:
 		return entry.installed == nil
 	case actionUpdate:
-		return entry.installed != nil && entry.hasUpdate()
+		return entry.installed != nil && (entry.hasUpdate() || entry.needsRebuild())
 	case actionRemove:
 		return entry.installed != nil
 	case actionReinstall:
@@ -1504,6 +1678,7 @@ func (m Model) startOperation() (tea.Model, tea.Cmd) {
 
 	op := func(in *pkg.Installer) error {
 		var errs []error
+		failed := map[string]bool{}
 
 		for _, entry := range batch {
 			if len(batch) > 1 {
@@ -1511,21 +1686,31 @@ func (m Model) startOperation() (tea.Model, tea.Cmd) {
 			}
 
 			var err error
-			switch act {
-			case actionInstall:
+			// The batch is in dependency order, so a failure has already been
+			// seen by the time something that needed it comes up.
+			for _, dep := range entry.pkg.Dependencies() {
+				if failed[dep] && act != actionRemove {
+					err = fmt.Errorf("skipped: %s failed", dep)
+					break
+				}
+			}
+
+			switch {
+			case err != nil:
+			case act == actionInstall:
 				// The installer stamps the method onto the package it is given,
 				// so it gets a copy rather than the cached registry entry.
 				err = in.Install(clonePackage(entry.pkg), methods[entry.pkg.Name])
-			case actionUpdate, actionReinstall:
+			case act == actionUpdate, act == actionReinstall:
 				// Update is the rebuild. A reinstall differs only in that the ref
 				// it rebuilds to is the one already installed, so nothing here
 				// has to tell them apart.
 				err = in.Update(clonePackage(entry.pkg), installedMethod(entry, methods[entry.pkg.Name]))
-			case actionRemove:
+			case act == actionRemove:
 				// Remove works from the installed manifest, the only record of
 				// what was actually put on disk.
 				err = in.Remove(entry.installed)
-			case actionSwitchMethod:
+			case act == actionSwitchMethod:
 				// Update is the rebuild: it clears what the current pin put on
 				// disk and installs again. The only difference here is the
 				// method, which comes from the switch rather than the manifest.
@@ -1544,6 +1729,7 @@ func (m Model) startOperation() (tea.Model, tea.Cmd) {
 					in.Report(pkg.Event{Kind: pkg.EventError, Text: entry.pkg.Name + ": " + err.Error()})
 				}
 				errs = append(errs, fmt.Errorf("%s: %w", entry.pkg.Name, err))
+				failed[entry.pkg.Name] = true
 			}
 		}
 
@@ -1563,6 +1749,7 @@ func (m Model) startOperation() (tea.Model, tea.Cmd) {
 	m.pending = actionNone
 	m.pendingBatch = nil
 	m.pendingSkipped = nil
+	m.pendingAdded = nil
 	m.pendingMethod = ""
 	m.clearChecks()
 	m.screen = screenRun
@@ -1590,7 +1777,11 @@ func (m Model) toggleMethod() (tea.Model, tea.Cmd) {
 	}
 
 	name := entry.pkg.Name
-	chosen := otherMethod(m.methodOf(name))
+	chosen := nextMethod(entry.pkg, m.methodOf(name))
+	if chosen == m.methodOf(name) {
+		m.status = noOtherMethod(entry.pkg, chosen)
+		return m, nil
+	}
 	if chosen == m.method {
 		// Back to the default: dropping the entry rather than storing a value
 		// equal to the default is what makes the package follow M again.
@@ -1707,9 +1898,13 @@ func (m Model) handleOpFinished(msg opFinishedMsg) (tea.Model, tea.Cmd) {
 		m.syncLog()
 	}
 
-	// Refresh the installed set so badges reflect what just happened.
+	// Refresh the installed set so badges reflect what just happened. The
+	// followed branches' tips come from the cache rather than the remote: a
+	// head build recorded the tip it built there, which the listing's own
+	// copy predates.
 	installed, err := pkg.InstalledMap(m.config)
 	if err == nil {
+		pkg.CachedHeads(m.config, installed)
 		m.installed = installed
 		m.refreshBroken()
 		m.applyTab()
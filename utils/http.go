@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Network is how clipack's HTTP requests leave the machine. The zero value is
+// Go's default: the proxy from HTTPS_PROXY and friends, and the system's CA
+// roots. A network that forces traffic through its own proxy, and re-signs it
+// with a private CA, needs both said explicitly.
+type Network struct {
+	// Proxy is the proxy URL every request goes through, overriding the
+	// environment.
+	Proxy string
+	// CABundle is a PEM file of certificates trusted on top of the system's.
+	CABundle string
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[Network]*http.Client{}
+)
+
+// HTTPClient returns the client for n. One is kept per network for the life of
+// the process, so the many requests a registry sync makes reuse connections.
+func HTTPClient(n Network) (*http.Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if client, ok := clients[n]; ok {
+		return client, nil
+	}
+	transport, err := n.transport()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 60 * time.Second, Transport: transport}
+	clients[n] = client
+	return client, nil
+}
+
+func (n Network) transport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 32
+	transport.MaxIdleConnsPerHost = 8
+	transport.IdleConnTimeout = 60 * time.Second
+
+	if n.Proxy != "" {
+		proxy, err := url.Parse(n.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("proxy %q is not a URL", n.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if n.CABundle != "" {
+		pem, err := os.ReadFile(n.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		// Added to the system roots rather than replacing them: the private
+		// CA covers the mirror, and everything else is still signed the
+		// usual way.
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("CA bundle " + n.CABundle + " holds no PEM certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	return transport, nil
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// caBundle writes the certificate a TLS test server presents to a PEM file,
+// standing in for a private CA.
+func caBundle(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNetworkTrustsTheCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "behind a private CA")
+	}))
+	defer server.Close()
+
+	if _, err := DownloadContent(server.URL + "/hook.sh"); err == nil {
+		t.Fatal("DownloadContent() trusted a certificate no CA in the system signed")
+	}
+
+	got, err := Network{CABundle: caBundle(t, server)}.DownloadContent(server.URL + "/hook.sh")
+	if err != nil {
+		t.Fatalf("DownloadContent() with the bundle error = %v", err)
+	}
+	if string(got) != "behind a private CA" {
+		t.Errorf("DownloadContent() = %q", got)
+	}
+}
+
+func TestNetworkGoesThroughTheProxy(t *testing.T) {
+	var asked string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A proxy is sent the absolute URL it is to fetch.
+		asked = r.URL.String()
+		fmt.Fprint(w, "via the proxy")
+	}))
+	defer proxy.Close()
+
+	got, err := Network{Proxy: proxy.URL}.DownloadContent("http://config.example.invalid/env.sh")
+	if err != nil {
+		t.Fatalf("DownloadContent() error = %v", err)
+	}
+	if string(got) != "via the proxy" || asked != "http://config.example.invalid/env.sh" {
+		t.Errorf("got %q for %q, want the request sent to the proxy", got, asked)
+	}
+}
+
+func TestHTTPClientRejectsABadNetwork(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		n    Network
+		want string
+	}{
+		{"missing bundle", Network{CABundle: filepath.Join(t.TempDir(), "absent.pem")}, "reading CA bundle"},
+		{"bundle without certificates", Network{CABundle: notPEM}, "no PEM certificates"},
+		{"proxy that is not a URL", Network{Proxy: "proxy:3128"}, "not a URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := HTTPClient(tt.n); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("HTTPClient() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
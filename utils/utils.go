@@ -9,13 +9,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"time"
 )
 
-// downloadClient is reused so repeated additional-config downloads share
-// connections instead of opening a new one per file.
-var downloadClient = &http.Client{Timeout: 60 * time.Second}
-
 // AskForConfirmation prompts on stdin until it gets a yes/no answer.
 // EOF (piped input, closed stdin) is treated as "no" rather than looping.
 func AskForConfirmation(s string) bool {
@@ -54,10 +49,19 @@ const maxDownloadBytes = 8 << 20 // 8 MiB
 
 // DownloadContent fetches a URL, rewriting GitHub blob links to raw links.
 func DownloadContent(url string) ([]byte, error) {
+	return Network{}.DownloadContent(url)
+}
+
+// DownloadContent is DownloadContent through n.
+func (n Network) DownloadContent(url string) ([]byte, error) {
 	url = strings.Replace(url, "github.com", "raw.githubusercontent.com", 1)
 	url = strings.Replace(url, "/blob/", "/", 1)
 
-	resp, err := downloadClient.Get(url)
+	client, err := HTTPClient(n)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download content: %w", err)
 	}